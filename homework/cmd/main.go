@@ -1,35 +1,195 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"homework/config"
 	"homework/controllers"
+	"homework/models"
 	"homework/repositories"
 	"homework/services"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
-
-	addr, check := os.LookupEnv("ADDRESS")
-	if !check{
-		addr = "127.0.0.1"
+	cfg, opts, err := config.Load(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if opts.PrintConfig {
+		if err := cfg.Redacted().Write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	port, check:= os.LookupEnv("PORT")
-	if !check{
-		port = "8080"
+	keys := make([]services.APIKey, 0, len(cfg.Auth.APIKeys))
+	for _, key := range cfg.Auth.APIKeys {
+		role := models.Role(key.Role)
+		if role == "" {
+			role = models.RoleViewer
+		}
+		keys = append(keys, services.APIKey{Name: key.Name, Key: key.Key, Role: role})
 	}
-	repo := repositories.NewDeviceService()
-	service := services.NewService(repo)
+	auth := services.NewAuthenticator(keys, cfg.Auth.TokenSecret)
+	if opts.IssueToken != "" {
+		now := time.Now()
+		token, err := auth.SignToken(models.TokenClaims{
+			Subject:   opts.IssueToken,
+			Role:      models.Role(opts.TokenRole),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(opts.TokenTTL).Unix(),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(token)
+		return
+	}
+	if !auth.Enabled() {
+		log.Print("No API key or token secret configured, authentication is off")
+	}
+
+	srv := &server{shutdownTimeout: time.Duration(cfg.Server.ShutdownTimeout)}
+
+	repo, err := newRepository(cfg.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bus := services.NewEventBus(cfg.Events.History)
+	service := services.Publish(services.NewService(repo), bus)
 	handler := controllers.NewHandler(service)
-	http.HandleFunc("/get", handler.GetDeviceInfo)
-	http.HandleFunc("/create", handler.CreateDevice)
-	http.HandleFunc("/update", handler.UpdateDevice)
-	http.HandleFunc("/delete", handler.RemoveDevice)
-	log.Printf("Starting server on %s:%s", addr, port)
-	err := http.ListenAndServe(fmt.Sprintf("%s:%s", addr, port), nil)
+	if auth.Enabled() {
+		handler.EnforceRoles()
+	}
+	auditLog, err := openAuditLog(cfg.Storage.AuditPath)
+	if err != nil {
+		log.Fatalf("opening the audit log: %v", err)
+	}
+	handler.Audit(auditLog)
+	if auditLog, ok := auditLog.(*repositories.FileAuditLog); ok {
+		srv.onShutdown("audit log", func(context.Context) error {
+			return auditLog.Close()
+		})
+	}
+
+	webhooks := services.NewWebhookDispatcher(&http.Client{}, services.DefaultRetryPolicy)
+	for _, webhook := range cfg.Events.Webhooks {
+		if _, err := webhooks.Add(models.Webhook{URL: webhook.URL, Secret: webhook.Secret, Types: webhook.Types}); err != nil {
+			log.Fatalf("webhook %s: %v", webhook.URL, err)
+		}
+	}
+	bus.OnPublish(webhooks.Deliver)
+	srv.onShutdown("webhooks", func(context.Context) error {
+		webhooks.Close()
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/", controllers.NewRouter(handler))
+	mux.Handle("/events", controllers.NewEventsRouter(controllers.NewEventsHandler(bus)))
+	webhookRouter := adminOnly(auth, controllers.NewWebhookRouter(controllers.NewWebhookHandler(webhooks)))
+	mux.Handle("/webhooks", webhookRouter)
+	mux.Handle("/webhooks/", webhookRouter)
+
+	switch repo := repo.(type) {
+	case *repositories.RepoDevice:
+		if cfg.Storage.SnapshotPath == "" {
+			break
+		}
+		snapshotter := repositories.NewSnapshotter(repo, cfg.Storage.SnapshotPath, time.Duration(cfg.Storage.SnapshotInterval))
+		info, err := snapshotter.Load()
+		if err != nil {
+			log.Fatalf("loading snapshot: %v", err)
+		}
+		log.Printf("Loaded %d devices from %s", info.Devices, cfg.Storage.SnapshotPath)
+		snapshotter.Start()
+		srv.onShutdown("snapshots", func(context.Context) error {
+			return snapshotter.Stop()
+		})
+		mux.Handle("/admin/", adminOnly(auth, controllers.NewAdminRouter(controllers.NewAdminHandler(snapshotter))))
+	case *repositories.WALRepo:
+		srv.onShutdown("write-ahead log", func(context.Context) error {
+			return repo.Close()
+		})
+		mux.Handle("/admin/", adminOnly(auth, controllers.NewAdminRouter(controllers.NewAdminHandler(repo.Snapshotter()))))
+	case *repositories.SQLRepo:
+		srv.onShutdown("database", func(context.Context) error {
+			return repo.Close()
+		})
+	}
+
+	if retention := time.Duration(cfg.Storage.TrashRetention); retention > 0 {
+		purger := services.NewTrashPurger(services.Audit(service, auditLog), retention)
+		purger.Start()
+		srv.onShutdown("trash purger", func(context.Context) error {
+			purger.Stop()
+			return nil
+		})
+	}
+
+	srv.http = &http.Server{
+		Handler:           controllers.RequestID(controllers.Authenticate(mux, auth, cfg.Auth.AnonymousReads)),
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+	}
+	// Shutdown waits for the event streams, they end with the bus.
+	srv.http.RegisterOnShutdown(bus.Close)
+	addr := net.JoinHostPort(cfg.Server.Address, strconv.Itoa(cfg.Server.Port))
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Starting server on %s with %s storage", addr, cfg.Storage.Backend)
+	if err := srv.run(ctx, ln); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Server stopped")
+}
+
+// adminOnly keeps next to admins once there are principals.
+func adminOnly(auth *services.Authenticator, next http.Handler) http.Handler {
+	if !auth.Enabled() {
+		return next
+	}
+	return controllers.RequireRole(next, models.RoleAdmin)
+}
+
+func openAuditLog(path string) (services.AuditLog, error) {
+	if path == "" {
+		return repositories.NewMemoryAuditLog(), nil
+	}
+	return repositories.OpenFileAuditLog(path)
+}
+
+func newRepository(cfg config.StorageConfig) (repositories.Repository, error) {
+	switch cfg.Backend {
+	case config.BackendMemory:
+		return repositories.NewRepoDevice(), nil
+	case config.BackendWAL:
+		return repositories.OpenWALRepo(cfg.WALPath, cfg.SnapshotPath, cfg.WALCompactSize)
+	case config.BackendSQLite:
+		return repositories.OpenSQLRepo("sqlite3", cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unknown storage %q, want memory, wal or sqlite", cfg.Backend)
+	}
 }
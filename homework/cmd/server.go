@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// server runs the HTTP server until its context ends, then drains the
+// in-flight requests and runs the shutdown hooks, all within
+// shutdownTimeout.
+type server struct {
+	http            *http.Server
+	shutdownTimeout time.Duration
+	hooks           []shutdownHook
+}
+
+// shutdownHook lets a backend flush its state once no request runs anymore.
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// onShutdown registers a hook, the hooks run in the reverse order.
+func (s *server) onShutdown(name string, fn func(ctx context.Context) error) {
+	s.hooks = append(s.hooks, shutdownHook{name: name, fn: fn})
+}
+
+func (s *server) run(ctx context.Context, ln net.Listener) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- s.http.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	if err == nil {
+		log.Printf("Shutting down, draining requests for up to %s", s.shutdownTimeout)
+		if serr := s.http.Shutdown(shutdownCtx); serr != nil {
+			log.Printf("Requests still running after %s, closing their connections", s.shutdownTimeout)
+			_ = s.http.Close()
+		}
+		if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+			err = serveErr
+		}
+	}
+
+	for i := len(s.hooks) - 1; i >= 0; i-- {
+		hook := s.hooks[i]
+		if herr := hook.fn(shutdownCtx); herr != nil {
+			log.Printf("shutdown of %s: %v", hook.name, herr)
+			err = errors.Join(err, herr)
+		}
+	}
+	return err
+}
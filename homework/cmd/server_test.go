@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler http.Handler, shutdownTimeout time.Duration) (*server, string, context.CancelFunc, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &server{http: &http.Server{Handler: handler}, shutdownTimeout: shutdownTimeout}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.run(ctx, ln)
+	}()
+	return srv, "http://" + ln.Addr().String(), cancel, done
+}
+
+func TestServerDrainsRequestsBeforeHooks(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	srv, url, cancel, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		record("request")
+	}), time.Second)
+	srv.onShutdown("first", func(context.Context) error { record("first"); return nil })
+	srv.onShutdown("second", func(context.Context) error { record("second"); return nil })
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	<-started
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, http.StatusOK, <-status)
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"request", "second", "first"}, events)
+
+	_, err := http.Get(url)
+	assert.Error(t, err, "the listener is closed")
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+
+	srv, url, cancel, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}), 50*time.Millisecond)
+	hookErr := errors.New("flush failed")
+	hookRan := false
+	srv.onShutdown("backend", func(ctx context.Context) error {
+		hookRan = true
+		return hookErr
+	})
+
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, hookErr)
+		assert.True(t, hookRan)
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not respect its deadline")
+	}
+}
@@ -0,0 +1,432 @@
+// Package config loads the settings of the server. Every setting has a
+// default which the config file, then the environment, then the command-line
+// flags override.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server  ServerConfig  `yaml:"server" json:"server"`
+	Storage StorageConfig `yaml:"storage" json:"storage"`
+	Auth    AuthConfig    `yaml:"auth" json:"auth"`
+	Events  EventsConfig  `yaml:"events" json:"events"`
+}
+
+type ServerConfig struct {
+	Address string `yaml:"address" json:"address"`
+	Port    int    `yaml:"port" json:"port"`
+	// Slow clients must send their headers within ReadHeaderTimeout and the
+	// whole request within ReadTimeout; WriteTimeout also bounds exports.
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" json:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout" json:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout" json:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout" json:"idle_timeout"`
+	ShutdownTimeout   Duration `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+}
+
+const (
+	BackendMemory = "memory"
+	BackendWAL    = "wal"
+	BackendSQLite = "sqlite"
+)
+
+type StorageConfig struct {
+	Backend string `yaml:"backend" json:"backend"`
+	// DSN may hold credentials, it is redacted by --print-config.
+	DSN     string `yaml:"dsn" json:"dsn"`
+	WALPath string `yaml:"wal_path" json:"wal_path"`
+	// An empty SnapshotPath turns the snapshots of the memory backend off,
+	// the wal backend needs one to compact its log into.
+	SnapshotPath     string   `yaml:"snapshot_path" json:"snapshot_path"`
+	SnapshotInterval Duration `yaml:"snapshot_interval" json:"snapshot_interval"`
+	WALCompactSize   int64    `yaml:"wal_compact_size" json:"wal_compact_size"`
+	// AuditPath is the file of the audit trail, the trail is kept in memory
+	// only when it is empty.
+	AuditPath string `yaml:"audit_path" json:"audit_path"`
+	// TrashRetention is how long deleted devices can be restored, 0 keeps
+	// them forever.
+	TrashRetention Duration `yaml:"trash_retention" json:"trash_retention"`
+}
+
+// AuthConfig holds the credentials accepted by the server, authentication is
+// off while there is none.
+type AuthConfig struct {
+	APIKeys     []APIKey `yaml:"api_keys" json:"api_keys"`
+	TokenSecret string   `yaml:"token_secret" json:"token_secret"`
+	// AnonymousReads lets requests without credentials use GET and HEAD.
+	AnonymousReads bool `yaml:"anonymous_reads" json:"anonymous_reads"`
+}
+
+// EventsConfig sets up the change events. History is the number of events a
+// client of /events can resume after, Webhooks are subscribed at startup.
+type EventsConfig struct {
+	History  int       `yaml:"history" json:"history"`
+	Webhooks []Webhook `yaml:"webhooks,omitempty" json:"webhooks,omitempty"`
+}
+
+// Webhook receives the events of Types, every event when it is empty, signed
+// with Secret.
+type Webhook struct {
+	URL    string   `yaml:"url" json:"url"`
+	Secret string   `yaml:"secret" json:"secret"`
+	Types  []string `yaml:"types,omitempty" json:"types,omitempty"`
+}
+
+// APIKey is a static credential. Name identifies its principal, Role is one
+// of viewer, operator and admin and defaults to viewer.
+type APIKey struct {
+	Name string `yaml:"name" json:"name"`
+	Key  string `yaml:"key" json:"key"`
+	Role string `yaml:"role,omitempty" json:"role,omitempty"`
+}
+
+var roles = []string{"viewer", "operator", "admin"}
+
+// minSecretLength is the shortest token secret and API key accepted, in bytes.
+const minSecretLength = 16
+
+// Duration is a time.Duration written like "30s" in config files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	parsed, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Address:           "127.0.0.1",
+			Port:              8080,
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(30 * time.Second),
+			WriteTimeout:      Duration(time.Minute),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(15 * time.Second),
+		},
+		Storage: StorageConfig{
+			Backend:          BackendMemory,
+			DSN:              "file:devices.db?_busy_timeout=5000",
+			WALPath:          "devices.wal",
+			SnapshotPath:     "devices.snapshot.json",
+			SnapshotInterval: Duration(time.Minute),
+			WALCompactSize:   16 << 20,
+			AuditPath:        "devices.audit.jsonl",
+			TrashRetention:   Duration(30 * 24 * time.Hour),
+		},
+		Auth: AuthConfig{
+			AnonymousReads: true,
+		},
+		Events: EventsConfig{
+			History: 1024,
+		},
+	}
+}
+
+// setting ties a field of Config to its flag and its environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value any
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"address", "ADDRESS", "address to listen on", &c.Server.Address},
+		{"port", "PORT", "port to listen on", &c.Server.Port},
+		{"read-header-timeout", "READ_HEADER_TIMEOUT", "time allowed to read the request headers", &c.Server.ReadHeaderTimeout},
+		{"read-timeout", "READ_TIMEOUT", "time allowed to read a whole request", &c.Server.ReadTimeout},
+		{"write-timeout", "WRITE_TIMEOUT", "time allowed to write a response", &c.Server.WriteTimeout},
+		{"idle-timeout", "IDLE_TIMEOUT", "time an idle keep-alive connection stays open", &c.Server.IdleTimeout},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time allowed to drain requests and flush the storage on shutdown", &c.Server.ShutdownTimeout},
+		{"storage", "STORAGE", "storage backend: memory, wal or sqlite", &c.Storage.Backend},
+		{"storage-dsn", "STORAGE_DSN", "data source name of the sqlite backend", &c.Storage.DSN},
+		{"wal-path", "WAL_PATH", "write-ahead log of the wal backend", &c.Storage.WALPath},
+		{"snapshot-path", "SNAPSHOT_PATH", "snapshot of the memory and wal backends, empty to disable memory snapshots", &c.Storage.SnapshotPath},
+		{"snapshot-interval", "SNAPSHOT_INTERVAL", "interval of the memory backend snapshots", &c.Storage.SnapshotInterval},
+		{"wal-compact-size", "WAL_COMPACT_SIZE", "log size in bytes past which the wal backend compacts it", &c.Storage.WALCompactSize},
+		{"audit-path", "AUDIT_PATH", "file of the audit trail, empty to keep it in memory", &c.Storage.AuditPath},
+		{"trash-retention", "TRASH_RETENTION", "time deleted devices can be restored, 0 to keep them forever", &c.Storage.TrashRetention},
+		{"api-keys", "AUTH_API_KEYS", "API keys as name:role=key,name:role=key", &c.Auth.APIKeys},
+		{"token-secret", "AUTH_TOKEN_SECRET", "secret the bearer tokens are signed with", &c.Auth.TokenSecret},
+		{"anonymous-reads", "AUTH_ANONYMOUS_READS", "let requests without credentials read devices", &c.Auth.AnonymousReads},
+		{"event-history", "EVENT_HISTORY", "number of change events kept for the clients resuming their stream", &c.Events.History},
+	}
+}
+
+func setValue(value any, s string) error {
+	switch v := value.(type) {
+	case *string:
+		*v = s
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		*v = n
+	case *int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		*v = b
+	case *Duration:
+		return v.UnmarshalText([]byte(s))
+	case *[]APIKey:
+		var keys []APIKey
+		for _, item := range strings.Split(s, ",") {
+			owner, key, ok := strings.Cut(item, "=")
+			if !ok || owner == "" {
+				return errors.New("not a list of name:role=key")
+			}
+			name, role, _ := strings.Cut(owner, ":")
+			keys = append(keys, APIKey{Name: name, Key: key, Role: role})
+		}
+		*v = keys
+	default:
+		return fmt.Errorf("unsupported setting type %T", value)
+	}
+	return nil
+}
+
+// Options are the flags that are not settings.
+type Options struct {
+	ConfigFile  string
+	PrintConfig bool
+	// IssueToken is the subject of a bearer token to print, valid for
+	// TokenTTL.
+	IssueToken string
+	TokenRole  string
+	TokenTTL   time.Duration
+}
+
+// Load builds the configuration from the defaults, the file named by --config
+// or CONFIG_FILE, the environment and args, and validates it. It returns
+// flag.ErrHelp when args ask for the usage.
+func Load(args []string, lookupEnv func(string) (string, bool), output io.Writer) (Config, Options, error) {
+	cfg := Default()
+	var opts Options
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.ConfigFile, "config", "", "YAML or JSON config file (env CONFIG_FILE)")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	fs.StringVar(&opts.IssueToken, "issue-token", "", "print a bearer token for this subject and exit")
+	fs.StringVar(&opts.TokenRole, "token-role", "viewer", "role of the token printed by -issue-token")
+	fs.DurationVar(&opts.TokenTTL, "token-ttl", 24*time.Hour, "lifetime of the token printed by -issue-token")
+	flagValues := make(map[string]*string)
+	for _, s := range cfg.settings() {
+		flagValues[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, opts, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, opts, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	if opts.ConfigFile == "" {
+		opts.ConfigFile, _ = lookupEnv("CONFIG_FILE")
+	}
+	if opts.ConfigFile != "" {
+		if err := cfg.loadFile(opts.ConfigFile); err != nil {
+			return cfg, opts, err
+		}
+	}
+
+	settings := cfg.settings()
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok {
+			if err := setValue(s.value, value); err != nil {
+				return cfg, opts, fmt.Errorf("%s=%q: %w", s.env, value, err)
+			}
+		}
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if serr := setValue(s.value, *flagValues[s.flag]); serr != nil {
+					err = fmt.Errorf("-%s=%q: %w", s.flag, *flagValues[s.flag], serr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, opts, err
+	}
+
+	if opts.IssueToken != "" && !slices.Contains(roles, opts.TokenRole) {
+		return cfg, opts, fmt.Errorf("-token-role %q is not viewer, operator or admin", opts.TokenRole)
+	}
+	return cfg, opts, cfg.Validate()
+}
+
+// loadFile reads a YAML or JSON file, JSON when its name ends in .json.
+// Unknown keys are errors so that a typo does not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err = dec.Decode(c); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid value at once.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %d is not between 1 and 65535", c.Server.Port))
+	}
+	positive := []struct {
+		name  string
+		value Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"storage.snapshot_interval", c.Storage.SnapshotInterval},
+	}
+	for _, p := range positive {
+		if p.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", p.name))
+		}
+	}
+
+	switch c.Storage.Backend {
+	case BackendMemory:
+	case BackendWAL:
+		if c.Storage.WALPath == "" {
+			errs = append(errs, errors.New("storage.wal_path is required by the wal backend"))
+		}
+		if c.Storage.SnapshotPath == "" {
+			errs = append(errs, errors.New("storage.snapshot_path is required by the wal backend"))
+		}
+	case BackendSQLite:
+		if c.Storage.DSN == "" {
+			errs = append(errs, errors.New("storage.dsn is required by the sqlite backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend %q is not memory, wal or sqlite", c.Storage.Backend))
+	}
+	if c.Storage.WALCompactSize < 0 {
+		errs = append(errs, errors.New("storage.wal_compact_size must not be negative"))
+	}
+	if c.Storage.TrashRetention < 0 {
+		errs = append(errs, errors.New("storage.trash_retention must not be negative"))
+	}
+
+	if c.Auth.TokenSecret != "" && len(c.Auth.TokenSecret) < minSecretLength {
+		errs = append(errs, fmt.Errorf("auth.token_secret must be at least %d bytes", minSecretLength))
+	}
+	owners := make(map[string]string)
+	for i, key := range c.Auth.APIKeys {
+		if key.Name == "" {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d] has no name", i))
+		}
+		if len(key.Key) < minSecretLength {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d] key must be at least %d bytes", i, minSecretLength))
+		}
+		if key.Role != "" && !slices.Contains(roles, key.Role) {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d] role %q is not viewer, operator or admin", i, key.Role))
+		}
+		if owner, ok := owners[key.Key]; ok {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d] has the key of %s", i, owner))
+		}
+		owners[key.Key] = key.Name
+	}
+
+	if c.Events.History < 1 {
+		errs = append(errs, errors.New("events.history must be positive"))
+	}
+	for i, webhook := range c.Events.Webhooks {
+		if webhook.URL == "" {
+			errs = append(errs, fmt.Errorf("events.webhooks[%d] has no url", i))
+		}
+		if len(webhook.Secret) < minSecretLength {
+			errs = append(errs, fmt.Errorf("events.webhooks[%d] secret must be at least %d bytes", i, minSecretLength))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy safe to print.
+func (c Config) Redacted() Config {
+	if c.Storage.DSN != "" {
+		c.Storage.DSN = redacted
+	}
+	if c.Auth.TokenSecret != "" {
+		c.Auth.TokenSecret = redacted
+	}
+	keys := make([]APIKey, len(c.Auth.APIKeys))
+	for i, key := range c.Auth.APIKeys {
+		key.Key = redacted
+		keys[i] = key
+	}
+	c.Auth.APIKeys = keys
+	webhooks := make([]Webhook, len(c.Events.Webhooks))
+	for i, webhook := range c.Events.Webhooks {
+		webhook.Secret = redacted
+		webhooks[i] = webhook
+	}
+	c.Events.Webhooks = webhooks
+	return c
+}
+
+// Write prints the configuration as YAML.
+func (c Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
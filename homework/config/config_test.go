@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	cfg, opts, err := Load(nil, env(nil), io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+	assert.Equal(t, Options{TokenRole: "viewer", TokenTTL: 24 * time.Hour}, opts)
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, "server.yaml", `
+server:
+  address: 0.0.0.0
+  port: 9000
+  read_timeout: 10s
+storage:
+  backend: sqlite
+  dsn: file:from-file.db
+`)
+
+	cfg, opts, err := Load(
+		[]string{"-config", path, "-port", "9002"},
+		env(map[string]string{"PORT": "9001", "READ_TIMEOUT": "20s"}),
+		io.Discard,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, path, opts.ConfigFile)
+	assert.Equal(t, "0.0.0.0", cfg.Server.Address, "file over default")
+	assert.Equal(t, Duration(20*time.Second), cfg.Server.ReadTimeout, "env over file")
+	assert.Equal(t, 9002, cfg.Server.Port, "flag over env")
+	assert.Equal(t, BackendSQLite, cfg.Storage.Backend)
+	assert.Equal(t, Duration(time.Minute), cfg.Server.WriteTimeout, "default")
+}
+
+func TestJSONFileFromEnv(t *testing.T) {
+	path := writeFile(t, "server.json", `{"storage": {"backend": "wal", "wal_path": "/var/lib/devices.wal"}}`)
+
+	cfg, _, err := Load(nil, env(map[string]string{"CONFIG_FILE": path}), io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, BackendWAL, cfg.Storage.Backend)
+	assert.Equal(t, "/var/lib/devices.wal", cfg.Storage.WALPath)
+}
+
+func TestInvalidInput(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"unknown yaml key", []string{"-config", writeFile(t, "a.yaml", "server:\n  prot: 1\n")}, nil},
+		{"unknown json key", []string{"-config", writeFile(t, "a.json", `{"server": {"prot": 1}}`)}, nil},
+		{"bad duration in file", []string{"-config", writeFile(t, "b.yaml", "server:\n  read_timeout: soon\n")}, nil},
+		{"missing file", []string{"-config", "/does/not/exist.yaml"}, nil},
+		{"bad env", nil, map[string]string{"PORT": "http"}},
+		{"bad flag", []string{"-idle-timeout", "forever"}, nil},
+		{"unknown flag", []string{"-colour"}, nil},
+		{"arguments", []string{"serve"}, nil},
+	}
+	for _, test := range tests {
+		_, _, err := Load(test.args, env(test.env), io.Discard)
+		assert.Error(t, err, test.name)
+	}
+}
+
+func TestHelp(t *testing.T) {
+	var out bytes.Buffer
+	_, _, err := Load([]string{"-h"}, env(nil), &out)
+	assert.ErrorIs(t, err, flag.ErrHelp)
+	assert.Contains(t, out.String(), "env STORAGE_DSN")
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Port = 70000
+	cfg.Server.IdleTimeout = 0
+	cfg.Storage.Backend = "wal"
+	cfg.Storage.SnapshotPath = ""
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.port")
+	assert.Contains(t, err.Error(), "server.idle_timeout")
+	assert.Contains(t, err.Error(), "storage.snapshot_path")
+
+	cfg = Default()
+	cfg.Storage.Backend = "postgres"
+	assert.ErrorContains(t, cfg.Validate(), "storage.backend")
+}
+
+func TestPrintConfig(t *testing.T) {
+	cfg, opts, err := Load([]string{"-print-config", "-storage-dsn", "postgres://admin:hunter2@db/devices"}, env(nil), io.Discard)
+	require.NoError(t, err)
+	assert.True(t, opts.PrintConfig)
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.Redacted().Write(&out))
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Contains(t, out.String(), "dsn: REDACTED")
+	assert.Contains(t, out.String(), "read_timeout: 30s")
+	assert.Equal(t, "postgres://admin:hunter2@db/devices", cfg.Storage.DSN, "Redacted returns a copy")
+}
+
+func TestAuthSettings(t *testing.T) {
+	cfg, _, err := Load([]string{"-anonymous-reads=false"}, env(map[string]string{
+		"AUTH_API_KEYS":     "ci:operator=ci-key-0123456789,noc=noc-key-0123456789",
+		"AUTH_TOKEN_SECRET": "0123456789abcdef0123456789abcdef",
+	}), io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, []APIKey{
+		{Name: "ci", Key: "ci-key-0123456789", Role: "operator"},
+		{Name: "noc", Key: "noc-key-0123456789"},
+	}, cfg.Auth.APIKeys)
+	assert.False(t, cfg.Auth.AnonymousReads)
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.Redacted().Write(&out))
+	assert.NotContains(t, out.String(), "key-0123456789")
+	assert.NotContains(t, out.String(), "0123456789abcdef")
+
+	cfg = Default()
+	cfg.Auth.TokenSecret = "short"
+	cfg.Auth.APIKeys = []APIKey{
+		{Name: "a", Key: "same-key-0123456789"},
+		{Name: "b", Key: "same-key-0123456789", Role: "root"},
+	}
+	err = cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "auth.token_secret")
+	assert.Contains(t, err.Error(), "auth.api_keys[1] has the key of a")
+	assert.Contains(t, err.Error(), `auth.api_keys[1] role "root"`)
+
+	_, _, err = Load(nil, env(map[string]string{"AUTH_API_KEYS": "no-separator"}), io.Discard)
+	assert.Error(t, err)
+}
+
+func TestAPIKeysFromYAML(t *testing.T) {
+	path := writeFile(t, "server.yaml", `
+auth:
+  api_keys:
+    - name: provisioning
+      key: prov-key-0123456789
+      role: operator
+`)
+	cfg, _, err := Load([]string{"-config", path}, env(nil), io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, []APIKey{{Name: "provisioning", Key: "prov-key-0123456789", Role: "operator"}}, cfg.Auth.APIKeys)
+}
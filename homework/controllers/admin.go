@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"io"
+	"net/http"
+)
+
+// Snapshots saves and restores the devices of the in-memory repository.
+type Snapshots interface {
+	// Snapshot and Restore use the default snapshot when file is empty.
+	Snapshot(file string) (models.SnapshotInfo, error)
+	Restore(file string) (models.SnapshotInfo, error)
+}
+
+type AdminHandler struct {
+	snapshots Snapshots
+}
+
+func NewAdminHandler(snapshots Snapshots) *AdminHandler {
+	return &AdminHandler{
+		snapshots: snapshots,
+	}
+}
+
+// SnapshotRequest is the optional body of the snapshot endpoints, File names
+// a file of the snapshot directory.
+type SnapshotRequest struct {
+	File string `json:"file"`
+}
+
+// NewAdminRouter serves the /admin/ endpoints.
+func NewAdminRouter(a *AdminHandler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/admin/snapshot", route{
+		http.MethodPost: a.Snapshot,
+	})
+	mux.Handle("/admin/restore", route{
+		http.MethodPost: a.Restore,
+	})
+	return mux
+}
+
+// Snapshot serves POST /admin/snapshot.
+func (a *AdminHandler) Snapshot(w http.ResponseWriter, r *http.Request) {
+	a.serveSnapshot(w, r, a.snapshots.Snapshot)
+}
+
+// Restore serves POST /admin/restore.
+func (a *AdminHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	a.serveSnapshot(w, r, a.snapshots.Restore)
+}
+
+func (a *AdminHandler) serveSnapshot(w http.ResponseWriter, r *http.Request, fn func(file string) (models.SnapshotInfo, error)) {
+	var req SnapshotRequest
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errReadBody)
+		return
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &req); err != nil {
+			writeError(w, errUnmarshalBody)
+			return
+		}
+	}
+
+	info, err := fn(req.File)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(info)
+}
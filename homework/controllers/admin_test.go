@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"homework/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSnapshots struct {
+	files []string
+}
+
+func (f *fakeSnapshots) Snapshot(file string) (models.SnapshotInfo, error) {
+	f.files = append(f.files, "snapshot:"+file)
+	return models.SnapshotInfo{File: file, Devices: 3}, nil
+}
+
+func (f *fakeSnapshots) Restore(file string) (models.SnapshotInfo, error) {
+	if file == "../etc" {
+		return models.SnapshotInfo{}, fmt.Errorf("%q :%w", file, models.ErrInvalidSnapshot)
+	}
+	f.files = append(f.files, "restore:"+file)
+	return models.SnapshotInfo{File: file}, nil
+}
+
+func TestAdminSnapshot(t *testing.T) {
+	snapshots := &fakeSnapshots{}
+	router := NewAdminRouter(NewAdminHandler(snapshots))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/snapshot", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var info models.SnapshotInfo
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, 3, info.Devices)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewBufferString(`{"file": "old.json"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewBufferString(`{"file": "../etc"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	assert.Equal(t, []string{"snapshot:", "restore:old.json"}, snapshots.files)
+}
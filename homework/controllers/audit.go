@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"homework/models"
+	"homework/services"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// AuditResponse is a page of the audit trail. NextAfter is set while there
+// may be more entries, it is the after parameter of the next page.
+type AuditResponse struct {
+	Entries   []models.AuditEntry `json:"entries"`
+	NextAfter uint64              `json:"next_after,omitempty"`
+}
+
+// RequestID gives every request an ID, the one of its X-Request-ID header if
+// it has a usable one, and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(services.WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// DeviceHistory serves GET /devices/{serial_num}/history, the changes of a
+// device oldest first, for admins only. It outlives the device.
+func (h *Handler) DeviceHistory(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		writeError(w, errNoAudit)
+		return
+	}
+	history, err := h.audit.History(serialNumFrom(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(AuditResponse{Entries: history})
+}
+
+// ListAudit serves GET /audit?since=&after=&limit=, the changes of every
+// device recorded at or after since (RFC 3339), oldest first.
+func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		writeError(w, errNoAudit)
+		return
+	}
+	query := r.URL.Query()
+	q := models.AuditQuery{Limit: DefaultAuditLimit}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeError(w, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "since", "since must be an RFC 3339 time"))
+			return
+		}
+		q.Since = t
+	}
+	if after := query.Get("after"); after != "" {
+		n, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			writeError(w, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "after", "after must be a sequence number"))
+			return
+		}
+		q.After = n
+	}
+	limit, err := intParam(query, "limit")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if limit < 0 || limit > MaxAuditLimit {
+		writeError(w, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "limit", "limit must be between 1 and "+strconv.Itoa(MaxAuditLimit)))
+		return
+	}
+	if limit > 0 {
+		q.Limit = limit
+	}
+
+	entries, err := h.audit.Since(q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp := AuditResponse{Entries: entries}
+	if len(entries) == q.Limit {
+		resp.NextAfter = entries[len(entries)-1].Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+var errNoAudit = newRequestError(http.StatusNotFound, CodeNotFound, "", "the audit log is not enabled")
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"homework/repositories"
+	"homework/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAuditedRouter(enforceRoles bool) http.Handler {
+	h := NewHandler(services.NewService(repositories.NewRepoDevice()))
+	h.Audit(repositories.NewMemoryAuditLog())
+	if enforceRoles {
+		h.EnforceRoles()
+	}
+	return RequestID(NewRouter(h))
+}
+
+func serveAs(router http.Handler, principal *models.Principal, r *http.Request) *httptest.ResponseRecorder {
+	if principal != nil {
+		r = r.WithContext(services.WithPrincipal(r.Context(), *principal))
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestDeviceHistory(t *testing.T) {
+	router := newAuditedRouter(true)
+	ci := &models.Principal{Name: "ci", Role: models.RoleOperator}
+	admin := &models.Principal{Name: "root", Role: models.RoleAdmin}
+
+	r := httptest.NewRequest(http.MethodPost, "/devices", strings.NewReader(`{"serial_num":"1","model":"m","ip":"10.0.0.1"}`))
+	r.Header.Set("X-Request-ID", "req-1")
+	w := serveAs(router, ci, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "req-1", w.Header().Get("X-Request-ID"))
+
+	w = serveAs(router, ci, httptest.NewRequest(http.MethodDelete, "/devices/1", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Len(t, w.Header().Get("X-Request-ID"), 32)
+
+	w = serveAs(router, nil, httptest.NewRequest(http.MethodGet, "/devices/1/history", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveAs(router, ci, httptest.NewRequest(http.MethodGet, "/devices/1/history", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serveAs(router, admin, httptest.NewRequest(http.MethodGet, "/devices/1/history", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp AuditResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Entries, 2)
+	assert.Equal(t, "ci", resp.Entries[0].Actor)
+	assert.Equal(t, "req-1", resp.Entries[0].RequestID)
+	assert.Equal(t, models.AuditDelete, resp.Entries[1].Action)
+	assert.Equal(t, "m", resp.Entries[1].Before.Model)
+
+	w = serveAs(router, admin, httptest.NewRequest(http.MethodGet, "/devices/2/history", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestListAudit(t *testing.T) {
+	router := newAuditedRouter(true)
+	ci := &models.Principal{Name: "ci", Role: models.RoleOperator}
+	for _, serialNum := range []string{"1", "2", "3"} {
+		body := `{"serial_num":"` + serialNum + `","model":"m","ip":"10.0.0.` + serialNum + `"}`
+		w := serveAs(router, ci, httptest.NewRequest(http.MethodPost, "/devices", strings.NewReader(body)))
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	w := serveAs(router, ci, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	admin := &models.Principal{Name: "root", Role: models.RoleAdmin}
+	w = serveAs(router, admin, httptest.NewRequest(http.MethodGet, "/audit?since=2000-01-01T00:00:00Z&limit=2", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp AuditResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Entries, 2)
+	assert.Equal(t, uint64(2), resp.NextAfter)
+
+	w = serveAs(router, admin, httptest.NewRequest(http.MethodGet, "/audit?after=2", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp = AuditResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Entries, 1)
+	assert.Equal(t, "3", resp.Entries[0].SerialNum)
+	assert.Zero(t, resp.NextAfter)
+
+	for _, query := range []string{"since=yesterday", "after=-1", "limit=5000"} {
+		w = serveAs(router, admin, httptest.NewRequest(http.MethodGet, "/audit?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	router := NewRouter(NewHandler(services.NewService(repositories.NewRepoDevice())))
+	w := serveAs(router, nil, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
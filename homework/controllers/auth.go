@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"fmt"
+	"homework/models"
+	"homework/services"
+	"net/http"
+	"strings"
+)
+
+// Authenticate lets through the requests carrying a valid API key in
+// X-API-Key or a valid bearer token in Authorization, with their principal in
+// the request context. Requests without credentials may only read, and only
+// when anonymousReads is set. An authenticator without any credential
+// configured lets everything through.
+func Authenticate(next http.Handler, auth *services.Authenticator, anonymousReads bool) http.Handler {
+	if !auth.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticate(r, auth)
+		if err == nil && principal == nil && !(anonymousReads && safeMethod(r.Method)) {
+			err = fmt.Errorf("authentication required :%w", models.ErrUnauthorized)
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="devices"`)
+			writeError(w, err)
+			return
+		}
+		if principal != nil {
+			r = r.WithContext(services.WithPrincipal(r.Context(), *principal))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns no principal and no error for a request without
+// credentials.
+func authenticate(r *http.Request, auth *services.Authenticator) (*models.Principal, error) {
+	var principal models.Principal
+	var err error
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		principal, err = auth.AuthenticateAPIKey(key)
+	} else if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, token, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, fmt.Errorf("unsupported authorization scheme %q :%w", scheme, models.ErrUnauthorized)
+		}
+		principal, err = auth.AuthenticateToken(strings.TrimSpace(token))
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &principal, nil
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// RequireRole lets through the requests whose principal has role.
+func RequireRole(next http.Handler, role models.Role) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := services.PrincipalFrom(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="devices"`)
+			writeError(w, fmt.Errorf("authentication required :%w", models.ErrUnauthorized))
+			return
+		}
+		if !principal.Role.Allows(role) {
+			writeError(w, fmt.Errorf("%q needs the %s role :%w", principal.Name, role, models.ErrForbidden))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"homework/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	servMock "homework/controllers/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newAuthTestHandler(t *testing.T, anonymousReads bool) (http.Handler, string) {
+	auth := services.NewAuthenticator([]services.APIKey{{Name: "ci", Key: "ci-key-0123456789", Role: models.RoleOperator}}, "0123456789abcdef0123456789abcdef")
+	token, err := auth.SignToken(models.TokenClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := services.PrincipalFrom(r.Context())
+		if !ok {
+			p.Name = "anonymous"
+		}
+		_, _ = w.Write([]byte(p.Name))
+	})
+	return Authenticate(next, auth, anonymousReads), token
+}
+
+func TestAuthenticate(t *testing.T) {
+	handler, token := newAuthTestHandler(t, true)
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		status int
+		body   string
+	}{
+		{"anonymous read", http.MethodGet, "", "", http.StatusOK, "anonymous"},
+		{"anonymous write", http.MethodDelete, "", "", http.StatusUnauthorized, ""},
+		{"api key", http.MethodDelete, "X-API-Key", "ci-key-0123456789", http.StatusOK, "ci"},
+		{"wrong api key", http.MethodGet, "X-API-Key", "nope", http.StatusUnauthorized, ""},
+		{"bearer token", http.MethodPost, "Authorization", "Bearer " + token, http.StatusOK, "alice"},
+		{"lowercase scheme", http.MethodPost, "Authorization", "bearer " + token, http.StatusOK, "alice"},
+		{"bad token", http.MethodGet, "Authorization", "Bearer " + token + "x", http.StatusUnauthorized, ""},
+		{"basic auth", http.MethodPost, "Authorization", "Basic YWxpY2U6cHc=", http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/devices/1", nil)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, test.status, w.Code, test.name)
+		if test.status == http.StatusOK {
+			assert.Equal(t, test.body, w.Body.String(), test.name)
+			continue
+		}
+		assert.Equal(t, `Bearer realm="devices"`, w.Header().Get("WWW-Authenticate"), test.name)
+		var body ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), test.name)
+		assert.Equal(t, CodeUnauthorized, body.Code, test.name)
+	}
+}
+
+func TestAuthenticateWithoutAnonymousReads(t *testing.T) {
+	handler, _ := newAuthTestHandler(t, false)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Authenticate(next, services.NewAuthenticator(nil, ""), false)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/devices/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRequireRole(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := RequireRole(next, models.RoleAdmin)
+
+	tests := []struct {
+		name      string
+		principal *models.Principal
+		status    int
+		code      string
+	}{
+		{"anonymous", nil, http.StatusUnauthorized, CodeUnauthorized},
+		{"operator", &models.Principal{Name: "ci", Role: models.RoleOperator}, http.StatusForbidden, CodeForbidden},
+		{"admin", &models.Principal{Name: "root", Role: models.RoleAdmin}, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/admin/snapshot", nil)
+		if test.principal != nil {
+			r = r.WithContext(services.WithPrincipal(r.Context(), *test.principal))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, test.status, w.Code, test.name)
+		if test.code != "" {
+			var body ErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), test.name)
+			assert.Equal(t, test.code, body.Code, test.name)
+		}
+	}
+}
+
+func TestHandlerEnforceRoles(t *testing.T) {
+	repo := new(servMock.Service)
+	repo.On("DeleteDevice", mock.Anything, "1").Return(nil).Once()
+	h := NewHandler(services.NewService(repo))
+	h.EnforceRoles()
+	router := NewRouter(h)
+
+	del := func(principal models.Principal) int {
+		r := httptest.NewRequest(http.MethodDelete, "/devices/1", nil)
+		r = r.WithContext(services.WithPrincipal(r.Context(), principal))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, del(models.Principal{Name: "noc", Role: models.RoleViewer}))
+	assert.Equal(t, http.StatusNoContent, del(models.Principal{Name: "ci", Role: models.RoleOperator}))
+	repo.AssertExpectations(t)
+}
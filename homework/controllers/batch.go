@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homework/models"
+	"homework/services"
+	"io"
+	"net/http"
+)
+
+// BatchRequest is the body of the /devices/batch endpoints. Devices is read by
+// POST and PUT, SerialNums or else Selector by DELETE. Mode defaults to
+// atomic.
+type BatchRequest struct {
+	Mode       models.BatchMode `json:"mode"`
+	Devices    []models.Device  `json:"devices,omitempty"`
+	SerialNums []string         `json:"serial_nums,omitempty"`
+	// Selector is a label selector, see models.ParseSelector.
+	Selector string `json:"selector,omitempty"`
+}
+
+const (
+	BatchItemOK      = "ok"
+	BatchItemFailed  = "failed"
+	BatchItemAborted = "aborted"
+)
+
+type BatchItemResult struct {
+	Index     int            `json:"index"`
+	SerialNum string         `json:"serial_num"`
+	Status    string         `json:"status"`
+	Error     *ErrorResponse `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Mode      models.BatchMode  `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// CreateDevices serves POST /devices/batch.
+func (h *Handler) CreateDevices(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatch(w, r)
+	if !ok {
+		return
+	}
+	errs, err := h.service.CreateDevices(r.Context(), req.Devices, req.Mode)
+	writeBatch(w, req.Mode, deviceSerials(req.Devices), errs, err)
+}
+
+// UpdateDevices serves PUT /devices/batch.
+func (h *Handler) UpdateDevices(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatch(w, r)
+	if !ok {
+		return
+	}
+	errs, err := h.service.UpdateDevices(r.Context(), req.Devices, req.Mode)
+	writeBatch(w, req.Mode, deviceSerials(req.Devices), errs, err)
+}
+
+// DeleteDevices serves DELETE /devices/batch, for the listed serial numbers
+// or the devices the selector selects. A selector selecting nothing deletes
+// nothing.
+func (h *Handler) DeleteDevices(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatch(w, r)
+	if !ok {
+		return
+	}
+	if req.Selector != "" {
+		if len(req.SerialNums) > 0 {
+			writeError(w, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "selector", "selector and serial_nums cannot be combined"))
+			return
+		}
+		serials, err := h.selectSerials(r.Context(), req.Selector)
+		if err != nil || len(serials) == 0 {
+			writeBatch(w, req.Mode, nil, nil, err)
+			return
+		}
+		req.SerialNums = serials
+	}
+	errs, err := h.service.DeleteDevices(r.Context(), req.SerialNums, req.Mode)
+	writeBatch(w, req.Mode, req.SerialNums, errs, err)
+}
+
+// selectSerials returns the serial numbers of the devices the selector
+// selects, a batch of them.
+func (h *Handler) selectSerials(ctx context.Context, s string) ([]string, error) {
+	selector, err := models.ParseSelector(s)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.service.ListDevices(ctx, models.ListQuery{Selector: selector, Limit: services.MaxBatchSize})
+	if err != nil {
+		return nil, err
+	}
+	if result.NextCursor != "" {
+		return nil, newRequestError(http.StatusUnprocessableEntity, CodeTooLarge, "selector",
+			fmt.Sprintf("the selector selects more than %d devices", services.MaxBatchSize))
+	}
+	return deviceSerials(result.Devices), nil
+}
+
+func decodeBatch(w http.ResponseWriter, r *http.Request) (BatchRequest, bool) {
+	var req BatchRequest
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errReadBody)
+		return req, false
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		writeError(w, errUnmarshalBody)
+		return req, false
+	}
+	if req.Mode == "" {
+		req.Mode = models.BatchAtomic
+	}
+	return req, true
+}
+
+func deviceSerials(devices []models.Device) []string {
+	serials := make([]string, len(devices))
+	for i, d := range devices {
+		serials[i] = d.SerialNum
+	}
+	return serials
+}
+
+// writeBatch answers 200 with the result of every item, unless the batch was
+// rejected as a whole or an atomic batch failed. The latter answers with the
+// status of its first failed item.
+func writeBatch(w http.ResponseWriter, mode models.BatchMode, serials []string, errs []error, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := BatchResponse{Mode: mode, Results: make([]BatchItemResult, len(errs))}
+	status := http.StatusOK
+	for i, itemErr := range errs {
+		result := BatchItemResult{Index: i, SerialNum: serials[i], Status: BatchItemOK}
+		switch {
+		case itemErr == nil:
+			resp.Succeeded++
+		case errors.Is(itemErr, models.ErrBatchAborted):
+			result.Status = BatchItemAborted
+			resp.Failed++
+		default:
+			itemStatus, body := errorResponse(itemErr)
+			result.Status = BatchItemFailed
+			result.Error = &body
+			resp.Failed++
+			if mode == models.BatchAtomic && status == http.StatusOK {
+				status = itemStatus
+			}
+		}
+		resp.Results[i] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homework/models"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+var formatContentTypes = map[string]string{
+	FormatCSV:    "text/csv; charset=utf-8",
+	FormatNDJSON: "application/x-ndjson",
+	FormatJSON:   "application/json",
+}
+
+// csvColumns is the header of an exported CSV file. An imported one may hold
+// its columns in any order and leave some out, version and the times are
+// ignored. The MAC addresses are written comma separated, and the labels as
+// comma separated key=value pairs.
+var csvColumns = []string{
+	"serial_num", "model", "ip", "ipv6", "segment", "vendor", "firmware", "macs", "hostname", "site", "rack",
+	"status", "status_reason", "labels", "version", "created_at", "updated_at",
+}
+
+// legacyCSVColumns are still read on import, the single MAC address of the
+// files exported before a device had several.
+var legacyCSVColumns = []string{"mac"}
+
+func formatParam(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return FormatJSON, nil
+	}
+	if _, ok := formatContentTypes[format]; !ok {
+		return "", newRequestError(http.StatusBadRequest, CodeInvalidRequest, "format",
+			fmt.Sprintf("format must be %q, %q or %q", FormatCSV, FormatNDJSON, FormatJSON))
+	}
+	return format, nil
+}
+
+// deviceWriter streams devices in one of the export formats.
+type deviceWriter interface {
+	Write(d models.Device) error
+	// Close terminates the document and flushes what is buffered.
+	Close() error
+}
+
+func newDeviceWriter(format string, w io.Writer) deviceWriter {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write(csvColumns)
+		return csvWriter{cw}
+	case FormatNDJSON:
+		return ndjsonWriter{json.NewEncoder(w)}
+	default:
+		return &jsonArrayWriter{w: w}
+	}
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c csvWriter) Write(d models.Device) error {
+	return c.w.Write([]string{
+		d.SerialNum, d.Model, d.IP, d.IPv6, d.Segment, d.Vendor, d.Firmware, strings.Join(d.MACs, ","), d.Hostname, d.Site, d.Rack,
+		d.Status, d.StatusReason, formatLabels(d.Labels), strconv.FormatInt(d.Version, 10),
+		formatTime(d.CreatedAt), formatTime(d.UpdatedAt),
+	})
+}
+
+// formatTime leaves the unknown times of the devices stored before they had
+// any empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func parseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q is not a key=value pair", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func (c csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n ndjsonWriter) Write(d models.Device) error {
+	return n.enc.Encode(d)
+}
+
+func (n ndjsonWriter) Close() error {
+	return nil
+}
+
+type jsonArrayWriter struct {
+	w      io.Writer
+	opened bool
+}
+
+func (j *jsonArrayWriter) Write(d models.Device) error {
+	sep := ","
+	if !j.opened {
+		sep, j.opened = "[", true
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.w, sep+string(b)+"\n")
+	return err
+}
+
+func (j *jsonArrayWriter) Close() error {
+	end := "]\n"
+	if !j.opened {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// importRow is a device read from an import, or the reason it could not be.
+type importRow struct {
+	device models.Device
+	err    error
+}
+
+// readDevices reads every row of an import. A broken row is reported in its
+// importRow, an error is returned only when the rest of the input cannot be
+// read.
+func readDevices(format string, r io.Reader) ([]importRow, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatNDJSON:
+		return readNDJSON(r)
+	default:
+		return readJSONArray(r)
+	}
+}
+
+func invalidRow(message string) error {
+	return newRequestError(http.StatusBadRequest, CodeInvalidRow, "", message)
+}
+
+func invalidImport(message string) error {
+	return newRequestError(http.StatusBadRequest, CodeInvalidRequest, "", message)
+}
+
+func readCSV(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, invalidImport("invalid CSV header: " + err.Error())
+	}
+	if len(header) > 0 {
+		// Spreadsheets like to start their UTF-8 exports with a BOM.
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	for _, column := range header {
+		if !slices.Contains(csvColumns, column) && !slices.Contains(legacyCSVColumns, column) {
+			return nil, invalidImport(fmt.Sprintf("unknown CSV column %q", column))
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{err: invalidRow(parseErr.Err.Error())})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var row importRow
+		d := &row.device
+		for i, value := range record {
+			switch header[i] {
+			case "serial_num":
+				d.SerialNum = value
+			case "model":
+				d.Model = value
+			case "ip":
+				d.IP = value
+			case "ipv6":
+				d.IPv6 = value
+			case "segment":
+				d.Segment = value
+			case "vendor":
+				d.Vendor = value
+			case "firmware":
+				d.Firmware = value
+			case "macs":
+				for _, mac := range strings.Split(value, ",") {
+					if mac = strings.TrimSpace(mac); mac != "" {
+						d.MACs = append(d.MACs, mac)
+					}
+				}
+			case "mac":
+				d.MAC = value
+			case "hostname":
+				d.Hostname = value
+			case "site":
+				d.Site = value
+			case "rack":
+				d.Rack = value
+			case "status":
+				d.Status = value
+			case "status_reason":
+				d.StatusReason = value
+			case "labels":
+				if d.Labels, err = parseLabels(value); err != nil {
+					row.err = invalidRow(err.Error())
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readNDJSON(r io.Reader) ([]importRow, error) {
+	var rows []importRow
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			rows = append(rows, decodeRow(line))
+		}
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readJSONArray(r io.Reader) ([]importRow, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, invalidImport("a JSON import must be an array of devices")
+	}
+	var rows []importRow
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, invalidImport("invalid JSON: " + err.Error())
+		}
+		rows = append(rows, decodeRow(raw))
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, invalidImport("invalid JSON: " + err.Error())
+	}
+	return rows, nil
+}
+
+// decodeRow decodes one JSON device, rejecting unknown fields so that a typo
+// does not silently drop a value. The version of an export is ignored.
+func decodeRow(b []byte) importRow {
+	var d models.Device
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d); err != nil {
+		return importRow{err: invalidRow(err.Error())}
+	}
+	d.Version = 0
+	return importRow{device: d}
+}
@@ -2,137 +2,378 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"homework/models"
 	"homework/services"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 type Handler struct {
+	// service is base with the decorators the handler was set up with.
 	service services.Service
+	base    services.Service
+	// enforceRoles limits every request to what the role of its principal
+	// allows.
+	enforceRoles bool
+	// audit records the changes made through the handler, nil when they are
+	// not audited.
+	audit services.AuditLog
 }
 
 func NewHandler(service services.Service) *Handler {
 	return &Handler{
 		service: service,
+		base:    service,
 	}
 }
 
+// EnforceRoles limits every request to what the role of its principal allows,
+// a request without principal is a viewer. Authenticate must run first.
+func (h *Handler) EnforceRoles() {
+	h.enforceRoles = true
+	h.decorate()
+}
+
+// Audit records every change made through the handler to log, and serves
+// it on the history endpoints.
+func (h *Handler) Audit(log services.AuditLog) {
+	h.audit = log
+	h.decorate()
+}
+
+// decorate wraps base so the changes are audited as made by the principal of
+// the request, and then only when its role allows them.
+func (h *Handler) decorate() {
+	h.service = h.base
+	if h.audit != nil {
+		h.service = services.Audit(h.service, h.audit)
+	}
+	if h.enforceRoles {
+		h.service = services.Authorize(h.service)
+	}
+}
+
+// requireAdmin keeps next to admins while roles are enforced.
+func (h *Handler) requireAdmin(next http.Handler) http.Handler {
+	admin := RequireRole(next, models.RoleAdmin)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.enforceRoles {
+			admin.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (h *Handler) GetDeviceInfo(w http.ResponseWriter, r *http.Request) {
-	serialNum := r.URL.Query().Get("serial_num")
+	serialNum := serialNumFrom(r)
 
 	if serialNum == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "invalid serial number"})
-	    _, _ = w.Write(responseBody)
+		writeError(w, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "serial_num", "invalid serial number"))
 		return
 	}
 
-	device, err := h.service.GetDevice(serialNum)
+	device, err := h.service.GetDevice(r.Context(), serialNum)
 	if err != nil {
-		responseBody, _ := json.Marshal(ErrorMessage{Message: err.Error()})
-		w.WriteHeader(http.StatusBadRequest)
-		_,_ =w.Write(responseBody)
+		writeError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(device.Version))
+	if notModified(r, device.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(device)
 }
 
 func (h *Handler) CreateDevice(w http.ResponseWriter, r *http.Request) {
-	b, err := io.ReadAll(r.Body)
+	if _, ok := h.createDevice(w, r); ok {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// CreateDeviceResource serves POST /devices.
+func (h *Handler) CreateDeviceResource(w http.ResponseWriter, r *http.Request) {
+	if d, ok := h.createDevice(w, r); ok {
+		w.Header().Set("Location", "/devices/"+url.PathEscape(d.SerialNum))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (h *Handler) createDevice(w http.ResponseWriter, r *http.Request) (models.Device, bool) {
+	d, ok := decodeDevice(w, r)
+	if !ok {
+		return d, false
+	}
+
+	err := h.service.CreateDevice(r.Context(), d)
+	if err != nil {
+		writeError(w, err)
+		return d, false
+	}
+	return d, true
+}
+
+func (h *Handler) RemoveDevice(w http.ResponseWriter, r *http.Request) {
+	if h.removeDevice(w, r) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// DeleteDeviceResource serves DELETE /devices/{serial_num}.
+func (h *Handler) DeleteDeviceResource(w http.ResponseWriter, r *http.Request) {
+	if h.removeDevice(w, r) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) removeDevice(w http.ResponseWriter, r *http.Request) bool {
+	serialNum := serialNumFrom(r)
+
+	if serialNum == "" {
+		writeError(w, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "serial_num", "invalid serial number"))
+		return false
+	}
+
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
+		return false
+	}
+
+	if version == models.AnyVersion {
+		err = h.service.DeleteDevice(r.Context(), serialNum)
+	} else {
+		err = h.service.CompareAndDeleteDevice(r.Context(), serialNum, version)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "error during reading body"})
-		_, _ = w.Write(responseBody)
+		writeError(w, err)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	var d models.Device
-	err = json.Unmarshal(b, &d)
+
+	d, ok := decodeDevice(w, r)
+	if !ok {
+		return
+	}
+
+	if serialNum, ok := r.Context().Value(serialNumKey).(string); ok {
+		if d.SerialNum == "" {
+			d.SerialNum = serialNum
+		}
+		if d.SerialNum != serialNum {
+			writeError(w, errSerialMismatch)
+			return
+		}
+	}
+
+	h.updateDevice(w, r, d, version)
+}
+
+// PatchDevice serves PATCH /devices/{serial_num} with a JSON Merge Patch
+// (application/merge-patch+json or application/json) or a JSON Patch
+// (application/json-patch+json) body.
+func (h *Handler) PatchDevice(w http.ResponseWriter, r *http.Request) {
+	serialNum := serialNumFrom(r)
+
+	version, err := ifMatch(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "error during unmarshaling body"})
-	    _, _ = w.Write(responseBody)
+		writeError(w, err)
 		return
 	}
 
-	respErr := services.ValidateDevice(d)
+	patchType := models.MergePatchType
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			writeError(w, fmt.Errorf("%q :%w", contentType, models.ErrUnsupportedPatch))
+			return
+		}
+		if mediaType != "application/json" {
+			patchType = mediaType
+		}
+	}
 
-	if respErr != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "Invalid date"})
-	    _, _ = w.Write(responseBody)
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errReadBody)
 		return
 	}
 
-	err = h.service.CreateDevice(d)
+	device, err := h.service.PatchDevice(r.Context(), serialNum, patchType, b, version)
 	if err != nil {
-		responseBody, _ := json.Marshal(ErrorMessage{Message: err.Error()})
-		w.WriteHeader(http.StatusBadRequest)
-		_,_ = w.Write(responseBody)
+		writeError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(device.Version))
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(device)
 }
 
-func (h *Handler) RemoveDevice(w http.ResponseWriter, r *http.Request) {
-	serialNum := r.URL.Query().Get("serial_num")
-
-	if serialNum == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "invalid serial number"})
-	    _, _ = w.Write(responseBody)
+// updateDevice replaces the device and answers with the ETag of the version it
+// wrote.
+func (h *Handler) updateDevice(w http.ResponseWriter, r *http.Request, d models.Device, version int64) {
+	var device models.Device
+	var err error
+	if version == models.AnyVersion {
+		device, err = h.service.UpdateDevice(r.Context(), d)
+	} else {
+		device, err = h.service.CompareAndSwapDevice(r.Context(), d, version)
+	}
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+	w.Header().Set("ETag", etag(device.Version))
+	w.WriteHeader(http.StatusOK)
+}
 
-	err := h.service.DeleteDevice(serialNum)
+// GetDeviceByMAC serves GET /devices/by-mac/{mac}, the MAC address is in any
+// of the forms net.ParseMAC reads.
+func (h *Handler) GetDeviceByMAC(w http.ResponseWriter, r *http.Request) {
+	mac, _ := r.Context().Value(macKey).(string)
+
+	device, err := h.service.GetDeviceByMAC(r.Context(), mac)
 	if err != nil {
-		responseBody, _ := json.Marshal(ErrorMessage{Message: err.Error()})
-		w.WriteHeader(http.StatusBadRequest)
-		_,_ = w.Write(responseBody)
+		writeError(w, err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(device)
 }
 
-func (h *Handler) UpdateDevice(w http.ResponseWriter, r *http.Request) {
-	b, err := io.ReadAll(r.Body)
+// GetDeviceByIP serves GET /devices/by-ip/{ip}?segment=
+func (h *Handler) GetDeviceByIP(w http.ResponseWriter, r *http.Request) {
+	ip, _ := r.Context().Value(ipKey).(string)
+
+	device, err := h.service.GetDeviceByIP(r.Context(), ip, r.URL.Query().Get("segment"))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "error during reading body"})
-	    _, _ = w.Write(responseBody)
+		writeError(w, err)
 		return
 	}
-	var d models.Device
-	err = json.Unmarshal(b, &d)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(device)
+}
+
+// ListDevices serves GET /devices?model=&segment=&ip=&serial_prefix=&sort=-model&limit=&offset=&cursor=
+// and the exact filters vendor=, firmware=, mac=, hostname=, site=, rack=,
+// status= and label=key=value, which may be repeated, and the label selector
+// selector=env=prod,rack in (a1,a2),!deprecated.
+func (h *Handler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "error during unmarshaling body"})
-	    _, _ = w.Write(responseBody)
+		writeError(w, err)
 		return
 	}
 
-	respErr := services.ValidateDevice(d)
-
-	if respErr != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		responseBody, _ := json.Marshal(ErrorMessage{Message: "Invalid date"})
-	    _, _ = w.Write(responseBody)
+	if q.Limit, err = intParam(r.URL.Query(), "limit"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if q.Offset, err = intParam(r.URL.Query(), "offset"); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	err = h.service.UpdateDevice(d)
+	result, err := h.service.ListDevices(r.Context(), q)
 	if err != nil {
-		responseBody, _ := json.Marshal(ErrorMessage{Message: err.Error()})
-		w.WriteHeader(http.StatusBadRequest)
-		_,_ = w.Write(responseBody)
+		writeError(w, err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// listQuery reads the filters and the order of a listing.
+func listQuery(query url.Values) (models.ListQuery, error) {
+	q := models.ListQuery{
+		Cursor:       query.Get("cursor"),
+		Model:        query.Get("model"),
+		Segment:      query.Get("segment"),
+		SerialPrefix: query.Get("serial_prefix"),
+		IPPrefix:     query.Get("ip"),
+		Vendor:       query.Get("vendor"),
+		Firmware:     query.Get("firmware"),
+		MAC:          query.Get("mac"),
+		Hostname:     query.Get("hostname"),
+		Site:         query.Get("site"),
+		Rack:         query.Get("rack"),
+		Status:       query.Get("status"),
+		SortBy:       strings.TrimPrefix(query.Get("sort"), "-"),
+		Desc:         strings.HasPrefix(query.Get("sort"), "-"),
+	}
+	for _, selector := range query["selector"] {
+		requirements, err := models.ParseSelector(selector)
+		if err != nil {
+			return q, err
+		}
+		q.Selector = append(q.Selector, requirements...)
+	}
+	// label=key=value is the older form of selector=key=value.
+	for _, label := range query["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			return q, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "label", "label must be a key=value pair")
+		}
+		q.Selector = append(q.Selector, models.Requirement{Key: key, Op: models.SelectEquals, Values: []string{value}})
+	}
+	return q, nil
+}
+
+func intParam(query url.Values, name string) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, newRequestError(http.StatusBadRequest, CodeInvalidRequest, name, name+" must be an integer")
+	}
+	return n, nil
 }
 
-type ErrorMessage struct {
-	Message string `json:"message"`
-}
\ No newline at end of file
+func decodeDevice(w http.ResponseWriter, r *http.Request) (models.Device, bool) {
+	var d models.Device
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errReadBody)
+		return d, false
+	}
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		writeError(w, errUnmarshalBody)
+		return d, false
+	}
+	return d, true
+}
+
+var (
+	errReadBody           = newRequestError(http.StatusInternalServerError, CodeInternal, "", "error during reading body")
+	errUnmarshalBody      = newRequestError(http.StatusBadRequest, CodeInvalidJSON, "", "error during unmarshaling body")
+	errPreconditionFailed = newRequestError(http.StatusPreconditionFailed, CodePreconditionFail, "If-Match", "the device was modified")
+	errSerialMismatch     = newRequestError(http.StatusBadRequest, CodeInvalidRequest, "serial_num", "serial number does not match the resource")
+)
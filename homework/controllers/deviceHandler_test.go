@@ -25,7 +25,7 @@ func TestGetDeviceInfo(t *testing.T) {
     expectedDevice := models.Device{SerialNum: "123456", Model: "model1", IP: "1.1.1.1"}
 
 
-    mockService.On("GetDevice", "123456").Return(expectedDevice, nil)
+    mockService.On("GetDevice", mock.Anything, "123456").Return(expectedDevice, nil)
 
     handler.GetDeviceInfo(w, r)
 
@@ -67,16 +67,17 @@ func TestGetDeviceInfo_DeviceNotFound(t *testing.T) {
     w := httptest.NewRecorder()
     r := httptest.NewRequest(http.MethodGet, "/get?serial_num=123456", nil)
    
-	mockService.On("GetDevice", "123456").Return(models.Device{}, fmt.Errorf("Device not found"))
+	mockService.On("GetDevice", mock.Anything, "123456").Return(models.Device{}, fmt.Errorf("%q :%w", "123456", models.ErrNotFound))
     handler.GetDeviceInfo(w, r)
 
 
-    assert.Equal(t, http.StatusBadRequest, w.Code)
+    assert.Equal(t, http.StatusNotFound, w.Code)
 
     var responseBody map[string]string
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
-    assert.Equal(t, "Device not found", responseBody["message"])
+    assert.Equal(t, CodeNotFound, responseBody["code"])
+    assert.Equal(t, `"123456" :not found`, responseBody["message"])
 }
 
 func TestCreateDevice_Success(t *testing.T) {
@@ -90,7 +91,7 @@ func TestCreateDevice_Success(t *testing.T) {
 		Model:     "model1",
 		IP:        "1.1.1.1",
 	}
-	mockService.On("CreateDevice", device).Return(nil).Times(1)
+	mockService.On("CreateDevice", mock.Anything, device).Return(nil).Times(1)
     
 
 	deviceBytes, _ := json.Marshal(&device)
@@ -124,7 +125,7 @@ func TestCreateDevice_ErrorDuringReadingBody(t *testing.T) {
 
     handler.CreateDevice(w, r)
 
-    assert.Equal(t, http.StatusInternalServerError, w.Code)
+    assert.Equal(t, http.StatusBadRequest, w.Code)
     var responseBody map[string]string
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
@@ -152,12 +153,15 @@ func TestCreateDevice_InvalidDevice(t *testing.T) {
 
     handler.CreateDevice(w, r)
 
-    assert.Equal(t, http.StatusBadRequest, w.Code)
+    assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 
-    var responseBody map[string]string
+    var responseBody ErrorResponse
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
-    assert.Equal(t, "Invalid date", responseBody["message"])
+    assert.Equal(t, CodeValidationFailed, responseBody.Code)
+    assert.Equal(t, []services.Violation{
+        {Field: "serial_num", Code: services.CodeRequired, Message: "Invalid serial number"},
+    }, responseBody.Errors)
 }
 
 func TestCreateDevice_DeviceAlreadyExists(t *testing.T) {
@@ -177,16 +181,17 @@ func TestCreateDevice_DeviceAlreadyExists(t *testing.T) {
 		`),
 	)),)
 
-    mockService.On("CreateDevice", mock.Anything).Return(fmt.Errorf("Device with the same serial number already exist"))
+    mockService.On("CreateDevice", mock.Anything, mock.Anything).Return(fmt.Errorf("%q :%w", "123456", models.ErrAlredyExist))
 
     handler.CreateDevice(w, r)
 
-    assert.Equal(t, http.StatusBadRequest, w.Code)
+    assert.Equal(t, http.StatusConflict, w.Code)
 
     var responseBody map[string]string
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
-    assert.Equal(t, "Device with the same serial number already exist", responseBody["message"])
+    assert.Equal(t, CodeAlreadyExists, responseBody["code"])
+    assert.Equal(t, `"123456" :already exist`, responseBody["message"])
 }
 
 func TestDeleteDevice(t *testing.T) {
@@ -197,7 +202,7 @@ func TestDeleteDevice(t *testing.T) {
     w := httptest.NewRecorder()
     r := httptest.NewRequest(http.MethodDelete, "/delte?serial_num=123456", nil)
 
-    mockService.On("DeleteDevice","123456").Return(nil)
+    mockService.On("DeleteDevice", mock.Anything, "123456").Return(nil)
 
     handler.RemoveDevice(w, r)
 
@@ -234,17 +239,17 @@ func TestDeleteDeviceErrorNotFound(t *testing.T) {
 
     w := httptest.NewRecorder()
     r := httptest.NewRequest(http.MethodDelete, "/device?serial_num=123456", nil)
-	mockService.On("DeleteDevice","123456").Return(fmt.Errorf("Device not found"))
+	mockService.On("DeleteDevice", mock.Anything, "123456").Return(fmt.Errorf("%q :%w", "123456", models.ErrNotFound))
 
     handler.RemoveDevice(w, r)
 
 
-    assert.Equal(t, http.StatusBadRequest, w.Code)
+    assert.Equal(t, http.StatusNotFound, w.Code)
 
     var responseBody map[string]string
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
-    assert.Equal(t, "Device not found", responseBody["message"])
+    assert.Equal(t, `"123456" :not found`, responseBody["message"])
 }
 
 func TestUpdateDevice_Success(t *testing.T) {
@@ -265,11 +270,12 @@ func TestUpdateDevice_Success(t *testing.T) {
 	)),)
 
 
-	mockService.On("UpdateDevice", mock.Anything).Return(nil)
+	mockService.On("UpdateDevice", mock.Anything, mock.Anything).Return(models.Device{SerialNum: "123456", Version: 2}, nil)
 
     handler.UpdateDevice(w, r)
 
     assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
 
 }
 
@@ -292,7 +298,7 @@ func TestUpdateDeviceErrorBadJson(t *testing.T) {
 
     handler.UpdateDevice(w, r)
 
-    assert.Equal(t, http.StatusInternalServerError, w.Code)
+    assert.Equal(t, http.StatusBadRequest, w.Code)
     var responseBody map[string]string
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
@@ -318,12 +324,15 @@ func TestUpdateDevice_InvalidDevice(t *testing.T) {
 
     handler.UpdateDevice(w, r)
 
-    assert.Equal(t, http.StatusBadRequest, w.Code)
+    assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 
-    var responseBody map[string]string
+    var responseBody ErrorResponse
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
-    assert.Equal(t, "Invalid date", responseBody["message"])
+    assert.Equal(t, CodeValidationFailed, responseBody.Code)
+    assert.Equal(t, []services.Violation{
+        {Field: "serial_num", Code: services.CodeRequired, Message: "Invalid serial number"},
+    }, responseBody.Errors)
 }
 
 func TestUpdateDeviceErrorNotFound(t *testing.T) {
@@ -344,15 +353,15 @@ func TestUpdateDeviceErrorNotFound(t *testing.T) {
 	)),)
 
 
-    mockService.On("UpdateDevice", mock.Anything).Return(fmt.Errorf("Device not found"))
+    mockService.On("UpdateDevice", mock.Anything, mock.Anything).Return(models.Device{}, fmt.Errorf("%q :%w", "123456", models.ErrNotFound))
 
     handler.UpdateDevice(w, r)
 
 
-    assert.Equal(t, http.StatusBadRequest, w.Code)
+    assert.Equal(t, http.StatusNotFound, w.Code)
 
     var responseBody map[string]string
     err := json.Unmarshal(w.Body.Bytes(), &responseBody)
     assert.NoError(t, err)
-    assert.Equal(t, "Device not found", responseBody["message"])
+    assert.Equal(t, `"123456" :not found`, responseBody["message"])
 }
\ No newline at end of file
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"homework/models"
+	"homework/services"
+	"log"
+	"net/http"
+)
+
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeInvalidJSON       = "invalid_json"
+	CodeValidationFailed  = "validation_failed"
+	CodeNotFound          = "not_found"
+	CodeAlreadyExists     = "already_exists"
+	CodeIPConflict        = "ip_conflict"
+	CodeMACConflict       = "mac_conflict"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodePreconditionFail  = "precondition_failed"
+	CodeInvalidPatch      = "invalid_patch"
+	CodePatchTestFailed   = "patch_test_failed"
+	CodeUnsupportedMedia  = "unsupported_media_type"
+	CodeInvalidRow        = "invalid_row"
+	CodeTooLarge          = "too_large"
+	CodeInvalidSnapshot   = "invalid_snapshot"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeInvalidTransition = "invalid_transition"
+	CodeInvalidSelector   = "invalid_selector"
+	CodeCanceled          = "canceled"
+	CodeTimeout           = "timeout"
+	CodeInternal          = "internal"
+)
+
+// ErrorResponse is the body of every non-2xx response.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	// Errors lists every invalid field of a 422 response.
+	Errors []services.Violation `json:"errors,omitempty"`
+}
+
+// StatusClientClosedRequest answers a request whose client went away before
+// it was served, as nginx logs it. Nobody reads it but the access logs.
+const StatusClientClosedRequest = 499
+
+// domainErrors translates the sentinel errors of the models package, and the
+// ones of the request context, the first entry matching with errors.Is wins.
+var domainErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{models.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{models.ErrAlredyExist, http.StatusConflict, CodeAlreadyExists},
+	{models.ErrIPConflict, http.StatusConflict, CodeIPConflict},
+	{models.ErrMACConflict, http.StatusConflict, CodeMACConflict},
+	{models.ErrVersionMismatch, http.StatusPreconditionFailed, CodePreconditionFail},
+	{models.ErrInvalidPatch, http.StatusBadRequest, CodeInvalidPatch},
+	{models.ErrPatchTestFailed, http.StatusConflict, CodePatchTestFailed},
+	{models.ErrUnsupportedPatch, http.StatusUnsupportedMediaType, CodeUnsupportedMedia},
+	{models.ErrInvalidSnapshot, http.StatusBadRequest, CodeInvalidSnapshot},
+	{models.ErrUnauthorized, http.StatusUnauthorized, CodeUnauthorized},
+	{models.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{models.ErrInvalidTransition, http.StatusConflict, CodeInvalidTransition},
+	{models.ErrInvalidSelector, http.StatusBadRequest, CodeInvalidSelector},
+	// The end of the request context is not a failure of the server.
+	{context.Canceled, StatusClientClosedRequest, CodeCanceled},
+	{context.DeadlineExceeded, http.StatusServiceUnavailable, CodeTimeout},
+}
+
+// requestError is raised by the handlers themselves when the request cannot
+// reach the service layer.
+type requestError struct {
+	status int
+	body   ErrorResponse
+}
+
+func (e *requestError) Error() string {
+	return e.body.Message
+}
+
+func newRequestError(status int, code, field, message string) error {
+	return &requestError{
+		status: status,
+		body:   ErrorResponse{Code: code, Message: message, Field: field},
+	}
+}
+
+func errorResponse(err error) (int, ErrorResponse) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.status, reqErr.body
+	}
+
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			Code:    CodeValidationFailed,
+			Message: "the request is invalid",
+			Errors:  validationErr.Violations,
+		}
+	}
+
+	for _, e := range domainErrors {
+		if errors.Is(err, e.err) {
+			return e.status, ErrorResponse{Code: e.code, Message: err.Error()}
+		}
+	}
+
+	log.Printf("unexpected error: %v", err)
+	return http.StatusInternalServerError, ErrorResponse{Code: CodeInternal, Message: "internal server error"}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, body := errorResponse(err)
+	responseBody, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(responseBody)
+}
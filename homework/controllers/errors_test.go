@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"homework/models"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   ErrorResponse
+	}{
+		{
+			"not found",
+			fmt.Errorf("%q :%w", "123", models.ErrNotFound),
+			http.StatusNotFound,
+			ErrorResponse{Code: CodeNotFound, Message: `"123" :not found`},
+		},
+		{
+			"already exist",
+			fmt.Errorf("%q :%w", "123", models.ErrAlredyExist),
+			http.StatusConflict,
+			ErrorResponse{Code: CodeAlreadyExists, Message: `"123" :already exist`},
+		},
+		{
+			"request error",
+			errSerialMismatch,
+			http.StatusBadRequest,
+			ErrorResponse{Code: CodeInvalidRequest, Message: "serial number does not match the resource", Field: "serial_num"},
+		},
+		{
+			"client gone",
+			fmt.Errorf("list devices: %w", context.Canceled),
+			StatusClientClosedRequest,
+			ErrorResponse{Code: CodeCanceled, Message: "list devices: context canceled"},
+		},
+		{
+			"deadline",
+			fmt.Errorf("list devices: %w", context.DeadlineExceeded),
+			http.StatusServiceUnavailable,
+			ErrorResponse{Code: CodeTimeout, Message: "list devices: context deadline exceeded"},
+		},
+		{
+			"unknown error is hidden",
+			errors.New("disk is on fire"),
+			http.StatusInternalServerError,
+			ErrorResponse{Code: CodeInternal, Message: "internal server error"},
+		},
+	}
+	for _, test := range tests {
+		status, body := errorResponse(test.err)
+		assert.Equal(t, test.status, status, test.name)
+		assert.Equal(t, test.body, body, test.name)
+	}
+}
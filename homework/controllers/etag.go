@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"homework/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch returns the version required by the If-Match header, or
+// models.AnyVersion when the request is unconditional.
+func ifMatch(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return models.AnyVersion, nil
+	}
+	if strings.HasPrefix(value, "W/") {
+		// If-Match uses the strong comparison, a weak tag never matches.
+		return 0, errPreconditionFailed
+	}
+	version, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+	if err != nil || version <= 0 || value != etag(version) {
+		return 0, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "If-Match", "If-Match must be a single entity tag")
+	}
+	return version, nil
+}
+
+func notModified(r *http.Request, version int64) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"homework/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int64
+		status  int
+	}{
+		{"", models.AnyVersion, 0},
+		{"*", models.AnyVersion, 0},
+		{`"3"`, 3, 0},
+		{`W/"3"`, 0, http.StatusPreconditionFailed},
+		{`"3", "4"`, 0, http.StatusBadRequest},
+		{"3", 0, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/devices/1", nil)
+		r.Header.Set("If-Match", test.header)
+
+		version, err := ifMatch(r)
+		if test.status != 0 {
+			status, _ := errorResponse(err)
+			assert.Equal(t, test.status, status, test.header)
+			continue
+		}
+		assert.NoError(t, err, test.header)
+		assert.Equal(t, test.version, version, test.header)
+	}
+}
+
+func TestRouterGetDeviceETag(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("GetDevice", mock.Anything, "1").Return(models.Device{SerialNum: "1", Model: "m", IP: "1.1.1.1", Version: 7}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"7"`, w.Header().Get("ETag"))
+
+	r := httptest.NewRequest(http.MethodGet, "/devices/1", nil)
+	r.Header.Set("If-None-Match", `"7"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+}
+
+func TestRouterUpdateDeviceIfMatch(t *testing.T) {
+	mockService, router := newTestRouter()
+	device := models.Device{SerialNum: "1", Model: "m", IP: "1.1.1.1"}
+	stored := device
+	stored.Version = 8
+	mockService.On("CompareAndSwapDevice", mock.Anything, device, int64(7)).Return(stored, nil)
+	mockService.On("CompareAndSwapDevice", mock.Anything, device, int64(6)).
+		Return(models.Device{}, fmt.Errorf("%q is at version 7, not 6 :%w", "1", models.ErrVersionMismatch))
+
+	r := httptest.NewRequest(http.MethodPut, "/devices/1", bytes.NewBufferString(`{"model": "m", "ip": "1.1.1.1"}`))
+	r.Header.Set("If-Match", `"7"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"8"`, w.Header().Get("ETag"))
+
+	r = httptest.NewRequest(http.MethodPut, "/devices/1", bytes.NewBufferString(`{"model": "m", "ip": "1.1.1.1"}`))
+	r.Header.Set("If-Match", `"6"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestRouterPatchDeviceIfMatch(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("GetDevice", mock.Anything, "1").Return(models.Device{SerialNum: "1", Model: "m", IP: "1.1.1.1", Version: 7}, nil)
+
+	r := httptest.NewRequest(http.MethodPatch, "/devices/1", bytes.NewBufferString(`{"ip": "1.1.1.2"}`))
+	r.Header.Set("If-Match", `"6"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	mockService.AssertNotCalled(t, "CompareAndSwapDevice")
+}
+
+func TestRouterDeleteDeviceIfMatch(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("CompareAndDeleteDevice", mock.Anything, "1", int64(7)).Return(nil)
+
+	r := httptest.NewRequest(http.MethodDelete, "/devices/1", nil)
+	r.Header.Set("If-Match", `"7"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestUpdateDeviceETagIsStored(t *testing.T) {
+	router := newInventoryRouter(t, models.Device{SerialNum: "1", Model: "m", IP: "1.1.1.1"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/devices/1", bytes.NewBufferString(`{"model": "m2", "ip": "1.1.1.1"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	written := w.Header().Get("ETag")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/1", nil))
+	assert.Equal(t, `"2"`, written)
+	assert.Equal(t, written, w.Header().Get("ETag"))
+}
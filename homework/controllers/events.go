@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"homework/models"
+	"homework/services"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EventStreamReset is sent instead of the missed events when a client
+	// resumes after events it can no longer get, it must reload the devices.
+	EventStreamReset = "stream.reset"
+
+	eventsHeartbeat = 15 * time.Second
+	// eventsRetry is the reconnection delay suggested to the clients, in
+	// milliseconds.
+	eventsRetry = 3000
+)
+
+// EventsHandler streams the change events as Server-Sent Events. The event
+// IDs are "<epoch>-<id>", so an ID of an earlier run of the server is told
+// apart from the current ones.
+type EventsHandler struct {
+	bus       *services.EventBus
+	heartbeat time.Duration
+}
+
+func NewEventsHandler(bus *services.EventBus) *EventsHandler {
+	return &EventsHandler{
+		bus:       bus,
+		heartbeat: eventsHeartbeat,
+	}
+}
+
+// NewEventsRouter serves the /events endpoint.
+func NewEventsRouter(e *EventsHandler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/events", route{
+		http.MethodGet: e.Stream,
+	})
+	return mux
+}
+
+// Stream serves GET /events. A client resumes after the event named by its
+// Last-Event-ID header, or by the last_event_id parameter.
+func (e *EventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var (
+		missed []models.Event
+		reset  bool
+		sub    *services.Subscription
+	)
+	if lastID, ok := e.parseEventID(lastEventID); ok {
+		var complete bool
+		missed, complete, sub = e.bus.Resume(lastID)
+		reset = !complete
+	} else {
+		sub = e.bus.Subscribe()
+		reset = lastEventID != ""
+	}
+	defer e.bus.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server.
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventsRetry); err != nil {
+		return
+	}
+
+	if reset {
+		if e.writeReset(w, sub.Start) != nil {
+			return
+		}
+		missed = nil
+	}
+	for _, event := range missed {
+		if e.writeEvent(w, event) != nil {
+			return
+		}
+	}
+	_ = rc.Flush()
+
+	ticker := time.NewTicker(e.heartbeat)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			err = e.writeEvent(w, event)
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		_ = rc.Flush()
+	}
+}
+
+// parseEventID returns the event number of id, ok is false when id does not
+// belong to the bus.
+func (e *EventsHandler) parseEventID(id string) (lastID uint64, ok bool) {
+	epoch, n, ok := strings.Cut(id, "-")
+	if !ok || epoch != e.bus.Epoch() {
+		return 0, false
+	}
+	lastID, err := strconv.ParseUint(n, 10, 64)
+	return lastID, err == nil
+}
+
+func (e *EventsHandler) eventID(id uint64) string {
+	return e.bus.Epoch() + "-" + strconv.FormatUint(id, 10)
+}
+
+func (e *EventsHandler) writeEvent(w http.ResponseWriter, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.eventID(event.ID), event.Type, data)
+	return err
+}
+
+// writeReset tells the client it missed events, the stream goes on after
+// lastID.
+func (e *EventsHandler) writeReset(w http.ResponseWriter, lastID uint64) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: {}\n\n", e.eventID(lastID), EventStreamReset)
+	return err
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"homework/models"
+	"homework/services"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// openStream connects to /events and returns a function reading the next
+// event, comments and retry fields are skipped.
+func openStream(t *testing.T, url, lastEventID string) func() sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	return func() sseEvent {
+		t.Helper()
+		var e sseEvent
+		for lines.Scan() {
+			field, value, _ := strings.Cut(lines.Text(), ": ")
+			switch field {
+			case "id":
+				e.id = value
+			case "event":
+				e.event = value
+			case "data":
+				e.data = value
+			case "":
+				if e.event != "" {
+					return e
+				}
+			}
+		}
+		require.FailNow(t, "stream ended", "%v", lines.Err())
+		return e
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	bus := services.NewEventBus(2)
+	srv := httptest.NewServer(NewEventsRouter(NewEventsHandler(bus)))
+	defer srv.Close()
+	// The subscription is made once the response headers are sent.
+	next := openStream(t, srv.URL, "")
+
+	bus.Publish(models.Event{Type: models.EventCreated, SerialNum: "1", Device: &models.Device{SerialNum: "1", Model: "m"}})
+	e := next()
+	assert.Equal(t, bus.Epoch()+"-1", e.id)
+	assert.Equal(t, models.EventCreated, e.event)
+	var event models.Event
+	require.NoError(t, json.Unmarshal([]byte(e.data), &event))
+	assert.Equal(t, "m", event.Device.Model)
+
+	bus.Publish(models.Event{Type: models.EventDeleted, SerialNum: "1"}, models.Event{Type: models.EventRestored, SerialNum: "1"})
+	assert.Equal(t, bus.Epoch()+"-2", next().id)
+	assert.Equal(t, bus.Epoch()+"-3", next().id)
+
+	// Resuming gets the events after the last one seen.
+	e = openStream(t, srv.URL, bus.Epoch()+"-2")()
+	assert.Equal(t, bus.Epoch()+"-3", e.id)
+	assert.Equal(t, models.EventRestored, e.event)
+
+	// Event 1 is no longer kept, and other epochs are unknown, the client
+	// must reload the devices.
+	for i, lastEventID := range []string{bus.Epoch() + "-0", bus.Epoch() + "-x", "old-3"} {
+		next := openStream(t, srv.URL, lastEventID)
+		e := next()
+		assert.Equal(t, EventStreamReset, e.event, lastEventID)
+		assert.Equal(t, bus.Epoch()+"-"+strconv.Itoa(3+i), e.id, lastEventID)
+		bus.Publish(models.Event{Type: models.EventUpdated, SerialNum: "1"})
+		assert.Equal(t, models.EventUpdated, next().event, lastEventID)
+	}
+
+	// The streams end with the bus.
+	resp, err := http.Get(srv.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	bus.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "retry: 3000\n\n", string(body))
+}
+
+func TestWebhookRoutes(t *testing.T) {
+	dispatcher := services.NewWebhookDispatcher(http.DefaultClient, services.DefaultRetryPolicy)
+	defer dispatcher.Close()
+	router := NewWebhookRouter(NewWebhookHandler(dispatcher))
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	w := serve(http.MethodPost, "/webhooks", `{"url":"http://127.0.0.1:1/hook","types":["device.deleted"]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var webhook models.Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &webhook))
+	assert.NotEmpty(t, webhook.Secret)
+	assert.Equal(t, "/webhooks/"+webhook.ID, w.Header().Get("Location"))
+
+	w = serve(http.MethodGet, "/webhooks", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"webhooks":[{"id":"`+webhook.ID+`","url":"http://127.0.0.1:1/hook","types":["device.deleted"]}]}`, w.Body.String())
+
+	w = serve(http.MethodPost, "/webhooks", `{"url":"nope"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), services.CodeInvalidURL)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/webhooks", `{`).Code)
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/webhooks/"+webhook.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/webhooks/"+webhook.ID, "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, "/webhooks/"+webhook.ID, "").Code)
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// TransitionRequest is the body of POST /devices/{serial_num}/transitions.
+type TransitionRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// TransitionDevice serves POST /devices/{serial_num}/transitions, it moves
+// the device to another lifecycle status and answers with the device. A move
+// the lifecycle does not allow is a 409.
+func (h *Handler) TransitionDevice(w http.ResponseWriter, r *http.Request) {
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errReadBody)
+		return
+	}
+	var req TransitionRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		writeError(w, errUnmarshalBody)
+		return
+	}
+
+	device, err := h.service.TransitionDevice(r.Context(), serialNumFrom(r), req.Status, req.Reason, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(device.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(device)
+}
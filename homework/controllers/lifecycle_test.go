@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitionDevice(t *testing.T) {
+	router := newInventoryRouter(t, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: models.StatusProvisioning})
+	transition := func(body, ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/devices/1/transitions", strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := transition(`{"status":"active","reason":"racked"}`, `"1"`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	var device models.Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &device))
+	assert.Equal(t, models.StatusActive, device.Status)
+	assert.Equal(t, "racked", device.StatusReason)
+
+	tests := []struct {
+		body, ifMatch string
+		status        int
+		code          string
+	}{
+		{`{"status":"provisioning","reason":"reinstall"}`, "", http.StatusConflict, CodeInvalidTransition},
+		{`{"status":"maintenance"}`, "", http.StatusUnprocessableEntity, CodeValidationFailed},
+		{`{"status":"maintenance","reason":"fan swap"}`, `"1"`, http.StatusPreconditionFailed, CodePreconditionFail},
+		{`{"status":`, "", http.StatusBadRequest, CodeInvalidJSON},
+	}
+	for _, test := range tests {
+		w := transition(test.body, test.ifMatch)
+
+		assert.Equal(t, test.status, w.Code, test.body)
+		var body ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), test.body)
+		assert.Equal(t, test.code, body.Code, test.body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/1/transitions", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
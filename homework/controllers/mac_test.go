@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDeviceByMAC(t *testing.T) {
+	router := newInventoryRouter(t, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: []string{"00:00:5e:00:53:01", "00:00:5e:00:53:02"}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/by-mac/00-00-5E-00-53-02", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var device models.Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &device))
+	assert.Equal(t, "1", device.SerialNum)
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/devices/by-mac/00:00:5e:00:53:03", http.StatusNotFound, CodeNotFound},
+		{"/devices/by-mac/nope", http.StatusUnprocessableEntity, CodeValidationFailed},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		assert.Equal(t, test.status, w.Code, test.path)
+		var body ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), test.path)
+		assert.Equal(t, test.code, body.Code, test.path)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices/by-mac/00:00:5e:00:53:01", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestCreateDeviceMACConflict(t *testing.T) {
+	router := newInventoryRouter(t, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: []string{"00:00:5e:00:53:01"}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices",
+		strings.NewReader(`{"serial_num": "2", "model": "m", "ip": "10.0.0.2", "macs": ["0000.5e00.5301"]}`)))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var body ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeMACConflict, body.Code)
+}
+
+func TestImportDevicesLegacyMAC(t *testing.T) {
+	router := newInventoryRouter(t)
+	body := "serial_num,model,ip,mac\n1,m,10.0.0.1,00-00-5E-00-53-01\n"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices/import?format=csv", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/1", nil))
+	var device models.Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &device))
+	assert.Equal(t, []string{"00:00:5e:00:53:01"}, device.MACs)
+	assert.Empty(t, device.MAC)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	serialNumKey contextKey = iota
+	ipKey
+	macKey
+)
+
+// route dispatches a request to the handler registered for its method and
+// answers 405 with an Allow header otherwise.
+type route map[string]http.HandlerFunc
+
+func (rt route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := rt[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		handler, ok = rt[http.MethodGet]
+	}
+	if !ok {
+		w.Header().Set("Allow", rt.allow())
+		writeError(w, newRequestError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "", "method not allowed"))
+		return
+	}
+	handler(w, r)
+}
+
+func (rt route) allow() string {
+	methods := make([]string, 0, len(rt)+1)
+	for method := range rt {
+		methods = append(methods, method)
+	}
+	if _, ok := rt[http.MethodGet]; ok {
+		methods = append(methods, http.MethodHead)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// deprecated marks responses of the legacy query-string routes so clients can
+// find their replacement under /devices.
+func deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</devices>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func NewRouter(h *Handler) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/devices", route{
+		http.MethodGet:  h.ListDevices,
+		http.MethodPost: h.CreateDeviceResource,
+	})
+	item := route{
+		http.MethodGet:    h.GetDeviceInfo,
+		http.MethodPut:    h.UpdateDevice,
+		http.MethodPatch:  h.PatchDevice,
+		http.MethodDelete: h.DeleteDeviceResource,
+	}
+	// collection routes live under /devices/ next to the devices, their
+	// names are in services.ReservedSerialNums.
+	collection := map[string]http.Handler{
+		"batch": route{
+			http.MethodPost:   h.CreateDevices,
+			http.MethodPut:    h.UpdateDevices,
+			http.MethodDelete: h.DeleteDevices,
+		},
+		"export": route{
+			http.MethodGet: h.ExportDevices,
+		},
+		"import": route{
+			http.MethodPost: h.ImportDevices,
+		},
+		"trash": route{
+			http.MethodGet: h.ListDeletedDevices,
+		},
+	}
+	byIP := route{
+		http.MethodGet: h.GetDeviceByIP,
+	}
+	byMAC := route{
+		http.MethodGet: h.GetDeviceByMAC,
+	}
+	// subresources live under /devices/{serial_num}/.
+	subresources := map[string]http.Handler{
+		// The history tells who did what, it is for admins only like /audit.
+		"history": h.requireAdmin(route{
+			http.MethodGet: h.DeviceHistory,
+		}),
+		"restore": route{
+			http.MethodPost: h.RestoreDevice,
+		},
+		"transitions": route{
+			http.MethodPost: h.TransitionDevice,
+		},
+	}
+	mux.HandleFunc("/devices/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/devices/"), "/")
+		switch {
+		case len(parts) == 1 && collection[parts[0]] != nil:
+			collection[parts[0]].ServeHTTP(w, r)
+		case len(parts) == 1 && parts[0] != "":
+			item.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serialNumKey, parts[0])))
+		case len(parts) == 2 && parts[0] == "by-ip" && parts[1] != "":
+			byIP.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ipKey, parts[1])))
+		case len(parts) == 2 && parts[0] == "by-mac" && parts[1] != "":
+			byMAC.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), macKey, parts[1])))
+		case len(parts) == 2 && parts[0] != "" && subresources[parts[1]] != nil:
+			subresources[parts[1]].ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serialNumKey, parts[0])))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	// The audit trail tells who did what, it is for admins only.
+	mux.Handle("/audit", h.requireAdmin(route{
+		http.MethodGet: h.ListAudit,
+	}))
+
+	mux.Handle("/get", deprecated(route{
+		http.MethodGet: h.GetDeviceInfo,
+	}))
+	mux.Handle("/create", deprecated(route{
+		http.MethodPost: h.CreateDevice,
+	}))
+	mux.Handle("/update", deprecated(route{
+		http.MethodPost: h.UpdateDevice,
+		http.MethodPut:  h.UpdateDevice,
+	}))
+	mux.Handle("/delete", deprecated(route{
+		http.MethodPost:   h.RemoveDevice,
+		http.MethodDelete: h.RemoveDevice,
+	}))
+
+	return mux
+}
+
+// serialNumFrom prefers the /devices/{serial_num} path segment and falls back
+// to the serial_num query parameter used by the legacy routes.
+func serialNumFrom(r *http.Request) string {
+	if serialNum, ok := r.Context().Value(serialNumKey).(string); ok {
+		return serialNum
+	}
+	return r.URL.Query().Get("serial_num")
+}
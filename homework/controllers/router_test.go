@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	servMock "homework/controllers/mocks"
+	"homework/models"
+	"homework/services"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouter() (*servMock.Service, http.Handler) {
+	mockService := new(servMock.Service)
+	return mockService, NewRouter(NewHandler(services.NewService(mockService)))
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		allow  string
+	}{
+		{http.MethodPut, "/devices", "GET, HEAD, POST"},
+		{http.MethodPost, "/devices/123", "DELETE, GET, HEAD, PATCH, PUT"},
+		{http.MethodGet, "/devices/batch", "DELETE, POST, PUT"},
+		{http.MethodGet, "/delete?serial_num=123", "DELETE, POST"},
+		{http.MethodGet, "/create", "POST"},
+		{http.MethodDelete, "/get?serial_num=123", "GET, HEAD"},
+	}
+	for _, test := range tests {
+		_, router := newTestRouter()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, test.target)
+		assert.Equal(t, test.allow, w.Header().Get("Allow"), test.target)
+	}
+}
+
+func TestRouterGetDevice(t *testing.T) {
+	mockService, router := newTestRouter()
+	expectedDevice := models.Device{SerialNum: "123456", Model: "model1", IP: "1.1.1.1"}
+	mockService.On("GetDevice", mock.Anything, "123456").Return(expectedDevice, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/123456", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var responseDevice models.Device
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseDevice))
+	assert.Equal(t, expectedDevice, responseDevice)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+}
+
+func TestRouterCreateDevice(t *testing.T) {
+	mockService, router := newTestRouter()
+	device := models.Device{SerialNum: "123456", Model: "model1", IP: "1.1.1.1"}
+	mockService.On("CreateDevice", mock.Anything, device).Return(nil)
+
+	body, _ := json.Marshal(device)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/devices/123456", w.Header().Get("Location"))
+	mockService.AssertExpectations(t)
+}
+
+func TestRouterUpdateDevice(t *testing.T) {
+	mockService, router := newTestRouter()
+	device := models.Device{SerialNum: "123456", Model: "model2", IP: "1.1.1.2"}
+	stored := device
+	stored.Version = 4
+	mockService.On("UpdateDevice", mock.Anything, device).Return(stored, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/devices/123456",
+		bytes.NewBufferString(`{"model": "model2", "ip": "1.1.1.2"}`)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"), "an unconditional update has an ETag too")
+	mockService.AssertExpectations(t)
+}
+
+func TestRouterUpdateDeviceSerialMismatch(t *testing.T) {
+	_, router := newTestRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/devices/123456",
+		bytes.NewBufferString(`{"serial_num": "654321", "model": "model2", "ip": "1.1.1.2"}`)))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRouterPatchDevice(t *testing.T) {
+	mockService, router := newTestRouter()
+	stored := models.Device{SerialNum: "123456", Model: "model1", IP: "1.1.1.1", Version: 3}
+	patched := models.Device{SerialNum: "123456", Model: "model1", IP: "1.1.1.2", Version: 3}
+	mockService.On("GetDevice", mock.Anything, "123456").Return(stored, nil)
+	written := patched
+	written.Version = 4
+	mockService.On("CompareAndSwapDevice", mock.Anything, patched, int64(3)).Return(written, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/devices/123456",
+		bytes.NewBufferString(`{"ip": "1.1.1.2"}`)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	var got models.Device
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "1.1.1.2", got.IP)
+	mockService.AssertExpectations(t)
+}
+
+func TestRouterPatchDeviceMediaTypes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json-patch+json", `[{"op": "replace", "path": "/ip", "value": "1.1.1.2"}]`, http.StatusOK},
+		{"application/merge-patch+json; charset=utf-8", `{"ip": "1.1.1.2"}`, http.StatusOK},
+		{"application/json-patch+json", `[{"op": "test", "path": "/ip", "value": "1.1.1.9"}]`, http.StatusConflict},
+		{"application/json-patch+json", `{"ip": "1.1.1.2"}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"serial_num": "654321"}`, http.StatusUnprocessableEntity},
+		{"text/plain", `ip=1.1.1.2`, http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		mockService, router := newTestRouter()
+		mockService.On("GetDevice", mock.Anything, "123456").Return(models.Device{SerialNum: "123456", Model: "model1", IP: "1.1.1.1", Version: 1}, nil)
+		mockService.On("CompareAndSwapDevice", mock.Anything, mock.Anything, int64(1)).Return(models.Device{SerialNum: "123456", Version: 2}, nil)
+
+		r := httptest.NewRequest(http.MethodPatch, "/devices/123456", bytes.NewBufferString(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, test.status, w.Code, test.body)
+	}
+}
+
+func TestRouterDeleteDevice(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("DeleteDevice", mock.Anything, "123456").Return(nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/devices/123456", nil))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestRouterUnknownResource(t *testing.T) {
+	_, router := newTestRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/123/456", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRouterLegacyRoutesAreDeprecated(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("DeleteDevice", mock.Anything, "123456").Return(nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/delete?serial_num=123456", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.Contains(t, w.Header().Get("Link"), "/devices")
+	mockService.AssertExpectations(t)
+}
+
+func TestRouterListDevices(t *testing.T) {
+	mockService, router := newTestRouter()
+	want := models.ListResult{
+		Devices:    []models.Device{{SerialNum: "123456", Model: "model1", IP: "10.0.0.1"}},
+		NextCursor: "next",
+	}
+	mockService.On("ListDevices", mock.Anything, models.ListQuery{
+		Limit:    10,
+		Model:    "model1",
+		IPPrefix: "10.0.0.0/8",
+		SortBy:   models.SortByIP,
+		Desc:     true,
+	}).Return(want, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices?limit=10&model=model1&ip=10.0.0.0/8&sort=-ip", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got models.ListResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, want, got)
+}
+
+func TestRouterListDevicesDetailFilters(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("ListDevices", mock.Anything, models.ListQuery{
+		Limit:    services.DefaultListLimit,
+		SortBy:   models.SortBySerialNum,
+		Vendor:   "acme",
+		Firmware: "1.2",
+		MAC:      "00:00:5e:00:53:01",
+		Hostname: "edge-1",
+		Site:     "ams",
+		Rack:     "a1",
+		Status:   models.StatusActive,
+		Selector: models.Selector{
+			{Key: "env", Op: models.SelectEquals, Values: []string{"prod"}},
+			{Key: "tier", Op: models.SelectEquals, Values: []string{""}},
+		},
+	}).Return(models.ListResult{}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+		"/devices?vendor=acme&firmware=1.2&mac=00:00:5e:00:53:01&hostname=edge-1&site=ams&rack=a1&status=active&label=env=prod&label=tier=", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices?label=env", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRouterListDevicesBadParams(t *testing.T) {
+	_, router := newTestRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices?limit=ten", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices?sort=color", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestRouterGetDeviceByIP(t *testing.T) {
+	mockService, router := newTestRouter()
+	device := models.Device{SerialNum: "123456", Model: "model1", IP: "2001:db8::1", Segment: "lab"}
+	mockService.On("GetDeviceByIP", mock.Anything, "2001:db8::1", "lab").Return(device, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/by-ip/2001:db8::1?segment=lab", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got models.Device
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, device, got)
+}
+
+func TestRouterCreateDeviceIPConflict(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("CreateDevice", mock.Anything, mock.Anything).Return(fmt.Errorf("%q is used by %q :%w", "1.1.1.1", "1", models.ErrIPConflict))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices",
+		bytes.NewBufferString(`{"serial_num": "2", "model": "model1", "ip": "1.1.1.1"}`)))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var body ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeIPConflict, body.Code)
+}
+
+func TestRouterBatch(t *testing.T) {
+	mockService, router := newTestRouter()
+	devices := []models.Device{
+		{SerialNum: "1", Model: "m", IP: "10.0.0.1"},
+		{SerialNum: "2", Model: "m", IP: "10.0.0.1"},
+	}
+	mockService.On("CreateDevices", mock.Anything, devices, models.BatchBestEffort).
+		Return([]error{nil, fmt.Errorf("%q :%w", "10.0.0.1", models.ErrIPConflict)}, nil)
+
+	body, _ := json.Marshal(BatchRequest{Mode: models.BatchBestEffort, Devices: devices})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices/batch", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp BatchResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Succeeded)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Equal(t, BatchItemOK, resp.Results[0].Status)
+	assert.Equal(t, BatchItemFailed, resp.Results[1].Status)
+	assert.Equal(t, CodeIPConflict, resp.Results[1].Error.Code)
+}
+
+func TestRouterBatchAtomicFailure(t *testing.T) {
+	mockService, router := newTestRouter()
+	mockService.On("DeleteDevices", mock.Anything, []string{"1", "2"}, models.BatchAtomic).
+		Return([]error{models.ErrBatchAborted, fmt.Errorf("%q :%w", "2", models.ErrNotFound)}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/devices/batch",
+		bytes.NewBufferString(`{"serial_nums": ["1", "2"]}`)))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var resp BatchResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, models.BatchAtomic, resp.Mode)
+	assert.Equal(t, BatchItemAborted, resp.Results[0].Status)
+	assert.Equal(t, 2, resp.Failed)
+}
+
+func TestRouterSelector(t *testing.T) {
+	router := newInventoryRouter(t,
+		models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Labels: map[string]string{"env": "prod", "rack": "a1"}},
+		models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2", Labels: map[string]string{"env": "prod", "deprecated": ""}},
+		models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3", Labels: map[string]string{"env": "staging"}},
+	)
+	list := func(selector string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices?selector="+url.QueryEscape(selector), nil))
+		return w
+	}
+
+	w := list("env=prod,!deprecated")
+	require.Equal(t, http.StatusOK, w.Code)
+	var result models.ListResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.Len(t, result.Devices, 1)
+	assert.Equal(t, "1", result.Devices[0].SerialNum)
+
+	assert.Equal(t, http.StatusBadRequest, list("rack in (a1").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, list("-env=prod").Code)
+
+	// The bulk deletion takes a selector in place of the serial numbers.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/devices/batch", bytes.NewBufferString(`{"selector":"env in (staging,dev)"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp BatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Succeeded)
+	assert.Equal(t, "3", resp.Results[0].SerialNum)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/devices/batch", bytes.NewBufferString(`{"selector":"env=staging"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"mode":"atomic","succeeded":0,"failed":0,"results":[]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/devices/batch", bytes.NewBufferString(`{"selector":"env","serial_nums":["1"]}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
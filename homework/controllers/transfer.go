@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"homework/models"
+	"homework/services"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// MaxImportSize bounds the body of an import.
+const MaxImportSize = 32 << 20
+
+type ImportRowError struct {
+	// Row is the 1-based position of the device in the import, not counting
+	// the CSV header and blank NDJSON lines.
+	Row       int           `json:"row"`
+	SerialNum string        `json:"serial_num,omitempty"`
+	Error     ErrorResponse `json:"error"`
+}
+
+type ImportResponse struct {
+	Mode    models.ImportMode `json:"mode"`
+	DryRun  bool              `json:"dry_run"`
+	Rows    int               `json:"rows"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+	Errors  []ImportRowError  `json:"errors"`
+}
+
+// ExportDevices serves GET /devices/export?format=csv|ndjson|json with the
+// filters and the order of GET /devices. The devices are streamed one page at
+// a time, so a failure past the first page can only cut the response short.
+func (h *Handler) ExportDevices(w http.ResponseWriter, r *http.Request) {
+	format, err := formatParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	q, err := listQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	q.Cursor = ""
+	q.Limit = services.MaxListLimit
+
+	result, err := h.service.ListDevices(r.Context(), q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="devices.`+format+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	out := newDeviceWriter(format, w)
+	for {
+		for _, d := range result.Devices {
+			if err := out.Write(d); err != nil {
+				return
+			}
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		q.Cursor = result.NextCursor
+		if result, err = h.service.ListDevices(r.Context(), q); err != nil {
+			// A client going away is no news.
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("export interrupted: %v", err)
+			}
+			return
+		}
+	}
+	_ = out.Close()
+}
+
+// ImportDevices serves POST /devices/import?format=csv|ndjson|json&mode=create|upsert&dry_run=true.
+// Every row is imported on its own and the failed ones are listed in the
+// response.
+func (h *Handler) ImportDevices(w http.ResponseWriter, r *http.Request) {
+	format, err := formatParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	mode := models.ImportMode(query.Get("mode"))
+	if mode == "" {
+		mode = models.ImportCreate
+	}
+	var dryRun bool
+	if value := query.Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			writeError(w, newRequestError(http.StatusBadRequest, CodeInvalidRequest, "dry_run", "dry_run must be a boolean"))
+			return
+		}
+	}
+
+	rows, err := readDevices(format, http.MaxBytesReader(w, r.Body, MaxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, newRequestError(http.StatusRequestEntityTooLarge, CodeTooLarge, "", "the import is larger than "+strconv.Itoa(MaxImportSize)+" bytes"))
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var devices []models.Device
+	var index []int
+	for i, row := range rows {
+		if row.err == nil {
+			devices = append(devices, row.device)
+			index = append(index, i)
+		}
+	}
+	results, err := h.service.ImportDevices(r.Context(), devices, mode, dryRun)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := ImportResponse{Mode: mode, DryRun: dryRun, Rows: len(rows), Errors: []ImportRowError{}}
+	for j, i := range index {
+		rows[i].err = results[j].Err
+		if results[j].Err != nil {
+			continue
+		}
+		if results[j].Action == models.ImportUpdated {
+			resp.Updated++
+		} else {
+			resp.Created++
+		}
+	}
+	for i, row := range rows {
+		if row.err == nil {
+			continue
+		}
+		_, body := errorResponse(row.err)
+		resp.Errors = append(resp.Errors, ImportRowError{Row: i + 1, SerialNum: row.device.SerialNum, Error: body})
+		resp.Failed++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"homework/models"
+	"homework/repositories"
+	"homework/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInventoryRouter(t *testing.T, devices ...models.Device) http.Handler {
+	repo := repositories.NewRepoDevice()
+	for _, d := range devices {
+		require.NoError(t, repo.CreateDevice(context.Background(), d))
+	}
+	return NewRouter(NewHandler(services.NewService(repo)))
+}
+
+func TestExportDevices(t *testing.T) {
+	repo := repositories.NewRepoDevice()
+	for _, d := range []models.Device{
+		{SerialNum: "1", Model: "m1", IP: "10.0.0.1", IPv6: "fd00::1", Site: "ams", Labels: map[string]string{"tier": "edge", "env": "prod"}},
+		{SerialNum: "2", Model: "m2", IP: "10.0.0.2", Segment: "lab", Status: models.StatusProvisioning, StatusReason: "racked"},
+	} {
+		require.NoError(t, repo.CreateDevice(context.Background(), d))
+	}
+	router := NewRouter(NewHandler(services.NewService(repo)))
+	d1, err := repo.GetDevice(context.Background(), "1")
+	require.NoError(t, err)
+	d2, err := repo.GetDevice(context.Background(), "2")
+	require.NoError(t, err)
+	t1, t2 := d1.CreatedAt.Format(time.RFC3339Nano), d2.CreatedAt.Format(time.RFC3339Nano)
+	j1, t1JSON := `{"serial_num":"1","model":"m1","ip":"10.0.0.1","ipv6":"fd00::1","site":"ams","status":"active","labels":{"env":"prod","tier":"edge"},"version":1`, `,"created_at":"`+t1+`","updated_at":"`+t1+`"}`
+	j2, t2JSON := `{"serial_num":"2","model":"m2","ip":"10.0.0.2","segment":"lab","status":"provisioning","status_reason":"racked","version":1`, `,"created_at":"`+t2+`","updated_at":"`+t2+`"}`
+
+	tests := []struct {
+		format      string
+		contentType string
+		body        string
+	}{
+		{"csv", "text/csv; charset=utf-8",
+			"serial_num,model,ip,ipv6,segment,vendor,firmware,macs,hostname,site,rack,status,status_reason,labels,version,created_at,updated_at\n" +
+				`1,m1,10.0.0.1,fd00::1,,,,,,ams,,active,,"env=prod,tier=edge",1,` + t1 + "," + t1 + "\n" +
+				"2,m2,10.0.0.2,,lab,,,,,,,provisioning,racked,,1," + t2 + "," + t2 + "\n"},
+		{"ndjson", "application/x-ndjson",
+			j1 + t1JSON + "\n" + j2 + t2JSON + "\n"},
+		{"json", "application/json",
+			"[" + j1 + t1JSON + "\n," + j2 + t2JSON + "\n]\n"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/export?format="+test.format, nil))
+
+		assert.Equal(t, http.StatusOK, w.Code, test.format)
+		assert.Equal(t, test.contentType, w.Header().Get("Content-Type"), test.format)
+		assert.Equal(t, test.body, w.Body.String(), test.format)
+	}
+}
+
+func TestExportDevicesEmptyJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	newInventoryRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/export", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
+
+func TestExportDevicesUnknownFormat(t *testing.T) {
+	w := httptest.NewRecorder()
+	newInventoryRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/export?format=xml", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestImportDevicesCSV(t *testing.T) {
+	router := newInventoryRouter(t, models.Device{SerialNum: "1", Model: "m1", IP: "10.0.0.1"})
+	body := "\ufeffmodel,serial_num,ip\n" +
+		"m9,1,10.0.0.1\n" +
+		"m2,2,10.0.0.2\n" +
+		"m3,3\n" +
+		"m4,4,not-an-ip\n"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices/import?format=csv&mode=upsert", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp ImportResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 4, resp.Rows)
+	assert.Equal(t, 1, resp.Created)
+	assert.Equal(t, 1, resp.Updated)
+	assert.Equal(t, 2, resp.Failed)
+	require.Len(t, resp.Errors, 2)
+	assert.Equal(t, 3, resp.Errors[0].Row)
+	assert.Equal(t, CodeInvalidRow, resp.Errors[0].Error.Code)
+	assert.Equal(t, 4, resp.Errors[1].Row)
+	assert.Equal(t, "4", resp.Errors[1].SerialNum)
+	assert.Equal(t, CodeValidationFailed, resp.Errors[1].Error.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/1", nil))
+	assert.Contains(t, w.Body.String(), `"model":"m9"`)
+}
+
+func TestImportDevicesNDJSONDryRun(t *testing.T) {
+	router := newInventoryRouter(t)
+	body := `{"serial_num":"1","model":"m1","ip":"10.0.0.1"}` + "\n\n" +
+		`{"serial_num":"2","model":"m1","ip":"10.0.0.2","colour":"red"}` + "\n" +
+		`{"serial_num":"1","model":"m1","ip":"10.0.0.3"}`
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices/import?format=ndjson&dry_run=true", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp ImportResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, models.ImportCreate, resp.Mode)
+	assert.Equal(t, 1, resp.Created)
+	require.Len(t, resp.Errors, 2)
+	assert.Equal(t, CodeInvalidRow, resp.Errors[0].Error.Code)
+	assert.Equal(t, CodeAlreadyExists, resp.Errors[1].Error.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/1", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestImportDevicesRoundTrip(t *testing.T) {
+	source := newInventoryRouter(t,
+		models.Device{SerialNum: "1", Model: "m1", IP: "10.0.0.1", IPv6: "fd00::1", Vendor: "acme", Firmware: "1.2",
+			MACs: []string{"00:00:5e:00:53:01", "00:00:5e:00:53:02"}, Hostname: "edge-1", Site: "ams", Rack: "a1", Labels: map[string]string{"env": "prod", "tier": "edge"}},
+		models.Device{SerialNum: "2", Model: "m2", IP: "10.0.0.2", Segment: "lab", Status: models.StatusDecommissioned, StatusReason: "end of life"},
+	)
+	for _, format := range []string{"csv", "ndjson", "json"} {
+		w := httptest.NewRecorder()
+		source.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/export?format="+format, nil))
+
+		target := newInventoryRouter(t)
+		imported := httptest.NewRecorder()
+		target.ServeHTTP(imported, httptest.NewRequest(http.MethodPost, "/devices/import?format="+format, w.Body))
+		var resp ImportResponse
+		require.NoError(t, json.Unmarshal(imported.Body.Bytes(), &resp), format)
+		assert.Equal(t, 2, resp.Created, format)
+		assert.Empty(t, resp.Errors, format)
+
+		for _, serialNum := range []string{"1", "2"} {
+			want, got := httptest.NewRecorder(), httptest.NewRecorder()
+			source.ServeHTTP(want, httptest.NewRequest(http.MethodGet, "/devices/"+serialNum, nil))
+			target.ServeHTTP(got, httptest.NewRequest(http.MethodGet, "/devices/"+serialNum, nil))
+			var wantDevice, gotDevice models.Device
+			require.NoError(t, json.Unmarshal(want.Body.Bytes(), &wantDevice))
+			require.NoError(t, json.Unmarshal(got.Body.Bytes(), &gotDevice))
+			wantDevice.CreatedAt, wantDevice.UpdatedAt = gotDevice.CreatedAt, gotDevice.UpdatedAt
+			assert.Equal(t, wantDevice, gotDevice, format)
+		}
+	}
+}
+
+func TestImportDevicesInvalidInput(t *testing.T) {
+	tests := []struct {
+		target string
+		body   string
+		status int
+	}{
+		{"/devices/import?format=csv", "serial_num,colour\n1,red\n", http.StatusBadRequest},
+		{"/devices/import?format=json", `{"serial_num":"1"}`, http.StatusBadRequest},
+		{"/devices/import?format=json", `[{"serial_num":"1"`, http.StatusBadRequest},
+		{"/devices/import?dry_run=maybe", `[]`, http.StatusBadRequest},
+		{"/devices/import?mode=replace", `[]`, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		newInventoryRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body)))
+
+		assert.Equal(t, test.status, w.Code, test.target+" "+test.body)
+	}
+}
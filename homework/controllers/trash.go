@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"net/http"
+)
+
+type TrashResponse struct {
+	Devices []models.DeletedDevice `json:"devices"`
+}
+
+// ListDeletedDevices serves GET /devices/trash, the deleted devices that can
+// still be restored.
+func (h *Handler) ListDeletedDevices(w http.ResponseWriter, r *http.Request) {
+	deleted, err := h.service.ListDeletedDevices(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if deleted == nil {
+		deleted = []models.DeletedDevice{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(TrashResponse{Devices: deleted})
+}
+
+// RestoreDevice serves POST /devices/{serial_num}/restore.
+func (h *Handler) RestoreDevice(w http.ResponseWriter, r *http.Request) {
+	device, err := h.service.RestoreDevice(r.Context(), serialNumFrom(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(device.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(device)
+}
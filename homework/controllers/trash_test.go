@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrashRoutes(t *testing.T) {
+	router := newInventoryRouter(t, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"})
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := serve(http.MethodGet, "/devices/trash")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"devices":[]}`, w.Body.String())
+
+	require.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/devices/1").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/devices/1").Code)
+
+	w = serve(http.MethodGet, "/devices/trash")
+	require.Equal(t, http.StatusOK, w.Code)
+	var trash TrashResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &trash))
+	require.Len(t, trash.Devices, 1)
+	assert.Equal(t, "1", trash.Devices[0].SerialNum)
+	assert.False(t, trash.Devices[0].DeletedAt.IsZero())
+
+	w = serve(http.MethodPost, "/devices/1/restore")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	var device models.Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &device))
+	assert.Equal(t, "1", device.SerialNum)
+	assert.Equal(t, models.StatusActive, device.Status)
+	assert.Equal(t, int64(2), device.Version)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/devices/1").Code)
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/devices/1/restore").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, "/devices/1/restore").Code)
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+	"homework/models"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Webhooks manages the subscriptions to the change events.
+type Webhooks interface {
+	Add(webhook models.Webhook) (models.Webhook, error)
+	Remove(id string) error
+	List() []models.Webhook
+}
+
+type WebhookHandler struct {
+	webhooks Webhooks
+}
+
+func NewWebhookHandler(webhooks Webhooks) *WebhookHandler {
+	return &WebhookHandler{
+		webhooks: webhooks,
+	}
+}
+
+type WebhooksResponse struct {
+	Webhooks []models.Webhook `json:"webhooks"`
+}
+
+// NewWebhookRouter serves the /webhooks endpoints.
+func NewWebhookRouter(h *WebhookHandler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/webhooks", route{
+		http.MethodGet:  h.ListWebhooks,
+		http.MethodPost: h.AddWebhook,
+	})
+	item := route{
+		http.MethodDelete: h.RemoveWebhook,
+	}
+	mux.HandleFunc("/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+		if id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		item.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// ListWebhooks serves GET /webhooks, the secrets are left out.
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(WebhooksResponse{Webhooks: h.webhooks.List()})
+}
+
+// AddWebhook serves POST /webhooks. The response holds the secret signing the
+// deliveries, generated when the request has none; it is not shown again.
+func (h *WebhookHandler) AddWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook models.Webhook
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errReadBody)
+		return
+	}
+	if err := json.Unmarshal(b, &webhook); err != nil {
+		writeError(w, errUnmarshalBody)
+		return
+	}
+	webhook, err = h.webhooks.Add(webhook)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/webhooks/"+webhook.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(webhook)
+}
+
+// RemoveWebhook serves DELETE /webhooks/{id}.
+func (h *WebhookHandler) RemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := h.webhooks.Remove(strings.TrimPrefix(r.URL.Path, "/webhooks/")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+	// AuditTransition is a change of the lifecycle status.
+	AuditTransition = "transition"
+)
+
+// AuditEntry records one change of a device. Before is nil for a creation
+// and a restore, After for a deletion and a purge.
+type AuditEntry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	SerialNum string    `json:"serial_num"`
+	Before    *Device   `json:"before,omitempty"`
+	After     *Device   `json:"after,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Reason is the reason given for a transition.
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditQuery selects the entries recorded at or after Since whose sequence
+// number is above After, at most Limit of them.
+type AuditQuery struct {
+	Since time.Time
+	After uint64
+	Limit int
+}
@@ -0,0 +1,44 @@
+package models
+
+const (
+	AuthAPIKey = "api_key"
+	AuthToken  = "token"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name string `json:"name"`
+	// Method is AuthAPIKey or AuthToken.
+	Method string `json:"method"`
+	Role   Role   `json:"role"`
+}
+
+// TokenClaims is the payload of a bearer token. Times are Unix seconds.
+type TokenClaims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Role grants a principal every operation of the roles below it.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether r includes required.
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[required]
+}
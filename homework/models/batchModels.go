@@ -0,0 +1,59 @@
+package models
+
+import "errors"
+
+// BatchMode decides what happens to a batch when some of its items fail.
+type BatchMode string
+
+const (
+	// BatchAtomic applies either every item or none of them.
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort applies every item that can be applied.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+// ErrBatchAborted is the result of the items of an atomic batch that would
+// have succeeded but were rolled back because another item failed.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchFailed reports whether any item of a batch failed.
+func BatchFailed(errs []error) bool {
+	for _, err := range errs {
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// AbortBatch turns the successful items of a failed atomic batch into
+// ErrBatchAborted.
+func AbortBatch(errs []error) {
+	for i, err := range errs {
+		if err == nil {
+			errs[i] = ErrBatchAborted
+		}
+	}
+}
+
+// ImportMode decides what an import does with devices that already exist.
+type ImportMode string
+
+const (
+	// ImportCreate rejects the rows of existing devices.
+	ImportCreate ImportMode = "create"
+	// ImportUpsert replaces existing devices and creates the others.
+	ImportUpsert ImportMode = "upsert"
+)
+
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+)
+
+// ImportResult is the outcome of one imported device. Action tells what was
+// (or, in a dry run, would have been) done with it when Err is nil.
+type ImportResult struct {
+	Action string
+	Err    error
+}
@@ -1,7 +1,97 @@
 package models
 
+import (
+	"slices"
+	"time"
+)
+
 type Device struct {
 	SerialNum string `json:"serial_num"`
 	Model     string `json:"model"`
-	IP        string `json:"ip"`
+	// IP is the primary address of either family, IPv6 is the second address
+	// of a dual-stack device whose primary address is IPv4.
+	IP   string `json:"ip"`
+	IPv6 string `json:"ipv6,omitempty"`
+	// Segment is the network segment the addresses belong to, they only have
+	// to be unique inside of it.
+	Segment string `json:"segment,omitempty"`
+
+	Vendor   string `json:"vendor,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+	// MACs are the hardware addresses of the device, unique across the
+	// inventory. MAC is the single address of the first releases, it is
+	// still read but moved to MACs.
+	MACs     []string `json:"macs,omitempty"`
+	MAC      string   `json:"mac,omitempty"`
+	Hostname string   `json:"hostname,omitempty"`
+	// Site and Rack locate the device, a rack is always inside a site.
+	Site string `json:"site,omitempty"`
+	Rack string `json:"rack,omitempty"`
+	// Status is the lifecycle status, set on creation and then only changed
+	// by a transition, for StatusReason: both are ignored by the updates.
+	Status       string            `json:"status"`
+	StatusReason string            `json:"status_reason,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+
+	// Version is managed by the repository, it starts at 1 and grows with
+	// every update.
+	Version int64 `json:"version"`
+	// CreatedAt and UpdatedAt are managed by the repository too, the values
+	// sent by a client are ignored.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Lifecycle statuses of a device.
+const (
+	StatusProvisioning   = "provisioning"
+	StatusActive         = "active"
+	StatusMaintenance    = "maintenance"
+	StatusDecommissioned = "decommissioned"
+)
+
+var Statuses = []string{StatusProvisioning, StatusActive, StatusMaintenance, StatusDecommissioned}
+
+// LegacyStatuses maps the statuses of the first releases of the lifecycle to
+// their successors.
+var LegacyStatuses = map[string]string{
+	"planned": StatusProvisioning,
+	"retired": StatusDecommissioned,
+}
+
+// DefaultStatus is the status of a device created without one, and of the
+// devices stored before the status existed.
+const DefaultStatus = StatusActive
+
+// Media types of the supported PATCH documents.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// AnyVersion makes a compare-and-swap operation skip the version check.
+const AnyVersion int64 = 0
+
+// HardwareAddresses returns the MAC addresses of the device, along with the
+// legacy one.
+func (d Device) HardwareAddresses() []string {
+	if d.MAC == "" || slices.Contains(d.MACs, d.MAC) {
+		return d.MACs
+	}
+	return append(slices.Clip(d.MACs), d.MAC)
+}
+
+// Addresses returns the assigned addresses of the device.
+func (d Device) Addresses() []string {
+	if d.IPv6 == "" {
+		return []string{d.IP}
+	}
+	return []string{d.IP, d.IPv6}
+}
+
+// DeletedDevice is a device in the trash, it can be restored until it is
+// purged.
+type DeletedDevice struct {
+	Device
+	DeletedAt time.Time `json:"deleted_at"`
 }
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Types of the change events, one per audit action.
+const (
+	EventCreated      = "device.created"
+	EventUpdated      = "device.updated"
+	EventDeleted      = "device.deleted"
+	EventRestored     = "device.restored"
+	EventPurged       = "device.purged"
+	EventTransitioned = "device.transitioned"
+)
+
+var EventTypes = []string{EventCreated, EventUpdated, EventDeleted, EventRestored, EventPurged, EventTransitioned}
+
+// Event tells that a device changed. Device is the device after the change,
+// or the one that is gone after a deletion or a purge.
+type Event struct {
+	ID        uint64    `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	SerialNum string    `json:"serial_num"`
+	Device    *Device   `json:"device,omitempty"`
+}
+
+// Webhook is a subscription to the change events. Types filters them, every
+// type is delivered when it is empty. Secret signs the deliveries, it is only
+// returned by the request adding the webhook.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Types  []string `json:"types,omitempty"`
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	SortBySerialNum = "serial_num"
+	SortByModel     = "model"
+	SortByIP        = "ip"
+	SortByVendor    = "vendor"
+	SortByFirmware  = "firmware"
+	SortByHostname  = "hostname"
+	SortBySite      = "site"
+	SortByRack      = "rack"
+	SortByStatus    = "status"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+)
+
+var SortFields = []string{
+	SortBySerialNum, SortByModel, SortByIP, SortByVendor, SortByFirmware, SortByHostname, SortBySite, SortByRack,
+	SortByStatus, SortByCreatedAt, SortByUpdatedAt,
+}
+
+// TimeSortFields are sorted by time, their SortValue is in Unix nanoseconds.
+var TimeSortFields = []string{SortByCreatedAt, SortByUpdatedAt}
+
+type ListQuery struct {
+	// Limit is the page size, Offset and Cursor are mutually exclusive.
+	Limit  int
+	Offset int
+	Cursor string
+
+	Model        string
+	Segment      string
+	SerialPrefix string
+	// IPPrefix is either a CIDR ("10.0.0.0/8") or a textual prefix ("10.0.").
+	IPPrefix string
+
+	Vendor   string
+	Firmware string
+	// MAC matches the devices having it among their MAC addresses.
+	MAC      string
+	Hostname string
+	Site     string
+	Rack     string
+	Status   string
+	// Selector filters on the labels.
+	Selector Selector
+
+	SortBy string
+	Desc   bool
+}
+
+type ListResult struct {
+	Devices    []Device `json:"devices"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points right after the last device of a page in the order it was
+// listed in, so the next page does not depend on an offset.
+type Cursor struct {
+	SortBy    string `json:"f"`
+	Desc      bool   `json:"d,omitempty"`
+	Value     string `json:"v"`
+	SerialNum string `json:"s"`
+}
+
+func NewCursor(q ListQuery, last Device) Cursor {
+	return Cursor{SortBy: q.SortBy, Desc: q.Desc, Value: SortValue(last, q.SortBy), SerialNum: last.SerialNum}
+}
+
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a cursor and checks it was issued for the same ordering.
+func ParseCursor(q ListQuery) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.SortBy != q.SortBy || c.Desc != q.Desc {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func SortValue(d Device, field string) string {
+	switch field {
+	case SortByModel:
+		return d.Model
+	case SortByIP:
+		return d.IP
+	case SortByVendor:
+		return d.Vendor
+	case SortByFirmware:
+		return d.Firmware
+	case SortByHostname:
+		return d.Hostname
+	case SortBySite:
+		return d.Site
+	case SortByRack:
+		return d.Rack
+	case SortByStatus:
+		return d.Status
+	case SortByCreatedAt:
+		return timeSortValue(d.CreatedAt)
+	case SortByUpdatedAt:
+		return timeSortValue(d.UpdatedAt)
+	default:
+		return d.SerialNum
+	}
+}
+
+// timeSortValue is 0 for the unknown times of the devices stored before they
+// had any, which time.UnixNano leaves undefined.
+func timeSortValue(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
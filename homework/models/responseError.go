@@ -12,4 +12,24 @@ import "errors"
 
 var ErrNotFound = errors.New("not found")
 
-var ErrAlredyExist = errors.New("already exist")
\ No newline at end of file
+var ErrAlredyExist = errors.New("already exist")
+
+var ErrIPConflict = errors.New("ip address already in use")
+
+var ErrMACConflict = errors.New("mac address already in use")
+
+var ErrVersionMismatch = errors.New("version mismatch")
+
+var ErrInvalidPatch = errors.New("invalid patch")
+
+var ErrPatchTestFailed = errors.New("patch test failed")
+
+var ErrUnsupportedPatch = errors.New("unsupported patch type")
+
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+var ErrForbidden = errors.New("forbidden")
+
+var ErrInvalidTransition = errors.New("invalid transition")
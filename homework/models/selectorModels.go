@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Operators of the requirements of a label selector.
+const (
+	SelectEquals       = "="
+	SelectNotEquals    = "!="
+	SelectIn           = "in"
+	SelectNotIn        = "notin"
+	SelectExists       = "exists"
+	SelectDoesNotExist = "!"
+)
+
+var ErrInvalidSelector = errors.New("invalid selector")
+
+// Requirement is one condition on the labels of a device. Values holds the
+// single value of = and != and the set of in and notin. As in Kubernetes, !=
+// and notin match the devices without the label too.
+type Requirement struct {
+	Key    string
+	Op     string
+	Values []string
+}
+
+// Selector selects the devices whose labels meet all of its requirements, an
+// empty one selects every device.
+type Selector []Requirement
+
+// ParseSelector reads the Kubernetes syntax of equality and set based
+// requirements separated by commas: "env=prod,rack in (a1,a2),!deprecated".
+// It only checks the structure, the keys and the values are left to the
+// label validation.
+func ParseSelector(s string) (Selector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var selector Selector
+	for _, part := range splitRequirements(s) {
+		r, err := parseRequirement(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v :%w", part, err, ErrInvalidSelector)
+		}
+		selector = append(selector, r)
+	}
+	return selector, nil
+}
+
+// splitRequirements splits s at the commas outside of parentheses.
+func splitRequirements(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseRequirement(s string) (Requirement, error) {
+	switch {
+	case s == "":
+		return Requirement{}, errors.New("empty requirement")
+	case strings.HasPrefix(s, "!") && !strings.HasPrefix(s, "!="):
+		return Requirement{Key: strings.TrimSpace(s[1:]), Op: SelectDoesNotExist}, nil
+	case strings.HasSuffix(s, ")"):
+		open := strings.IndexByte(s, '(')
+		fields := strings.Fields(s[:max(open, 0)])
+		if open < 0 || len(fields) != 2 || fields[1] != SelectIn && fields[1] != SelectNotIn {
+			return Requirement{}, errors.New("a set needs the form key in (v1,v2) or key notin (v1,v2)")
+		}
+		if strings.TrimSpace(s[open+1:len(s)-1]) == "" {
+			return Requirement{}, errors.New("a set needs at least one value")
+		}
+		var values []string
+		for _, v := range strings.Split(s[open+1:len(s)-1], ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+		return Requirement{Key: fields[0], Op: fields[1], Values: values}, nil
+	case strings.ContainsAny(s, "()"):
+		return Requirement{}, errors.New("unbalanced parentheses")
+	}
+	for _, op := range []struct{ token, op string }{{"!=", SelectNotEquals}, {"==", SelectEquals}, {"=", SelectEquals}} {
+		if key, value, ok := strings.Cut(s, op.token); ok {
+			return Requirement{Key: strings.TrimSpace(key), Op: op.op, Values: []string{strings.TrimSpace(value)}}, nil
+		}
+	}
+	return Requirement{Key: s, Op: SelectExists}, nil
+}
+
+// Matches reports whether labels meet the requirement.
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Op {
+	case SelectEquals, SelectIn:
+		return ok && slices.Contains(r.Values, value)
+	case SelectNotEquals, SelectNotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case SelectExists:
+		return ok
+	case SelectDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// Matches reports whether labels meet every requirement.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SnapshotInfo describes a snapshot file that was written or restored.
+type SnapshotInfo struct {
+	File    string    `json:"file"`
+	Devices int       `json:"devices"`
+	TakenAt time.Time `json:"taken_at"`
+}
@@ -0,0 +1,57 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPUniqueness(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", IPv6: "2001:db8::1"}))
+
+		err := repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.1"})
+		require.ErrorIs(t, err, models.ErrIPConflict, name)
+
+		err = repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "2001:db8::1"})
+		require.ErrorIs(t, err, models.ErrIPConflict, name)
+
+		_, err = repo.GetDevice(context.Background(), "2")
+		require.ErrorIs(t, err, models.ErrNotFound, "%s: failed create must not leave the device behind", name)
+
+		// Another segment may reuse the address.
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.1", Segment: "lab"}), name)
+
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3"}))
+		_, err = repo.UpdateDevice(context.Background(), models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.1"})
+		require.ErrorIs(t, err, models.ErrIPConflict, name)
+
+		// Keeping its own address is not a conflict.
+		_, err = repo.UpdateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1"})
+		require.NoError(t, err, name)
+		// The released IPv6 address can be taken by another device.
+		_, err = repo.UpdateDevice(context.Background(), models.Device{SerialNum: "3", Model: "m", IP: "2001:db8::1"})
+		require.NoError(t, err, name)
+
+		require.NoError(t, repo.DeleteDevice(context.Background(), "1"))
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "4", Model: "m", IP: "10.0.0.1"}), name)
+	}
+}
+
+func TestGetDeviceByIP(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		want := models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", IPv6: "2001:db8::1", Segment: "lab"}
+		require.NoError(t, repo.CreateDevice(context.Background(), want))
+
+		got, err := repo.GetDeviceByIP(context.Background(), "2001:db8::1", "lab")
+		require.NoError(t, err, name)
+		want.Version = 1
+		want.Status = models.DefaultStatus
+		require.Equal(t, want, untimed(got), name)
+
+		_, err = repo.GetDeviceByIP(context.Background(), "10.0.0.1", "")
+		require.ErrorIs(t, err, models.ErrNotFound, name)
+	}
+}
@@ -0,0 +1,188 @@
+package repositories
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"homework/models"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryAuditLog keeps the audit trail in memory, in the order it was
+// appended. Entries are never changed or removed.
+type MemoryAuditLog struct {
+	mu      sync.RWMutex
+	entries []models.AuditEntry
+	// bySerial holds the positions of the entries of every device.
+	bySerial map[string][]int
+	now      func() time.Time
+}
+
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{
+		bySerial: make(map[string][]int),
+		now:      time.Now,
+	}
+}
+
+// Append numbers and timestamps the entries and records them.
+func (l *MemoryAuditLog) Append(entries ...models.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(l.stamp(entries))
+	return nil
+}
+
+// stamp numbers the entries after the last one and sets their time, so the
+// trail is ordered by both. Callers hold the lock.
+func (l *MemoryAuditLog) stamp(entries []models.AuditEntry) []models.AuditEntry {
+	now := l.now().UTC()
+	if n := len(l.entries); n > 0 && now.Before(l.entries[n-1].Time) {
+		now = l.entries[n-1].Time
+	}
+	stamped := make([]models.AuditEntry, len(entries))
+	for i, entry := range entries {
+		entry.Seq = l.lastSeq() + uint64(i) + 1
+		entry.Time = now
+		stamped[i] = entry
+	}
+	return stamped
+}
+
+func (l *MemoryAuditLog) lastSeq() uint64 {
+	if len(l.entries) == 0 {
+		return 0
+	}
+	return l.entries[len(l.entries)-1].Seq
+}
+
+func (l *MemoryAuditLog) add(entries []models.AuditEntry) {
+	for _, entry := range entries {
+		l.bySerial[entry.SerialNum] = append(l.bySerial[entry.SerialNum], len(l.entries))
+		l.entries = append(l.entries, entry)
+	}
+}
+
+// History returns the entries of a device, oldest first.
+func (l *MemoryAuditLog) History(serialNumber string) ([]models.AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	positions, ok := l.bySerial[serialNumber]
+	if !ok {
+		return nil, fmt.Errorf("no history for %q :%w", serialNumber, models.ErrNotFound)
+	}
+	history := make([]models.AuditEntry, len(positions))
+	for i, pos := range positions {
+		history[i] = l.entries[pos]
+	}
+	return history, nil
+}
+
+// Since returns the entries q selects, oldest first.
+func (l *MemoryAuditLog) Since(q models.AuditQuery) ([]models.AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i := sort.Search(len(l.entries), func(i int) bool {
+		e := l.entries[i]
+		return !e.Time.Before(q.Since) && e.Seq > q.After
+	})
+	end := len(l.entries)
+	if q.Limit > 0 && i+q.Limit < end {
+		end = i + q.Limit
+	}
+	return append([]models.AuditEntry(nil), l.entries[i:end]...), nil
+}
+
+// FileAuditLog is a MemoryAuditLog that also appends every entry as a line of
+// JSON to a file and syncs it before Append returns. The file is read back on
+// open; a torn last line, left by a crash during a write, is cut off.
+type FileAuditLog struct {
+	*MemoryAuditLog
+
+	file *os.File
+	size int64
+}
+
+// OpenFileAuditLog opens the trail at path, creating it if needed.
+func OpenFileAuditLog(path string) (*FileAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &FileAuditLog{MemoryAuditLog: NewMemoryAuditLog(), file: file}
+	if err := l.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *FileAuditLog) load() error {
+	r := bufio.NewReader(l.file)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("audit log: dropping a torn entry at byte %d", offset)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var entry models.AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("audit log entry at byte %d: %w", offset, err)
+		}
+		l.add([]models.AuditEntry{entry})
+		offset += int64(len(line))
+	}
+
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	l.size = offset
+	return nil
+}
+
+// Append writes the entries to the file and records them once it is synced.
+func (l *FileAuditLog) Append(entries ...models.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stamped := l.stamp(entries)
+	var b []byte
+	for _, entry := range stamped {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		b = append(append(b, line...), '\n')
+	}
+	_, err := l.file.Write(b)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// Leave no partial line for the next entry to follow.
+		_ = l.file.Truncate(l.size)
+		_, _ = l.file.Seek(l.size, io.SeekStart)
+		return err
+	}
+	l.size += int64(len(b))
+	l.add(stamped)
+	return nil
+}
+
+func (l *FileAuditLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,98 @@
+package repositories_test
+
+import (
+	"homework/models"
+	"homework/repositories"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditLog interface {
+	Append(entries ...models.AuditEntry) error
+	History(serialNumber string) ([]models.AuditEntry, error)
+	Since(q models.AuditQuery) ([]models.AuditEntry, error)
+}
+
+func appendSomeEntries(t *testing.T, log auditLog) {
+	created := models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Version: 1}
+	updated := models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1", Version: 2}
+	require.NoError(t, log.Append(models.AuditEntry{Actor: "ci", Action: models.AuditCreate, SerialNum: "1", After: &created, RequestID: "r1"}))
+	require.NoError(t, log.Append(
+		models.AuditEntry{Actor: "ci", Action: models.AuditUpdate, SerialNum: "1", Before: &created, After: &updated},
+		models.AuditEntry{Actor: "ci", Action: models.AuditCreate, SerialNum: "2"},
+	))
+	require.NoError(t, log.Append(models.AuditEntry{Actor: "noc", Action: models.AuditDelete, SerialNum: "1", Before: &updated}))
+}
+
+func TestMemoryAuditLog(t *testing.T) {
+	log := repositories.NewMemoryAuditLog()
+	start := time.Now().Add(-time.Second)
+	appendSomeEntries(t, log)
+
+	history, err := log.History("1")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, []uint64{1, 2, 4}, []uint64{history[0].Seq, history[1].Seq, history[2].Seq})
+	assert.Equal(t, models.AuditDelete, history[2].Action)
+	assert.Equal(t, "m2", history[2].Before.Model)
+	assert.Nil(t, history[2].After)
+	assert.True(t, history[0].Time.After(start))
+
+	_, err = log.History("3")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	all, err := log.Since(models.AuditQuery{})
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	page, err := log.Since(models.AuditQuery{After: 1, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, uint64(2), page[0].Seq)
+	assert.Equal(t, uint64(3), page[1].Seq)
+
+	none, err := log.Since(models.AuditQuery{Since: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func TestFileAuditLogSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := repositories.OpenFileAuditLog(path)
+	require.NoError(t, err)
+	appendSomeEntries(t, log)
+	want, err := log.Since(models.AuditQuery{})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// A crash in the middle of a write leaves a torn line behind.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":5,"actor":"c`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	log, err = repositories.OpenFileAuditLog(path)
+	require.NoError(t, err)
+	defer log.Close()
+	got, err := log.Since(models.AuditQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	require.NoError(t, log.Append(models.AuditEntry{Actor: "ci", Action: models.AuditCreate, SerialNum: "3"}))
+	history, err := log.History("3")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), history[0].Seq)
+}
+
+func TestFileAuditLogRejectsCorruptEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("not json\n"), 0o600))
+	_, err := repositories.OpenFileAuditLog(path)
+	assert.Error(t, err)
+}
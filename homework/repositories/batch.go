@@ -0,0 +1,165 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"homework/models"
+	"time"
+)
+
+func (ds *RepoDevice) CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.createDevices(devices, mode, ds.now().UTC()), nil
+}
+
+func (ds *RepoDevice) createDevices(devices []models.Device, mode models.BatchMode, at time.Time) []error {
+	return ds.batch(len(devices), mode, func(i int) string {
+		return devices[i].SerialNum
+	}, func(i int) error {
+		return ds.create(devices[i], at)
+	})
+}
+
+func (ds *RepoDevice) UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.updateDevices(devices, mode, ds.now().UTC()), nil
+}
+
+func (ds *RepoDevice) updateDevices(devices []models.Device, mode models.BatchMode, at time.Time) []error {
+	return ds.batch(len(devices), mode, func(i int) string {
+		return devices[i].SerialNum
+	}, func(i int) error {
+		return ds.update(devices[i], models.AnyVersion, at)
+	})
+}
+
+func (ds *RepoDevice) DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.deleteDevices(serialNumbers, mode, ds.now().UTC()), nil
+}
+
+func (ds *RepoDevice) deleteDevices(serialNumbers []string, mode models.BatchMode, at time.Time) []error {
+	return ds.batch(len(serialNumbers), mode, func(i int) string {
+		return serialNumbers[i]
+	}, func(i int) error {
+		return ds.delete(serialNumbers[i], models.AnyVersion, at)
+	})
+}
+
+// batch runs n operations, each touching the device serial(i), under the lock
+// the caller holds. An atomic batch remembers what every applied operation
+// replaced, in the devices and in the trash, and puts it back if any
+// operation failed.
+func (ds *RepoDevice) batch(n int, mode models.BatchMode, serial func(i int) string, op func(i int) error) []error {
+	type undo struct {
+		serialNum string
+		device    models.Device
+		existed   bool
+		deleted   models.DeletedDevice
+		trashed   bool
+	}
+	var journal []undo
+
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		serialNum := serial(i)
+		old, existed := ds.devices[serialNum]
+		deleted, trashed := ds.trash[serialNum]
+		if errs[i] = op(i); errs[i] == nil && mode == models.BatchAtomic {
+			journal = append(journal, undo{serialNum: serialNum, device: old, existed: existed, deleted: deleted, trashed: trashed})
+		}
+	}
+
+	if mode == models.BatchAtomic && models.BatchFailed(errs) {
+		for i := len(journal) - 1; i >= 0; i-- {
+			undo := journal[i]
+			ds.remove(undo.serialNum)
+			if undo.existed {
+				ds.put(undo.device)
+			}
+			delete(ds.trash, undo.serialNum)
+			if undo.trashed {
+				ds.trash[undo.serialNum] = undo.deleted
+			}
+		}
+		models.AbortBatch(errs)
+	}
+	return errs
+}
+
+func (r *SQLRepo) CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	at := time.Now().UTC()
+	return r.batch(ctx, len(devices), mode, func(tx *sql.Tx, i int) error {
+		device := devices[i]
+		device.CreatedAt, device.UpdatedAt = at, at
+		return createDevice(tx, device)
+	})
+}
+
+func (r *SQLRepo) UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	at := time.Now().UTC()
+	return r.batch(ctx, len(devices), mode, func(tx *sql.Tx, i int) error {
+		return swapDevice(tx, devices[i], models.AnyVersion, at)
+	})
+}
+
+func (r *SQLRepo) DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error) {
+	at := time.Now().UTC()
+	return r.batch(ctx, len(serialNumbers), mode, func(tx *sql.Tx, i int) error {
+		return deleteDevice(tx, serialNumbers[i], models.AnyVersion, at)
+	})
+}
+
+// batch runs n operations in one transaction. Every operation gets a savepoint
+// so a failed one leaves nothing half done behind; an atomic batch with a
+// failed operation rolls the whole transaction back.
+func (r *SQLRepo) batch(ctx context.Context, n int, mode models.BatchMode, op func(tx *sql.Tx, i int) error) ([]error, error) {
+	errs := make([]error, n)
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		for i := 0; i < n; i++ {
+			if _, err := tx.Exec(`SAVEPOINT batch_item`); err != nil {
+				return err
+			}
+			errs[i] = op(tx, i)
+			release := `RELEASE SAVEPOINT batch_item`
+			if errs[i] != nil {
+				release = `ROLLBACK TO SAVEPOINT batch_item`
+			}
+			if _, err := tx.Exec(release); err != nil {
+				return err
+			}
+			if errs[i] != nil {
+				// ROLLBACK TO keeps the savepoint open.
+				if _, err := tx.Exec(`RELEASE SAVEPOINT batch_item`); err != nil {
+					return err
+				}
+			}
+		}
+		if mode == models.BatchAtomic && models.BatchFailed(errs) {
+			models.AbortBatch(errs)
+			return errBatchRolledBack
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchRolledBack) {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// errBatchRolledBack makes withTx roll back an atomic batch whose failures are
+// already recorded per item.
+var errBatchRolledBack = errors.New("batch rolled back")
@@ -0,0 +1,99 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDevicesBestEffort(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "a", Model: "m", IP: "10.0.0.1"}))
+
+			errs, err := repo.CreateDevices(context.Background(), []models.Device{
+				{SerialNum: "b", Model: "m", IP: "10.0.0.2"},
+				{SerialNum: "a", Model: "m", IP: "10.0.0.3"},
+				{SerialNum: "c", Model: "m", IP: "10.0.0.2"},
+				{SerialNum: "d", Model: "m", IP: "10.0.0.4"},
+			}, models.BatchBestEffort)
+			require.NoError(t, err)
+			require.Len(t, errs, 4)
+			assert.NoError(t, errs[0])
+			assert.ErrorIs(t, errs[1], models.ErrAlredyExist)
+			assert.ErrorIs(t, errs[2], models.ErrIPConflict)
+			assert.NoError(t, errs[3])
+
+			result, err := repo.ListDevices(context.Background(), models.ListQuery{})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a", "b", "d"}, serials(result.Devices))
+		})
+	}
+}
+
+func TestCreateDevicesAtomic(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			errs, err := repo.CreateDevices(context.Background(), []models.Device{
+				{SerialNum: "a", Model: "m", IP: "10.0.0.1"},
+				{SerialNum: "b", Model: "m", IP: "10.0.0.1"},
+				{SerialNum: "c", Model: "m", IP: "10.0.0.3"},
+			}, models.BatchAtomic)
+			require.NoError(t, err)
+			assert.ErrorIs(t, errs[0], models.ErrBatchAborted)
+			assert.ErrorIs(t, errs[1], models.ErrIPConflict)
+			assert.ErrorIs(t, errs[2], models.ErrBatchAborted)
+
+			result, err := repo.ListDevices(context.Background(), models.ListQuery{})
+			require.NoError(t, err)
+			assert.Empty(t, result.Devices)
+
+			// Nothing of the rolled back batch holds an address.
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "x", Model: "m", IP: "10.0.0.1"}))
+		})
+	}
+}
+
+func TestUpdateAndDeleteDevicesAtomic(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, d := range listFixture {
+				require.NoError(t, repo.CreateDevice(context.Background(), d))
+			}
+
+			errs, err := repo.UpdateDevices(context.Background(), []models.Device{
+				{SerialNum: "a-1", Model: "m9", IP: "10.9.9.9"},
+				{SerialNum: "a-1", Model: "m9", IP: "10.9.9.8"},
+				{SerialNum: "zz", Model: "m9", IP: "10.9.9.7"},
+			}, models.BatchAtomic)
+			require.NoError(t, err)
+			assert.ErrorIs(t, errs[0], models.ErrBatchAborted)
+			assert.ErrorIs(t, errs[1], models.ErrBatchAborted)
+			assert.ErrorIs(t, errs[2], models.ErrNotFound)
+
+			got, err := repo.GetDevice(context.Background(), "a-1")
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.9", got.IP)
+			assert.Equal(t, int64(1), got.Version)
+			_, err = repo.GetDeviceByIP(context.Background(), "10.9.9.8", "")
+			assert.ErrorIs(t, err, models.ErrNotFound)
+
+			errs, err = repo.DeleteDevices(context.Background(), []string{"a-1", "b-2", "a-1"}, models.BatchAtomic)
+			require.NoError(t, err)
+			assert.ErrorIs(t, errs[2], models.ErrNotFound)
+			result, err := repo.ListDevices(context.Background(), models.ListQuery{})
+			require.NoError(t, err)
+			assert.Len(t, result.Devices, len(listFixture))
+
+			errs, err = repo.DeleteDevices(context.Background(), []string{"a-1", "b-2"}, models.BatchAtomic)
+			require.NoError(t, err)
+			assert.False(t, models.BatchFailed(errs))
+			result, err = repo.ListDevices(context.Background(), models.ListQuery{})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a-2", "a-3", "b-1"}, serials(result.Devices))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanceledContext(t *testing.T) {
+	device := models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, repo.CreateDevice(context.Background(), device))
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := repo.GetDevice(ctx, "1")
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = repo.GetDeviceByIP(ctx, "10.0.0.1", "")
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = repo.ListDevices(ctx, models.ListQuery{})
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = repo.ListDeletedDevices(ctx)
+			assert.ErrorIs(t, err, context.Canceled)
+
+			assert.ErrorIs(t, repo.CreateDevice(ctx, models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}), context.Canceled)
+			_, err = repo.UpdateDevice(ctx, models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1"})
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.ErrorIs(t, repo.DeleteDevice(ctx, "1"), context.Canceled)
+			_, err = repo.CreateDevices(ctx, []models.Device{{SerialNum: "3", Model: "m", IP: "10.0.0.3"}}, models.BatchAtomic)
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = repo.DeleteDevices(ctx, []string{"1"}, models.BatchBestEffort)
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = repo.RestoreDevice(ctx, "1")
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = repo.PurgeDeletedDevices(ctx, time.Now().Add(time.Hour))
+			assert.ErrorIs(t, err, context.Canceled)
+
+			// Nothing was written.
+			result, err := repo.ListDevices(context.Background(), models.ListQuery{})
+			require.NoError(t, err)
+			require.Len(t, result.Devices, 1)
+			assert.Equal(t, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: models.DefaultStatus, Version: 1}, untimed(result.Devices[0]))
+		})
+	}
+}
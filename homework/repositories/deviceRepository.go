@@ -1,22 +1,53 @@
 package repositories
 
 import (
-	_"errors"
+	"context"
+	_ "errors"
 	"fmt"
 	"homework/models"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
-
+// Repository stores the devices. Every method fails with the error of its
+// context once the context is done, a write that already reached the storage
+// is not undone.
 type Repository interface {
-	GetDevice(string) (models.Device, error)
-	CreateDevice(models.Device) error
-	DeleteDevice(string) error
-	UpdateDevice(models.Device) error
+	GetDevice(ctx context.Context, serialNumber string) (models.Device, error)
+	CreateDevice(ctx context.Context, device models.Device) error
+	DeleteDevice(ctx context.Context, serialNumber string) error
+	// UpdateDevice and CompareAndSwapDevice return the device as stored.
+	UpdateDevice(ctx context.Context, device models.Device) (models.Device, error)
+	ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error)
+	GetDeviceByIP(ctx context.Context, ip, segment string) (models.Device, error)
+	// GetDeviceByMAC finds the device with the MAC address, given in the
+	// canonical form.
+	GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error)
+	// CompareAndSwapDevice replaces the device only while it still has the
+	// given version and fails with models.ErrVersionMismatch otherwise.
+	CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error)
+	CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error
+	// The batch operations return one error per item, nil for the applied
+	// ones, and an error of their own only when the batch could not run.
+	CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error)
+	UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error)
+	DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error)
+	// Deleted devices move to the trash. RestoreDevice brings one back with
+	// its addresses if they are still free, PurgeDeletedDevices drops the
+	// ones deleted before a time for good and returns them.
+	ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error)
+	RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error)
+	PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error)
+	// TransitionDevice changes the lifecycle status of the device and the
+	// reason of it if the device still has the given version, and returns
+	// it. The updates keep both.
+	TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error)
 }
 
-
-
 type DeviceService struct {
 	Repository
 }
@@ -29,63 +60,438 @@ func NewDeviceService() *DeviceService {
 
 type RepoDevice struct {
 	devices map[string]models.Device
-	mu        sync.RWMutex
+	// order keeps the serial numbers sorted for listings and prefix lookups.
+	order []string
+	// byIP maps every address of a segment to the device holding it.
+	byIP map[string]string
+	// byMAC maps every MAC address to the device holding it.
+	byMAC map[string]string
+	// byLabel maps every label key, then value, to the devices having it.
+	byLabel map[string]map[string]map[string]struct{}
+	// trash holds the last deleted device of every serial number until it is
+	// restored or purged.
+	trash map[string]models.DeletedDevice
+	now   func() time.Time
+	// changes counts the writes, so snapshots can tell whether anything
+	// changed since the last one.
+	changes uint64
+	// seq is the last write-ahead log record applied, see WALRepo.
+	seq uint64
+	mu  sync.RWMutex
 }
 
-func NewRepoDevice()  *RepoDevice {
-	return  &RepoDevice{
+func NewRepoDevice() *RepoDevice {
+	return &RepoDevice{
 		devices: make(map[string]models.Device),
-		mu:        sync.RWMutex{},
+		byIP:    make(map[string]string),
+		byMAC:   make(map[string]string),
+		byLabel: make(map[string]map[string]map[string]struct{}),
+		trash:   make(map[string]models.DeletedDevice),
+		now:     time.Now,
+		mu:      sync.RWMutex{},
 	}
 }
 
+// storedStatus is the status the device is stored with, a device without one,
+// like the ones stored before the status existed, has the default status.
+func storedStatus(device models.Device) string {
+	if device.Status == "" {
+		return models.DefaultStatus
+	}
+	if status, ok := models.LegacyStatuses[device.Status]; ok {
+		return status
+	}
+	return device.Status
+}
 
-func (ds  *RepoDevice) CreateDevice(device models.Device) error {
-	ds.mu.RLock()
-	defer ds.mu.RUnlock()
+// detach copies the labels and the MAC addresses of a device, so the stored
+// devices share no map or slice with the callers.
+func detach(device models.Device) models.Device {
+	device.Labels = maps.Clone(device.Labels)
+	device.MACs = slices.Clone(device.MACs)
+	return device
+}
+
+func addressKey(segment, ip string) string {
+	return segment + "\x00" + ip
+}
+
+// checkAddresses reports whether an address of the device is held by another
+// one in the same segment, or a MAC address by any other one. Callers hold
+// the lock.
+func (ds *RepoDevice) checkAddresses(device models.Device) error {
+	for _, ip := range device.Addresses() {
+		owner, ok := ds.byIP[addressKey(device.Segment, ip)]
+		if ok && owner != device.SerialNum {
+			return fmt.Errorf("%q is used by %q :%w", ip, owner, models.ErrIPConflict)
+		}
+	}
+	for _, mac := range device.HardwareAddresses() {
+		owner, ok := ds.byMAC[mac]
+		if ok && owner != device.SerialNum {
+			return fmt.Errorf("%q is used by %q :%w", mac, owner, models.ErrMACConflict)
+		}
+	}
+	return nil
+}
 
+// put stores the device and keeps the indexes in sync. Callers hold the lock
+// and have checked the addresses.
+func (ds *RepoDevice) put(device models.Device) {
+	device.Status = storedStatus(device)
+	// The devices stored before MACs existed have their address in MAC.
+	device.MACs, device.MAC = device.HardwareAddresses(), ""
+	old, ok := ds.devices[device.SerialNum]
+	if ok {
+		ds.unindexAddresses(old)
+		ds.unindexLabels(old)
+	} else {
+		i := sort.SearchStrings(ds.order, device.SerialNum)
+		ds.order = append(ds.order, "")
+		copy(ds.order[i+1:], ds.order[i:])
+		ds.order[i] = device.SerialNum
+	}
+	ds.devices[device.SerialNum] = detach(device)
+	ds.changes++
+	for _, ip := range device.Addresses() {
+		ds.byIP[addressKey(device.Segment, ip)] = device.SerialNum
+	}
+	for _, mac := range device.MACs {
+		ds.byMAC[mac] = device.SerialNum
+	}
+	for key, value := range device.Labels {
+		values, ok := ds.byLabel[key]
+		if !ok {
+			values = make(map[string]map[string]struct{})
+			ds.byLabel[key] = values
+		}
+		if values[value] == nil {
+			values[value] = make(map[string]struct{})
+		}
+		values[value][device.SerialNum] = struct{}{}
+	}
+}
+
+// remove deletes the device and its index entries. Callers hold the lock.
+func (ds *RepoDevice) remove(serialNumber string) {
+	device, ok := ds.devices[serialNumber]
+	if !ok {
+		return
+	}
+	ds.unindexAddresses(device)
+	ds.unindexLabels(device)
+	delete(ds.devices, serialNumber)
+	ds.changes++
+
+	i := sort.SearchStrings(ds.order, serialNumber)
+	ds.order = append(ds.order[:i], ds.order[i+1:]...)
+}
+
+func (ds *RepoDevice) unindexAddresses(device models.Device) {
+	for _, ip := range device.Addresses() {
+		key := addressKey(device.Segment, ip)
+		if ds.byIP[key] == device.SerialNum {
+			delete(ds.byIP, key)
+		}
+	}
+	for _, mac := range device.MACs {
+		if ds.byMAC[mac] == device.SerialNum {
+			delete(ds.byMAC, mac)
+		}
+	}
+}
+
+func (ds *RepoDevice) unindexLabels(device models.Device) {
+	for key, value := range device.Labels {
+		values := ds.byLabel[key]
+		delete(values[value], device.SerialNum)
+		if len(values[value]) == 0 {
+			delete(values, value)
+		}
+		if len(values) == 0 {
+			delete(ds.byLabel, key)
+		}
+	}
+}
+
+// labelCandidates returns the sorted serial numbers of the devices meeting
+// the most selective requirement of the selector which the label index can
+// answer, the others are left to the filter. It returns false when there is
+// no such requirement: !=, notin and ! match the devices without the label
+// too. Callers hold the lock.
+func (ds *RepoDevice) labelCandidates(selector models.Selector) ([]string, bool) {
+	var best []map[string]struct{}
+	bestSize := -1
+	for _, r := range selector {
+		var sets []map[string]struct{}
+		switch r.Op {
+		case models.SelectEquals, models.SelectIn:
+			for _, value := range r.Values {
+				sets = append(sets, ds.byLabel[r.Key][value])
+			}
+		case models.SelectExists:
+			for _, set := range ds.byLabel[r.Key] {
+				sets = append(sets, set)
+			}
+		default:
+			continue
+		}
+		// A device has one value per key, the sets do not overlap.
+		size := 0
+		for _, set := range sets {
+			size += len(set)
+		}
+		if bestSize < 0 || size < bestSize {
+			best, bestSize = sets, size
+		}
+	}
+	if bestSize < 0 {
+		return nil, false
+	}
+	serials := make([]string, 0, bestSize)
+	for _, set := range best {
+		for serialNum := range set {
+			serials = append(serials, serialNum)
+		}
+	}
+	// An in requirement can repeat a value.
+	slices.Sort(serials)
+	return slices.Compact(serials), true
+}
+
+func (ds *RepoDevice) CreateDevice(ctx context.Context, device models.Device) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.create(device, ds.now().UTC())
+}
+
+// create stores a new device as created at the given time.
+func (ds *RepoDevice) create(device models.Device, at time.Time) error {
+	if err := ds.checkCreate(device); err != nil {
+		return err
+	}
+	device.Version = 1
+	device.CreatedAt, device.UpdatedAt = at, at
+	ds.put(device)
+	return nil
+}
+
+// checkCreate reports why the device cannot be created. Callers hold the lock.
+func (ds *RepoDevice) checkCreate(device models.Device) error {
 	_, ok := ds.devices[device.SerialNum]
 	if ok {
-		return fmt.Errorf( "%q :%w", device.SerialNum, models.ErrAlredyExist) 
+		return fmt.Errorf("%q :%w", device.SerialNum, models.ErrAlredyExist)
 		//&models.ResponseError{Err: errors.New("Device with the same serial number already exist") }
 	}
-	ds.devices[device.SerialNum] = device
-	return nil
+	return ds.checkAddresses(device)
 }
 
-func (ds  *RepoDevice) GetDevice(serialNumber string) (models.Device, error) {
-    ds.mu.Lock()
-	defer ds.mu.Unlock()
+func (ds *RepoDevice) GetDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Device{}, err
+	}
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
 	device, ok := ds.devices[serialNumber]
 	if !ok {
-		return models.Device{}, fmt.Errorf( "%q :%w", device.SerialNum, models.ErrNotFound) 
+		return models.Device{}, fmt.Errorf("%q :%w", device.SerialNum, models.ErrNotFound)
 		//&models.ResponseError{Err: errors.New("Device not found") }
 	}
 
-	return device, nil
+	return detach(device), nil
 }
 
-func (ds  *RepoDevice) DeleteDevice(serialNumber string) error {
+func (ds *RepoDevice) DeleteDevice(ctx context.Context, serialNumber string) error {
+	return ds.CompareAndDeleteDevice(ctx, serialNumber, models.AnyVersion)
+}
+
+func (ds *RepoDevice) CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	_, ok := ds.devices[serialNumber]
-	if !ok {
-		return fmt.Errorf( "%q :%w", serialNumber, models.ErrNotFound)  
-		//&models.ResponseError{Err: errors.New("Device not found") }
+	return ds.delete(serialNumber, version, ds.now().UTC())
+}
+
+// delete moves the device to the trash as deleted at the given time.
+func (ds *RepoDevice) delete(serialNumber string, version int64, at time.Time) error {
+	device, err := ds.current(serialNumber, version)
+	if err != nil {
+		return err
 	}
-	delete(ds.devices, serialNumber)
+	ds.remove(serialNumber)
+	ds.trash[serialNumber] = models.DeletedDevice{Device: device, DeletedAt: at}
 
 	return nil
 }
 
-func (ds  *RepoDevice) UpdateDevice(device models.Device) error {
+func (ds *RepoDevice) UpdateDevice(ctx context.Context, device models.Device) (models.Device, error) {
+	return ds.CompareAndSwapDevice(ctx, device, models.AnyVersion)
+}
+
+func (ds *RepoDevice) CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Device{}, err
+	}
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	_, ok := ds.devices[device.SerialNum]
+	if err := ds.update(device, version, ds.now().UTC()); err != nil {
+		return models.Device{}, err
+	}
+	return detach(ds.devices[device.SerialNum]), nil
+}
+
+// update replaces the device as updated at the given time, but for its status.
+func (ds *RepoDevice) update(device models.Device, version int64, at time.Time) error {
+	current, err := ds.checkUpdate(device, version)
+	if err != nil {
+		return err
+	}
+	device.Status, device.StatusReason = current.Status, current.StatusReason
+	device.Version = current.Version + 1
+	device.CreatedAt, device.UpdatedAt = current.CreatedAt, at
+	ds.put(device)
+	return nil
+}
+
+func (ds *RepoDevice) TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Device{}, err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.transition(serialNumber, status, reason, version, ds.now().UTC())
+}
+
+// transition changes the status of the device as updated at the given time.
+// Callers hold the lock.
+func (ds *RepoDevice) transition(serialNumber, status, reason string, version int64, at time.Time) (models.Device, error) {
+	device, err := ds.current(serialNumber, version)
+	if err != nil {
+		return models.Device{}, err
+	}
+	device.Status, device.StatusReason = status, reason
+	device.Version++
+	device.UpdatedAt = at
+	ds.put(device)
+	return detach(device), nil
+}
+
+// checkUpdate returns the device the update would replace. Callers hold the
+// lock.
+func (ds *RepoDevice) checkUpdate(device models.Device, version int64) (models.Device, error) {
+	current, err := ds.current(device.SerialNum, version)
+	if err != nil {
+		return current, err
+	}
+	return current, ds.checkAddresses(device)
+}
+
+// current returns the stored device if it has the expected version. Callers
+// hold the lock.
+func (ds *RepoDevice) current(serialNumber string, version int64) (models.Device, error) {
+	device, ok := ds.devices[serialNumber]
 	if !ok {
-		return fmt.Errorf( "%q :%w", device.SerialNum, models.ErrNotFound) 
+		return device, fmt.Errorf("%q :%w", serialNumber, models.ErrNotFound)
 		//&models.ResponseError{Err: errors.New("Device not found") }
 	}
-	ds.devices[device.SerialNum] = device
-	return nil
-}
\ No newline at end of file
+	if version != models.AnyVersion && device.Version != version {
+		return device, fmt.Errorf("%q is at version %d, not %d :%w", serialNumber, device.Version, version, models.ErrVersionMismatch)
+	}
+	return device, nil
+}
+
+func (ds *RepoDevice) GetDeviceByIP(ctx context.Context, ip, segment string) (models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Device{}, err
+	}
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	serialNumber, ok := ds.byIP[addressKey(segment, ip)]
+	if !ok {
+		return models.Device{}, fmt.Errorf("%q :%w", ip, models.ErrNotFound)
+	}
+	return detach(ds.devices[serialNumber]), nil
+}
+
+func (ds *RepoDevice) GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Device{}, err
+	}
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	serialNumber, ok := ds.byMAC[mac]
+	if !ok {
+		return models.Device{}, fmt.Errorf("%q :%w", mac, models.ErrNotFound)
+	}
+	return detach(ds.devices[serialNumber]), nil
+}
+
+func (ds *RepoDevice) ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ListResult{}, err
+	}
+	if q.SortBy == "" {
+		q.SortBy = models.SortBySerialNum
+	}
+	var cursor *models.Cursor
+	if q.Cursor != "" {
+		c, err := models.ParseCursor(q)
+		if err != nil {
+			return models.ListResult{}, err
+		}
+		cursor = &c
+	}
+	filter := newDeviceFilter(q)
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	// The serial number index narrows both the prefix filter and, when the
+	// listing is in index order, the position of the cursor.
+	lo := sort.SearchStrings(ds.order, q.SerialPrefix)
+	hi := lo + sort.Search(len(ds.order)-lo, func(i int) bool {
+		return !strings.HasPrefix(ds.order[lo+i], q.SerialPrefix)
+	})
+	serials := ds.order[lo:hi]
+	// The label index narrows them further down for a selector, the filter
+	// still checks the prefix.
+	if candidates, ok := ds.labelCandidates(q.Selector); ok {
+		serials = candidates
+	}
+	inIndexOrder := q.SortBy == models.SortBySerialNum && !q.Desc
+	if inIndexOrder && cursor != nil {
+		serials = serials[sort.Search(len(serials), func(i int) bool {
+			return serials[i] > cursor.SerialNum
+		}):]
+		cursor = nil
+	}
+
+	var matched []models.Device
+	for _, serialNum := range serials {
+		d := ds.devices[serialNum]
+		if !filter.match(d) {
+			continue
+		}
+		if cursor != nil && compareListed(q.SortBy, q.Desc, models.SortValue(d, q.SortBy), d.SerialNum, cursor.Value, cursor.SerialNum) <= 0 {
+			continue
+		}
+		matched = append(matched, detach(d))
+		if inIndexOrder && q.Limit > 0 && len(matched) > q.Offset+q.Limit {
+			break
+		}
+	}
+
+	if !inIndexOrder {
+		sort.Slice(matched, func(i, j int) bool {
+			a, b := matched[i], matched[j]
+			return compareListed(q.SortBy, q.Desc, models.SortValue(a, q.SortBy), a.SerialNum, models.SortValue(b, q.SortBy), b.SerialNum) < 0
+		})
+	}
+
+	return paginate(q, matched), nil
+}
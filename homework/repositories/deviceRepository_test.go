@@ -1,9 +1,11 @@
 package repositories_test
 
 import (
+	"context"
 	"homework/models"
 	"homework/repositories"
 	"homework/services"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -36,7 +38,7 @@ func (suite *MyTestSuite) TestCreate() {
 		},
 	}
 	for _, d := range devices {
-		err := suite.service.CreateDevice(d)
+		err := suite.service.CreateDevice(context.Background(), d)
 		if err != nil {
 			suite.T().Errorf("unexpected error: %v", err)
 		}
@@ -50,17 +52,20 @@ func (suite *MyTestSuite) TestUpdate() {
 		Model:     "model1",
 		IP:        "1.1.1.2",
 	}
-	err := suite.service.UpdateDevice(newDevice)
+	_, err := suite.service.UpdateDevice(context.Background(), newDevice)
 	if err != nil {
 		suite.T().Errorf("unexpected error: %v", err)
 	}
 
-	gotDevice, err := suite.service.GetDevice(newDevice.SerialNum)
+	gotDevice, err := suite.service.GetDevice(context.Background(), newDevice.SerialNum)
 	if err != nil {
 		suite.T().Errorf("unexpected error: %v", err)
 	}
+	newDevice.Version = 2
+	newDevice.Status = models.DefaultStatus
+	newDevice.CreatedAt, newDevice.UpdatedAt = gotDevice.CreatedAt, gotDevice.UpdatedAt
 
-	if gotDevice != newDevice {
+	if !reflect.DeepEqual(gotDevice, newDevice) {
 		suite.T().Errorf("new device %+#v not equal got device %+#v", newDevice, gotDevice)
 	}
 }
@@ -72,12 +77,12 @@ func (suite *MyTestSuite) TestDelete() {
 		IP:        "1.1.1.2",
 	}
 
-	err := suite.service.DeleteDevice(newDevice.SerialNum)
+	err := suite.service.DeleteDevice(context.Background(), newDevice.SerialNum)
 	if err != nil {
 		suite.T().Errorf("unexpected error: %v", err)
 	}
 
-	_, err = suite.service.GetDevice(newDevice.SerialNum)
+	_, err = suite.service.GetDevice(context.Background(), newDevice.SerialNum)
 	if err == nil {
 		suite.T().Error("want error, but got nil")
 	}
@@ -93,7 +98,7 @@ func TestDeleteDeviceUnexisting(t *testing.T) {
 	repo := repositories.NewDeviceService()
 	service := services.NewService(repo)
 
-	err := service.DeleteDevice("123")
+	err := service.DeleteDevice(context.Background(), "123")
 	if err == nil {
 		t.Errorf("want error, but got nil")
 	}
@@ -108,7 +113,7 @@ func TestUpdateDeviceUnexsting(t *testing.T) {
 		IP:        "1.1.1.1",
 	}
 
-	err := service.CreateDevice(device)
+	err := service.CreateDevice(context.Background(), device)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -118,7 +123,7 @@ func TestUpdateDeviceUnexsting(t *testing.T) {
 		Model:     "model1",
 		IP:        "1.1.1.2",
 	}
-	err = service.UpdateDevice(newDevice)
+	_, err = service.UpdateDevice(context.Background(), newDevice)
 	if err == nil {
 		t.Errorf("want err, but got nil")
 	}
@@ -133,12 +138,12 @@ func TestCreateDuplicate(t *testing.T) {
 		IP:        "1.1.1.1",
 	}
 
-	err := service.CreateDevice(wantDevice)
+	err := service.CreateDevice(context.Background(), wantDevice)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	err = service.CreateDevice(wantDevice)
+	err = service.CreateDevice(context.Background(), wantDevice)
 	if err == nil {
 		t.Errorf("want error, but got nil")
 	}
@@ -155,20 +160,21 @@ func FuzzGetDevice(f *testing.F) {
 			IP:        "1.1.1.2",
 		}
 
-		err := repo.CreateDevice(expect)
+		err := repo.CreateDevice(context.Background(), expect)
 
 		require.NoError(t, err)
+		expect.Version = 1
 
-		actual, err := repo.GetDevice(expect.SerialNum)
+		actual, err := repo.GetDevice(context.Background(), expect.SerialNum)
 
 		require.Equal(t, actual, expect)
 		require.NoError(t, err)
 
-		err = repo.DeleteDevice(expect.SerialNum)
+		err = repo.DeleteDevice(context.Background(), expect.SerialNum)
 
 		require.NoError(t, err)
 
-		actual, err = repo.GetDevice(expect.SerialNum)
+		actual, err = repo.GetDevice(context.Background(), expect.SerialNum)
 
 		require.Nil(t, actual)
 		require.Error(t, err)
@@ -192,12 +198,12 @@ func BenchmarkGet(b *testing.B) {
 		IP:        "1.1.1.1",
 	}
 
-	_ = service.CreateDevice(wantDevice)
+	_ = service.CreateDevice(context.Background(), wantDevice)
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _ = service.GetDevice(wantDevice.SerialNum)
+		_, _ = service.GetDevice(context.Background(), wantDevice.SerialNum)
 	}
 }
 
@@ -212,7 +218,7 @@ func BenchmarkCreate(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = service.CreateDevice(device)
+		_ = service.CreateDevice(context.Background(), device)
 	}
 }
 
@@ -225,7 +231,7 @@ func BenchmarkUpdate(b *testing.B) {
 		IP:        "1.1.1.1",
 	}
 
-	_ = service.CreateDevice(device)
+	_ = service.CreateDevice(context.Background(), device)
 	
 
 	newDevice := models.Device{
@@ -234,12 +240,12 @@ func BenchmarkUpdate(b *testing.B) {
 		IP:        "1.1.1.2",
 	}
 
-	_ = service.CreateDevice(device)
+	_ = service.CreateDevice(context.Background(), device)
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = service.UpdateDevice(newDevice)
+		_, _ = service.UpdateDevice(context.Background(), newDevice)
 	}
 }
 
@@ -252,11 +258,11 @@ func BenchmarkDelete(b *testing.B) {
 		IP:        "1.1.1.1",
 	}
 
-	_ = service.CreateDevice(device)
+	_ = service.CreateDevice(context.Background(), device)
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = service.DeleteDevice(device.SerialNum)
+		_ = service.DeleteDevice(context.Background(), device.SerialNum)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,8 @@
+package repositories
+
+import "database/sql"
+
+// MigrateTo builds the database of the release that stopped at version.
+func MigrateTo(db *sql.DB, version int) error {
+	return (&SQLRepo{db: db}).migrateTo(version)
+}
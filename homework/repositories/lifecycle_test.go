@@ -0,0 +1,54 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitionDevice(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, repo.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: models.StatusProvisioning}))
+
+			_, err := repo.TransitionDevice(ctx, "1", models.StatusActive, "racked", 2)
+			require.ErrorIs(t, err, models.ErrVersionMismatch)
+			_, err = repo.TransitionDevice(ctx, "2", models.StatusActive, "racked", models.AnyVersion)
+			require.ErrorIs(t, err, models.ErrNotFound)
+
+			device, err := repo.TransitionDevice(ctx, "1", models.StatusActive, "racked", 1)
+			require.NoError(t, err)
+			assert.Equal(t, models.StatusActive, device.Status)
+			assert.Equal(t, "racked", device.StatusReason)
+			assert.Equal(t, int64(2), device.Version)
+			stored, err := repo.GetDevice(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, device, stored)
+
+			// The updates keep the status and its reason.
+			_, err = repo.UpdateDevice(ctx, models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1", Status: models.StatusDecommissioned})
+			require.NoError(t, err)
+			stored, err = repo.GetDevice(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, models.StatusActive, stored.Status)
+			assert.Equal(t, "racked", stored.StatusReason)
+			assert.Equal(t, int64(3), stored.Version)
+		})
+	}
+}
+
+func TestLegacyStatuses(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: "planned"}))
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2", Status: "retired"}))
+
+		devices := listAll(t, repo)
+		require.Len(t, devices, 2, name)
+		assert.Equal(t, models.StatusProvisioning, devices[0].Status, name)
+		assert.Equal(t, models.StatusDecommissioned, devices[1].Status, name)
+	}
+}
@@ -0,0 +1,156 @@
+package repositories
+
+import (
+	"bytes"
+	"cmp"
+	"homework/models"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ipKey is the 16 byte form of an address, which orders IPv4 and IPv6
+// numerically when compared byte by byte.
+func ipKey(ip string) []byte {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+	return parsed.To16()
+}
+
+// cidrRange returns the first and the last address of a network as ipKeys.
+func cidrRange(network *net.IPNet) ([]byte, []byte) {
+	first := make(net.IP, len(network.IP))
+	last := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		first[i] = network.IP[i] & network.Mask[i]
+		last[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return first.To16(), last.To16()
+}
+
+func compareValues(field, a, b string) int {
+	if field == models.SortByIP {
+		ka, kb := ipKey(a), ipKey(b)
+		if ka != nil && kb != nil {
+			return bytes.Compare(ka, kb)
+		}
+	}
+	if slices.Contains(models.TimeSortFields, field) {
+		ta, errA := strconv.ParseInt(a, 10, 64)
+		tb, errB := strconv.ParseInt(b, 10, 64)
+		if errA == nil && errB == nil {
+			return cmp.Compare(ta, tb)
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// compareListed orders two (sort value, serial number) pairs the way a listing
+// sorted by field returns them.
+func compareListed(field string, desc bool, aValue, aSerial, bValue, bSerial string) int {
+	c := compareValues(field, aValue, bValue)
+	if c == 0 {
+		c = strings.Compare(aSerial, bSerial)
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
+
+type deviceFilter struct {
+	model        string
+	segment      string
+	serialPrefix string
+	ipPrefix     string
+	network      *net.IPNet
+	// mac is one of the MAC addresses the device must have.
+	mac string
+	// fields holds the other exact matches, a field of the device and the
+	// value it must have.
+	fields   []fieldMatch
+	selector models.Selector
+}
+
+type fieldMatch struct {
+	field func(d *models.Device) string
+	value string
+}
+
+func newDeviceFilter(q models.ListQuery) deviceFilter {
+	f := deviceFilter{model: q.Model, segment: q.Segment, serialPrefix: q.SerialPrefix, ipPrefix: q.IPPrefix, mac: q.MAC, selector: q.Selector}
+	for _, m := range []fieldMatch{
+		{func(d *models.Device) string { return d.Vendor }, q.Vendor},
+		{func(d *models.Device) string { return d.Firmware }, q.Firmware},
+		{func(d *models.Device) string { return d.Hostname }, q.Hostname},
+		{func(d *models.Device) string { return d.Site }, q.Site},
+		{func(d *models.Device) string { return d.Rack }, q.Rack},
+		{func(d *models.Device) string { return d.Status }, q.Status},
+	} {
+		if m.value != "" {
+			f.fields = append(f.fields, m)
+		}
+	}
+	if _, network, err := net.ParseCIDR(q.IPPrefix); err == nil {
+		f.network = network
+		f.ipPrefix = ""
+	}
+	return f
+}
+
+func (f deviceFilter) match(d models.Device) bool {
+	if f.model != "" && d.Model != f.model {
+		return false
+	}
+	if f.segment != "" && d.Segment != f.segment {
+		return false
+	}
+	if !strings.HasPrefix(d.SerialNum, f.serialPrefix) {
+		return false
+	}
+	if f.mac != "" && !slices.Contains(d.HardwareAddresses(), f.mac) {
+		return false
+	}
+	for _, m := range f.fields {
+		if m.field(&d) != m.value {
+			return false
+		}
+	}
+	if !f.selector.Matches(d.Labels) {
+		return false
+	}
+	return f.matchIP(d.IP) || (d.IPv6 != "" && f.matchIP(d.IPv6))
+}
+
+func (f deviceFilter) matchIP(address string) bool {
+	if f.network != nil {
+		ip := net.ParseIP(address)
+		return ip != nil && f.network.Contains(ip)
+	}
+	return strings.HasPrefix(address, f.ipPrefix)
+}
+
+// paginate cuts a page out of devices already sorted for q and fills the
+// cursor of the next page.
+func paginate(q models.ListQuery, devices []models.Device) models.ListResult {
+	if q.Offset > 0 {
+		if q.Offset >= len(devices) {
+			devices = nil
+		} else {
+			devices = devices[q.Offset:]
+		}
+	}
+
+	result := models.ListResult{Devices: devices}
+	if q.Limit > 0 && len(devices) > q.Limit {
+		result.Devices = devices[:q.Limit]
+		result.NextCursor = models.NewCursor(q, result.Devices[q.Limit-1]).String()
+	}
+	if result.Devices == nil {
+		result.Devices = []models.Device{}
+	}
+	return result
+}
@@ -0,0 +1,307 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var listFixture = []models.Device{
+	{SerialNum: "a-1", Model: "m1", IP: "10.0.0.9"},
+	{SerialNum: "a-2", Model: "m2", IP: "10.0.0.10"},
+	{SerialNum: "a-3", Model: "m1", IP: "192.168.1.1"},
+	{SerialNum: "b-1", Model: "m1", IP: "10.0.1.1"},
+	{SerialNum: "b-2", Model: "m2", IP: "172.16.0.1"},
+}
+
+// newBackends returns an empty instance of every Repository implementation.
+func newBackends(t *testing.T) map[string]repositories.Repository {
+	sqlRepo, err := repositories.OpenSQLRepo("sqlite3", filepath.Join(t.TempDir(), "devices.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlRepo.Close() })
+	walRepo, err := repositories.OpenWALRepo(filepath.Join(t.TempDir(), "devices.wal"), filepath.Join(t.TempDir(), "devices.json"), 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = walRepo.Close() })
+
+	return map[string]repositories.Repository{
+		"memory": repositories.NewRepoDevice(),
+		"sql":    sqlRepo,
+		"wal":    walRepo,
+	}
+}
+
+func listBackends(t *testing.T) map[string]repositories.Repository {
+	backends := newBackends(t)
+	for _, repo := range backends {
+		for _, d := range listFixture {
+			require.NoError(t, repo.CreateDevice(context.Background(), d))
+		}
+	}
+	return backends
+}
+
+// untimed drops the times a repository stamps, to compare a device with a
+// literal one.
+func untimed(d models.Device) models.Device {
+	d.CreatedAt, d.UpdatedAt = time.Time{}, time.Time{}
+	return d
+}
+
+func selector(t *testing.T, s string) models.Selector {
+	selector, err := models.ParseSelector(s)
+	require.NoError(t, err)
+	return selector
+}
+
+func serials(devices []models.Device) []string {
+	result := make([]string, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, d.SerialNum)
+	}
+	return result
+}
+
+func TestListDevices(t *testing.T) {
+	tests := []struct {
+		name  string
+		query models.ListQuery
+		want  []string
+	}{
+		{"all", models.ListQuery{}, []string{"a-1", "a-2", "a-3", "b-1", "b-2"}},
+		{"by model", models.ListQuery{Model: "m1"}, []string{"a-1", "a-3", "b-1"}},
+		{"serial prefix", models.ListQuery{SerialPrefix: "b-"}, []string{"b-1", "b-2"}},
+		{"ip prefix", models.ListQuery{IPPrefix: "10.0.0."}, []string{"a-1", "a-2"}},
+		{"cidr", models.ListQuery{IPPrefix: "10.0.0.0/16"}, []string{"a-1", "a-2", "b-1"}},
+		{"sort by ip is numeric", models.ListQuery{SortBy: models.SortByIP}, []string{"a-1", "a-2", "b-1", "b-2", "a-3"}},
+		{"sort by model desc", models.ListQuery{SortBy: models.SortByModel, Desc: true}, []string{"b-2", "a-2", "b-1", "a-3", "a-1"}},
+		{"offset", models.ListQuery{Offset: 3}, []string{"b-1", "b-2"}},
+		{"limit", models.ListQuery{Limit: 2, SerialPrefix: "a"}, []string{"a-1", "a-2"}},
+	}
+	for name, repo := range listBackends(t) {
+		for _, test := range tests {
+			result, err := repo.ListDevices(context.Background(), test.query)
+			require.NoError(t, err, "%s: %s", name, test.name)
+			require.Equal(t, test.want, serials(result.Devices), "%s: %s", name, test.name)
+		}
+	}
+}
+
+func TestListDevicesCursor(t *testing.T) {
+	queries := []models.ListQuery{
+		{Limit: 2},
+		{Limit: 2, Desc: true},
+		{Limit: 2, SortBy: models.SortByIP},
+		{Limit: 1, SortBy: models.SortByModel, Desc: true, Model: "m1"},
+	}
+	for name, repo := range listBackends(t) {
+		for _, q := range queries {
+			all := q
+			all.Limit = 0
+			want, err := repo.ListDevices(context.Background(), all)
+			require.NoError(t, err)
+
+			var got []models.Device
+			for {
+				page, err := repo.ListDevices(context.Background(), q)
+				require.NoError(t, err, name)
+				got = append(got, page.Devices...)
+				if page.NextCursor == "" {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+			require.Equal(t, serials(want.Devices), serials(got), "%s: %+v", name, q)
+		}
+	}
+}
+
+func TestListDevicesForeignCursor(t *testing.T) {
+	for name, repo := range listBackends(t) {
+		page, err := repo.ListDevices(context.Background(), models.ListQuery{Limit: 1})
+		require.NoError(t, err)
+
+		_, err = repo.ListDevices(context.Background(), models.ListQuery{Limit: 1, SortBy: models.SortByIP, Cursor: page.NextCursor})
+		require.ErrorIs(t, err, models.ErrInvalidCursor, name)
+	}
+}
+
+func TestListDevicesDualStack(t *testing.T) {
+	for name, repo := range listBackends(t) {
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "c-1", Model: "m3", IP: "2001:db8::1"}))
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "c-2", Model: "m3", IP: "10.1.0.1", IPv6: "2001:db8::2"}))
+
+		result, err := repo.ListDevices(context.Background(), models.ListQuery{IPPrefix: "2001:db8::/64"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"c-1", "c-2"}, serials(result.Devices), name)
+
+		result, err = repo.ListDevices(context.Background(), models.ListQuery{IPPrefix: "2001:db8::2"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"c-2"}, serials(result.Devices), name)
+
+		result, err = repo.ListDevices(context.Background(), models.ListQuery{IPPrefix: "10.0.0.0/8", SerialPrefix: "c"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"c-2"}, serials(result.Devices), name)
+
+		got, err := repo.GetDevice(context.Background(), "c-2")
+		require.NoError(t, err)
+		require.Equal(t, "2001:db8::2", got.IPv6, name)
+	}
+}
+
+func TestListDevicesDetails(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		for _, d := range []models.Device{
+			{SerialNum: "1", Model: "m", IP: "10.0.0.1", Vendor: "acme", Site: "ams", Rack: "a1", Labels: map[string]string{"env": "prod", "tier": "edge"}},
+			{SerialNum: "2", Model: "m", IP: "10.0.0.2", Vendor: "acme", Site: "ams", Rack: "a2", Status: models.StatusMaintenance, Labels: map[string]string{"env": "prod"}},
+			{SerialNum: "3", Model: "m", IP: "10.0.0.3", Vendor: "initech", Site: "fra", MACs: []string{"00:00:5e:00:53:01", "00:00:5e:00:53:02"}, Hostname: "core-1.fra", Labels: map[string]string{"env": "lab"}},
+		} {
+			require.NoError(t, repo.CreateDevice(context.Background(), d), name)
+		}
+
+		for _, tc := range []struct {
+			q    models.ListQuery
+			want []string
+		}{
+			{models.ListQuery{Vendor: "acme"}, []string{"1", "2"}},
+			{models.ListQuery{Site: "ams", Rack: "a2"}, []string{"2"}},
+			{models.ListQuery{Status: models.StatusActive}, []string{"1", "3"}},
+			{models.ListQuery{MAC: "00:00:5e:00:53:02"}, []string{"3"}},
+			{models.ListQuery{Hostname: "core-1.fra"}, []string{"3"}},
+			{models.ListQuery{Selector: selector(t, "env=prod")}, []string{"1", "2"}},
+			{models.ListQuery{Selector: selector(t, "env=prod,tier=edge")}, []string{"1"}},
+			{models.ListQuery{Selector: selector(t, "tier=")}, []string{}},
+			{models.ListQuery{Vendor: "acme", Selector: selector(t, "env=lab")}, []string{}},
+		} {
+			result, err := repo.ListDevices(context.Background(), tc.q)
+			require.NoError(t, err, name)
+			require.Equal(t, tc.want, serials(result.Devices), "%s %+v", name, tc.q)
+		}
+
+		got, err := repo.GetDevice(context.Background(), "1")
+		require.NoError(t, err)
+		require.Equal(t, models.Device{
+			SerialNum: "1", Model: "m", IP: "10.0.0.1", Vendor: "acme", Site: "ams", Rack: "a1", Status: models.StatusActive,
+			Labels: map[string]string{"env": "prod", "tier": "edge"}, Version: 1,
+		}, untimed(got), name)
+
+		// Updating the labels replaces them.
+		got.Labels = map[string]string{"env": "lab"}
+		_, err = repo.UpdateDevice(context.Background(), got)
+		require.NoError(t, err, name)
+		result, err := repo.ListDevices(context.Background(), models.ListQuery{Selector: selector(t, "env=prod")})
+		require.NoError(t, err)
+		require.Equal(t, []string{"2"}, serials(result.Devices), name)
+	}
+}
+
+func TestListDevicesSelector(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		for _, d := range []models.Device{
+			{SerialNum: "1", Model: "m", IP: "10.0.0.1", Labels: map[string]string{"env": "prod", "rack": "a1"}},
+			{SerialNum: "2", Model: "m", IP: "10.0.0.2", Labels: map[string]string{"env": "prod", "rack": "a2", "deprecated": ""}},
+			{SerialNum: "3", Model: "m", IP: "10.0.0.3", Labels: map[string]string{"env": "lab", "rack": "a1"}},
+			{SerialNum: "4", Model: "m", IP: "10.0.0.4"},
+		} {
+			require.NoError(t, repo.CreateDevice(context.Background(), d))
+		}
+
+		for _, tc := range []struct {
+			q    models.ListQuery
+			want []string
+		}{
+			{models.ListQuery{Selector: selector(t, "env=prod,rack in (a1,a2),!deprecated")}, []string{"1"}},
+			{models.ListQuery{Selector: selector(t, "env==prod")}, []string{"1", "2"}},
+			{models.ListQuery{Selector: selector(t, "env!=prod")}, []string{"3", "4"}},
+			{models.ListQuery{Selector: selector(t, "rack in (a1, a1)")}, []string{"1", "3"}},
+			{models.ListQuery{Selector: selector(t, "rack notin (a1)")}, []string{"2", "4"}},
+			{models.ListQuery{Selector: selector(t, "deprecated")}, []string{"2"}},
+			{models.ListQuery{Selector: selector(t, "!env")}, []string{"4"}},
+			{models.ListQuery{Selector: selector(t, "env in (staging)")}, []string{}},
+			{models.ListQuery{Selector: selector(t, "env"), SerialPrefix: "3"}, []string{"3"}},
+			{models.ListQuery{Selector: selector(t, "env"), SortBy: models.SortByIP, Desc: true}, []string{"3", "2", "1"}},
+		} {
+			result, err := repo.ListDevices(context.Background(), tc.q)
+			require.NoError(t, err, name)
+			require.Equal(t, tc.want, serials(result.Devices), "%s %+v", name, tc.q)
+		}
+
+		// The pages of a selection follow each other.
+		q := models.ListQuery{Selector: selector(t, "rack"), Limit: 2}
+		page, err := repo.ListDevices(context.Background(), q)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1", "2"}, serials(page.Devices), name)
+		q.Cursor = page.NextCursor
+		page, err = repo.ListDevices(context.Background(), q)
+		require.NoError(t, err)
+		require.Equal(t, []string{"3"}, serials(page.Devices), name)
+
+		// The index follows the updates and the deletions.
+		_, err = repo.UpdateDevice(context.Background(), models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3", Labels: map[string]string{"env": "prod"}})
+		require.NoError(t, err)
+		require.NoError(t, repo.DeleteDevice(context.Background(), "1"))
+		result, err := repo.ListDevices(context.Background(), models.ListQuery{Selector: selector(t, "env=prod")})
+		require.NoError(t, err)
+		require.Equal(t, []string{"2", "3"}, serials(result.Devices), name)
+		_, err = repo.RestoreDevice(context.Background(), "1")
+		require.NoError(t, err)
+		result, err = repo.ListDevices(context.Background(), models.ListQuery{Selector: selector(t, "rack=a1")})
+		require.NoError(t, err)
+		require.Equal(t, []string{"1"}, serials(result.Devices), name)
+	}
+}
+
+func TestListDevicesSortDetails(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		ctx := context.Background()
+		devices := []models.Device{
+			{SerialNum: "1", Model: "m", IP: "10.0.0.1", Vendor: "initech", Firmware: "2.0", Hostname: "b", Site: "fra", Rack: "a2", Status: models.StatusProvisioning},
+			{SerialNum: "2", Model: "m", IP: "10.0.0.2", Vendor: "acme", Firmware: "1.0", Hostname: "c", Site: "ams", Rack: "a1", Status: models.StatusMaintenance},
+			{SerialNum: "3", Model: "m", IP: "10.0.0.3", Vendor: "globex", Hostname: "a", Site: "ams", Rack: "a3"},
+		}
+		for _, d := range devices {
+			require.NoError(t, repo.CreateDevice(ctx, d), name)
+		}
+		devices[0].Model = "m2"
+		_, err := repo.UpdateDevice(ctx, devices[0])
+		require.NoError(t, err, name)
+
+		for _, tc := range []struct {
+			q    models.ListQuery
+			want []string
+		}{
+			{models.ListQuery{SortBy: models.SortByVendor}, []string{"2", "3", "1"}},
+			{models.ListQuery{SortBy: models.SortByFirmware}, []string{"3", "2", "1"}},
+			{models.ListQuery{SortBy: models.SortByHostname}, []string{"3", "1", "2"}},
+			{models.ListQuery{SortBy: models.SortBySite, Desc: true}, []string{"1", "3", "2"}},
+			{models.ListQuery{SortBy: models.SortByRack}, []string{"2", "1", "3"}},
+			{models.ListQuery{SortBy: models.SortByStatus}, []string{"3", "2", "1"}},
+			{models.ListQuery{SortBy: models.SortByCreatedAt}, []string{"1", "2", "3"}},
+			{models.ListQuery{SortBy: models.SortByUpdatedAt}, []string{"2", "3", "1"}},
+			{models.ListQuery{SortBy: models.SortByUpdatedAt, Desc: true}, []string{"1", "3", "2"}},
+		} {
+			result, err := repo.ListDevices(ctx, tc.q)
+			require.NoError(t, err, name)
+			require.Equal(t, tc.want, serials(result.Devices), "%s %+v", name, tc.q)
+
+			// The cursors walk the same order a page at a time.
+			q := tc.q
+			q.Limit = 1
+			var got []models.Device
+			for {
+				page, err := repo.ListDevices(ctx, q)
+				require.NoError(t, err, name)
+				got = append(got, page.Devices...)
+				if page.NextCursor == "" {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+			require.Equal(t, tc.want, serials(got), "%s %+v", name, q)
+		}
+	}
+}
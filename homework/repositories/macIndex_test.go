@@ -0,0 +1,75 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMACUniqueness(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		ctx := context.Background()
+		require.NoError(t, repo.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: []string{"00:00:5e:00:53:01", "00:00:5e:00:53:02"}}))
+
+		err := repo.CreateDevice(ctx, models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2", MACs: []string{"00:00:5e:00:53:02"}})
+		require.ErrorIs(t, err, models.ErrMACConflict, name)
+		_, err = repo.GetDevice(ctx, "2")
+		require.ErrorIs(t, err, models.ErrNotFound, "%s: failed create must not leave the device behind", name)
+
+		// The segments do not matter, a MAC address is unique everywhere.
+		err = repo.CreateDevice(ctx, models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2", Segment: "lab", MAC: "00:00:5e:00:53:01"})
+		require.ErrorIs(t, err, models.ErrMACConflict, name)
+
+		require.NoError(t, repo.CreateDevice(ctx, models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3"}))
+		_, err = repo.UpdateDevice(ctx, models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3", MACs: []string{"00:00:5e:00:53:01"}})
+		require.ErrorIs(t, err, models.ErrMACConflict, name)
+
+		// Keeping its own addresses is not a conflict, and the released one
+		// can be taken by another device.
+		_, err = repo.UpdateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: []string{"00:00:5e:00:53:01"}})
+		require.NoError(t, err, name)
+		_, err = repo.UpdateDevice(ctx, models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3", MACs: []string{"00:00:5e:00:53:02"}})
+		require.NoError(t, err, name)
+
+		// A deleted device gives its addresses back, and cannot be restored
+		// while another device holds them.
+		require.NoError(t, repo.DeleteDevice(ctx, "1"))
+		require.NoError(t, repo.CreateDevice(ctx, models.Device{SerialNum: "4", Model: "m", IP: "10.0.0.4", MACs: []string{"00:00:5e:00:53:01"}}), name)
+		_, err = repo.RestoreDevice(ctx, "1")
+		require.ErrorIs(t, err, models.ErrMACConflict, name)
+	}
+}
+
+func TestGetDeviceByMAC(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		ctx := context.Background()
+		require.NoError(t, repo.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: []string{"00:00:5e:00:53:01", "00:00:5e:00:53:02"}}))
+
+		got, err := repo.GetDeviceByMAC(ctx, "00:00:5e:00:53:02")
+		require.NoError(t, err, name)
+		assert.Equal(t, "1", got.SerialNum, name)
+		assert.Equal(t, []string{"00:00:5e:00:53:01", "00:00:5e:00:53:02"}, got.MACs, name)
+
+		_, err = repo.GetDeviceByMAC(ctx, "00:00:5e:00:53:03")
+		require.ErrorIs(t, err, models.ErrNotFound, name)
+
+		require.NoError(t, repo.DeleteDevice(ctx, "1"))
+		_, err = repo.GetDeviceByMAC(ctx, "00:00:5e:00:53:01")
+		require.ErrorIs(t, err, models.ErrNotFound, name)
+	}
+}
+
+func TestLegacyMAC(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		ctx := context.Background()
+		require.NoError(t, repo.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MAC: "00:00:5e:00:53:01"}))
+
+		got, err := repo.GetDeviceByMAC(ctx, "00:00:5e:00:53:01")
+		require.NoError(t, err, name)
+		assert.Equal(t, []string{"00:00:5e:00:53:01"}, got.MACs, name)
+		assert.Empty(t, got.MAC, name)
+	}
+}
@@ -0,0 +1,118 @@
+package repositories_test
+
+import (
+	"context"
+	"database/sql"
+	"homework/models"
+	"homework/repositories"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// olderDatabase builds the database of the release that stopped at version,
+// holding the rows of stmts.
+func olderDatabase(t *testing.T, version int, stmts ...string) string {
+	path := filepath.Join(t.TempDir(), "devices.db")
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, repositories.MigrateTo(db, version))
+	for _, stmt := range stmts {
+		_, err := db.Exec(stmt)
+		require.NoError(t, err, stmt)
+	}
+	return path
+}
+
+func TestMigrateLegacyStatuses(t *testing.T) {
+	path := olderDatabase(t, 7,
+		`INSERT INTO devices (serial_num, model, ip, status) VALUES ('1', 'm', '10.0.0.1', 'planned')`,
+		`INSERT INTO devices (serial_num, model, ip, status) VALUES ('2', 'm', '10.0.0.2', 'retired')`,
+		`INSERT INTO devices (serial_num, model, ip, status) VALUES ('3', 'm', '10.0.0.3', 'maintenance')`,
+		`INSERT INTO deleted_devices (serial_num, model, ip, ipv6, segment, version, deleted_at, status)
+			VALUES ('4', 'm', '10.0.0.4', '', '', 1, 1, 'retired')`,
+	)
+
+	repo, err := repositories.OpenSQLRepo("sqlite3", path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	for serialNum, status := range map[string]string{"1": models.StatusProvisioning, "2": models.StatusDecommissioned, "3": models.StatusMaintenance} {
+		device, err := repo.GetDevice(context.Background(), serialNum)
+		require.NoError(t, err)
+		assert.Equal(t, status, device.Status, serialNum)
+	}
+	deleted, err := repo.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, models.StatusDecommissioned, deleted[0].Device.Status)
+}
+
+func TestMigrateDuplicateIPs(t *testing.T) {
+	path := olderDatabase(t, 3,
+		`INSERT INTO devices (serial_num, model, ip) VALUES ('1', 'm', '10.0.0.1')`,
+		`INSERT INTO devices (serial_num, model, ip) VALUES ('2', 'm', '10.0.0.1')`,
+		`INSERT INTO devices (serial_num, model, ip, ipv6) VALUES ('3', 'm', '10.0.0.3', '2001:db8::1')`,
+		`INSERT INTO devices (serial_num, model, ip, ipv6) VALUES ('4', 'm', '10.0.0.4', '2001:db8::1')`,
+		`INSERT INTO devices (serial_num, model, ip) VALUES ('5', 'm', '10.0.0.1')`,
+	)
+
+	_, err := repositories.OpenSQLRepo("sqlite3", path)
+	require.ErrorIs(t, err, models.ErrIPConflict)
+	assert.ErrorContains(t, err, `"10.0.0.1" is used by "1", "2" and "5"; "2001:db8::1" is used by "3" and "4"`)
+
+	// Nothing was dropped, the devices can be fixed and the upgrade retried.
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	for _, stmt := range []string{
+		`UPDATE devices SET ip = '10.0.0.2' WHERE serial_num = '2'`,
+		`UPDATE devices SET ipv6 = '' WHERE serial_num = '4'`,
+		`UPDATE devices SET ip = '10.0.0.5' WHERE serial_num = '5'`,
+	} {
+		_, err := db.Exec(stmt)
+		require.NoError(t, err, stmt)
+	}
+
+	repo, err := repositories.OpenSQLRepo("sqlite3", path)
+	require.NoError(t, err)
+	defer repo.Close()
+	device, err := repo.GetDeviceByIP(context.Background(), "2001:db8::1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "3", device.SerialNum)
+	result, err := repo.ListDevices(context.Background(), models.ListQuery{})
+	require.NoError(t, err)
+	assert.Len(t, result.Devices, 5)
+}
+
+func TestMigrateSharedMACs(t *testing.T) {
+	path := olderDatabase(t, 8,
+		`INSERT INTO devices (serial_num, model, ip, mac) VALUES ('1', 'm', '10.0.0.1', '00:00:5e:00:53:01')`,
+		`INSERT INTO devices (serial_num, model, ip, mac) VALUES ('2', 'm', '10.0.0.2', '00:00:5e:00:53:01')`,
+		`INSERT INTO devices (serial_num, model, ip, mac) VALUES ('3', 'm', '10.0.0.3', '00:00:5e:00:53:03')`,
+	)
+
+	_, err := repositories.OpenSQLRepo("sqlite3", path)
+	require.ErrorIs(t, err, models.ErrMACConflict)
+	assert.ErrorContains(t, err, `"00:00:5e:00:53:01" is used by "1" and "2"`)
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`UPDATE devices SET mac = '00:00:5e:00:53:02' WHERE serial_num = '2'`)
+	require.NoError(t, err)
+
+	repo, err := repositories.OpenSQLRepo("sqlite3", path)
+	require.NoError(t, err)
+	defer repo.Close()
+	for serialNum, mac := range map[string]string{"1": "00:00:5e:00:53:01", "2": "00:00:5e:00:53:02", "3": "00:00:5e:00:53:03"} {
+		device, err := repo.GetDeviceByMAC(context.Background(), mac)
+		require.NoError(t, err, mac)
+		assert.Equal(t, serialNum, device.SerialNum, mac)
+		assert.Equal(t, []string{mac}, device.MACs, mac)
+	}
+}
@@ -0,0 +1,277 @@
+package repositories
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homework/models"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotFormat is bumped whenever the layout of a snapshot file changes.
+const snapshotFormat = 1
+
+type snapshot struct {
+	Format  int       `json:"format"`
+	TakenAt time.Time `json:"taken_at"`
+	// Seq is the last write-ahead log record the snapshot holds.
+	Seq     uint64                 `json:"seq,omitempty"`
+	Devices []models.Device        `json:"devices"`
+	Deleted []models.DeletedDevice `json:"deleted,omitempty"`
+}
+
+// WriteSnapshot saves every device to path. The file is written next to path,
+// synced and renamed over it, so path always holds a complete snapshot.
+func (ds *RepoDevice) WriteSnapshot(path string) (models.SnapshotInfo, error) {
+	s, _ := ds.snapshot()
+	return s.info(path), writeFileAtomic(path, s)
+}
+
+func (ds *RepoDevice) snapshot() (snapshot, uint64) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.capture(), ds.changes
+}
+
+// capture copies every device into a snapshot. Callers hold the lock.
+func (ds *RepoDevice) capture() snapshot {
+	s := snapshot{Format: snapshotFormat, TakenAt: time.Now().UTC(), Seq: ds.seq, Devices: make([]models.Device, 0, len(ds.order))}
+	for _, serialNum := range ds.order {
+		s.Devices = append(s.Devices, ds.devices[serialNum])
+	}
+	for _, d := range ds.trash {
+		s.Deleted = append(s.Deleted, d)
+	}
+	sortDeleted(s.Deleted)
+	return s
+}
+
+func (s snapshot) info(path string) models.SnapshotInfo {
+	return models.SnapshotInfo{File: filepath.Base(path), Devices: len(s.Devices), TakenAt: s.TakenAt}
+}
+
+// ReadSnapshot replaces every device by the ones saved in path. A snapshot
+// that does not decode or holds conflicting devices leaves the repository
+// untouched.
+func (ds *RepoDevice) ReadSnapshot(path string) (models.SnapshotInfo, error) {
+	restored, info, err := loadSnapshot(path)
+	if err != nil {
+		return info, err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.install(restored)
+	return info, nil
+}
+
+// install replaces the content of ds by the one of restored. The log
+// sequence never goes back, so records written before a restore are not
+// mistaken for later ones. Callers hold the lock.
+func (ds *RepoDevice) install(restored *RepoDevice) {
+	ds.devices, ds.order, ds.byIP, ds.byMAC, ds.byLabel, ds.trash = restored.devices, restored.order, restored.byIP, restored.byMAC, restored.byLabel, restored.trash
+	ds.seq = max(ds.seq, restored.seq)
+	ds.changes++
+}
+
+func loadSnapshot(path string) (*RepoDevice, models.SnapshotInfo, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, models.SnapshotInfo{}, fmt.Errorf("%q :%w", filepath.Base(path), models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, models.SnapshotInfo{}, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, models.SnapshotInfo{}, fmt.Errorf("%q: %v :%w", filepath.Base(path), err, models.ErrInvalidSnapshot)
+	}
+	if s.Format != snapshotFormat {
+		return nil, models.SnapshotInfo{}, fmt.Errorf("%q has format %d :%w", filepath.Base(path), s.Format, models.ErrInvalidSnapshot)
+	}
+
+	// Every conflict is reported: a snapshot taken while devices could share
+	// a MAC address needs them all fixed before it loads.
+	restored := NewRepoDevice()
+	var conflicts []string
+	for _, d := range s.Devices {
+		if err := restored.checkCreate(d); err != nil {
+			conflicts = append(conflicts, fmt.Sprintf("device %q: %v", d.SerialNum, err))
+			continue
+		}
+		restored.put(d)
+	}
+	if len(conflicts) > 0 {
+		return nil, models.SnapshotInfo{}, fmt.Errorf("%q: %s :%w", filepath.Base(path), strings.Join(conflicts, "; "), models.ErrInvalidSnapshot)
+	}
+	for _, d := range s.Deleted {
+		restored.trash[d.SerialNum] = d
+	}
+	restored.seq = s.Seq
+	return restored, s.info(path), nil
+}
+
+func writeFileAtomic(path string, v any) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := json.NewEncoder(tmp).Encode(v); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Snapshotter keeps the snapshots of a RepoDevice in a directory: it writes
+// the default one periodically and on Stop, and saves or restores named ones
+// on demand. Names never leave the directory.
+type Snapshotter struct {
+	repo        *RepoDevice
+	restore     func(path string) (models.SnapshotInfo, error)
+	dir         string
+	defaultName string
+	interval    time.Duration
+
+	mu sync.Mutex
+	// saved is the change counter of the repository at the last periodic
+	// snapshot, unchanged devices are not written again.
+	saved uint64
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewSnapshotter keeps the snapshots next to path, path being the default one.
+func NewSnapshotter(repo *RepoDevice, path string, interval time.Duration) *Snapshotter {
+	return &Snapshotter{
+		repo:        repo,
+		restore:     repo.ReadSnapshot,
+		dir:         filepath.Dir(path),
+		defaultName: filepath.Base(path),
+		interval:    interval,
+	}
+}
+
+func (s *Snapshotter) path(name string) (string, error) {
+	if name == "" {
+		name = s.defaultName
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%q is not a file name :%w", name, models.ErrInvalidSnapshot)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// Snapshot writes the named snapshot, the default one when name is empty.
+func (s *Snapshotter) Snapshot(name string) (models.SnapshotInfo, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return models.SnapshotInfo{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, changes := s.repo.snapshot()
+	if err := writeFileAtomic(path, snap); err != nil {
+		return models.SnapshotInfo{}, err
+	}
+	if path == filepath.Join(s.dir, s.defaultName) {
+		s.saved = changes
+	}
+	return snap.info(path), nil
+}
+
+// Restore replaces the devices by the named snapshot, the default one when
+// name is empty.
+func (s *Snapshotter) Restore(name string) (models.SnapshotInfo, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return models.SnapshotInfo{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restore(path)
+}
+
+// Load restores the default snapshot if there is one.
+func (s *Snapshotter) Load() (models.SnapshotInfo, error) {
+	info, err := s.Restore("")
+	if errors.Is(err, models.ErrNotFound) {
+		return info, nil
+	}
+	return info, err
+}
+
+// Start writes the default snapshot every interval until Stop.
+func (s *Snapshotter) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.snapshotIfChanged(); err != nil {
+					log.Printf("periodic snapshot: %v", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Snapshotter) snapshotIfChanged() error {
+	s.repo.mu.RLock()
+	changes := s.repo.changes
+	s.repo.mu.RUnlock()
+
+	s.mu.Lock()
+	unchanged := changes == s.saved
+	s.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	_, err := s.Snapshot("")
+	return err
+}
+
+// Stop ends the periodic snapshots and writes a last one.
+func (s *Snapshotter) Stop() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	_, err := s.Snapshot("")
+	return err
+}
@@ -0,0 +1,137 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	repo := repositories.NewRepoDevice()
+	for _, d := range listFixture {
+		require.NoError(t, repo.CreateDevice(context.Background(), d))
+	}
+	_, err := repo.UpdateDevice(context.Background(), models.Device{SerialNum: "a-1", Model: "m1", IP: "10.0.0.9", IPv6: "fd00::1", MACs: []string{"00:00:5e:00:53:01"}, Labels: map[string]string{"env": "prod"}})
+	require.NoError(t, err)
+
+	info, err := repo.WriteSnapshot(path)
+	require.NoError(t, err)
+	assert.Equal(t, len(listFixture), info.Devices)
+	assert.Equal(t, "devices.json", info.File)
+
+	restored := repositories.NewRepoDevice()
+	require.NoError(t, restored.CreateDevice(context.Background(), models.Device{SerialNum: "gone", Model: "m", IP: "10.0.0.9"}))
+	_, err = restored.ReadSnapshot(path)
+	require.NoError(t, err)
+
+	_, err = restored.GetDevice(context.Background(), "gone")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	got, err := restored.GetDeviceByIP(context.Background(), "fd00::1", "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got.Version)
+	result, err := restored.ListDevices(context.Background(), models.ListQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a-1", "a-2", "a-3", "b-1", "b-2"}, serials(result.Devices))
+	result, err = restored.ListDevices(context.Background(), models.ListQuery{Selector: selector(t, "env=prod")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a-1"}, serials(result.Devices))
+	got, err = restored.GetDeviceByMAC(context.Background(), "00:00:5e:00:53:01")
+	require.NoError(t, err)
+	assert.Equal(t, "a-1", got.SerialNum)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary file is left behind")
+}
+
+func TestReadSnapshotRejectsBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"garbage.json":   `{"format": 1, "devices": [`,
+		"format.json":    `{"format": 99, "devices": []}`,
+		"twice.json":     `{"format": 1, "devices": [{"serial_num": "1", "ip": "10.0.0.1"}, {"serial_num": "1", "ip": "10.0.0.2"}]}`,
+		"addresses.json": `{"format": 1, "devices": [{"serial_num": "1", "ip": "10.0.0.1"}, {"serial_num": "2", "ip": "10.0.0.1"}]}`,
+	}
+	repo := repositories.NewRepoDevice()
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "kept", Model: "m", IP: "10.0.0.1"}))
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := repo.ReadSnapshot(path)
+		assert.ErrorIs(t, err, models.ErrInvalidSnapshot, name)
+	}
+	_, err := repo.ReadSnapshot(filepath.Join(dir, "missing.json"))
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = repo.GetDevice(context.Background(), "kept")
+	assert.NoError(t, err)
+}
+
+func TestSnapshotterNames(t *testing.T) {
+	dir := t.TempDir()
+	snapshots := repositories.NewSnapshotter(repositories.NewRepoDevice(), filepath.Join(dir, "devices.json"), time.Hour)
+
+	for _, name := range []string{"../devices.json", "/etc/passwd", "a/b.json", "..", ".hidden"} {
+		_, err := snapshots.Snapshot(name)
+		assert.ErrorIs(t, err, models.ErrInvalidSnapshot, name)
+		_, err = snapshots.Restore(name)
+		assert.ErrorIs(t, err, models.ErrInvalidSnapshot, name)
+	}
+
+	info, err := snapshots.Snapshot("before-upgrade.json")
+	require.NoError(t, err)
+	assert.Equal(t, "before-upgrade.json", info.File)
+	assert.FileExists(t, filepath.Join(dir, "before-upgrade.json"))
+}
+
+func TestSnapshotterLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	repo := repositories.NewRepoDevice()
+	snapshots := repositories.NewSnapshotter(repo, path, 10*time.Millisecond)
+
+	info, err := snapshots.Load()
+	require.NoError(t, err)
+	assert.Zero(t, info.Devices)
+
+	snapshots.Start()
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}))
+	require.NoError(t, snapshots.Stop())
+
+	reloaded := repositories.NewRepoDevice()
+	info, err = repositories.NewSnapshotter(reloaded, path, time.Hour).Load()
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Devices)
+}
+
+func TestSnapshotSharedMACs(t *testing.T) {
+	// Devices could share a MAC address when this snapshot was taken.
+	path := filepath.Join(t.TempDir(), "devices.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"format": 1, "devices": [
+		{"serial_num": "1", "model": "m", "ip": "10.0.0.1", "mac": "00:00:5e:00:53:01"},
+		{"serial_num": "2", "model": "m", "ip": "10.0.0.2", "mac": "00:00:5e:00:53:01"},
+		{"serial_num": "3", "model": "m", "ip": "10.0.0.3", "mac": "00:00:5e:00:53:01"}
+	]}`), 0o600))
+
+	_, err := repositories.NewRepoDevice().ReadSnapshot(path)
+	require.ErrorIs(t, err, models.ErrInvalidSnapshot)
+	assert.ErrorContains(t, err, `device "2": "00:00:5e:00:53:01" is used by "1"`)
+	assert.ErrorContains(t, err, `device "3": "00:00:5e:00:53:01" is used by "1"`)
+
+	_, err = repositories.OpenWALRepo(filepath.Join(t.TempDir(), "devices.wal"), path, 0)
+	assert.ErrorContains(t, err, `device "2": "00:00:5e:00:53:01" is used by "1"`)
+}
@@ -0,0 +1,877 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homework/models"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLRepo stores devices in any database/sql backend that understands "?"
+// placeholders and ON CONFLICT clauses (SQLite, MySQL 8 in ANSI mode, ...).
+type SQLRepo struct {
+	db *sql.DB
+}
+
+const deviceColumns = `serial_num, model, ip, ipv6, segment, vendor, firmware, macs, hostname, site, rack, status, status_reason, labels, version, created_at, updated_at`
+
+type migration struct {
+	version int
+	up      func(tx *sql.Tx) error
+}
+
+func execStatements(stmts ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// migrations are applied in order and recorded in schema_migrations, never edit
+// an already released one, append a new version instead.
+var migrations = []migration{
+	{
+		version: 1,
+		up: execStatements(
+			`CREATE TABLE devices (
+				serial_num TEXT NOT NULL PRIMARY KEY,
+				model      TEXT NOT NULL,
+				ip         TEXT NOT NULL
+			)`,
+		),
+	},
+	{
+		version: 2,
+		up: func(tx *sql.Tx) error {
+			err := execStatements(
+				`ALTER TABLE devices ADD COLUMN ip_bin BLOB`,
+				`CREATE INDEX devices_model_idx ON devices (model, serial_num)`,
+				`CREATE INDEX devices_ip_bin_idx ON devices (ip_bin, serial_num)`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			return backfillIPKeys(tx)
+		},
+	},
+	{
+		version: 3,
+		up: execStatements(
+			`ALTER TABLE devices ADD COLUMN ipv6 TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE devices ADD COLUMN ipv6_bin BLOB`,
+			`CREATE INDEX devices_ipv6_bin_idx ON devices (ipv6_bin)`,
+		),
+	},
+	{
+		version: 4,
+		up: func(tx *sql.Tx) error {
+			err := execStatements(
+				`ALTER TABLE devices ADD COLUMN segment TEXT NOT NULL DEFAULT ''`,
+				`CREATE TABLE device_addresses (
+					segment    TEXT NOT NULL,
+					address    TEXT NOT NULL,
+					serial_num TEXT NOT NULL,
+					PRIMARY KEY (segment, address)
+				)`,
+				`CREATE INDEX device_addresses_serial_num_idx ON device_addresses (serial_num)`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			// Every address was in the default segment.
+			claims := `SELECT ip AS address, serial_num FROM devices
+				UNION SELECT ipv6, serial_num FROM devices WHERE ipv6 <> ''`
+			if err := checkUnique(tx, claims, models.ErrIPConflict); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO device_addresses (segment, address, serial_num)
+				SELECT '', address, serial_num FROM (` + claims + `)`)
+			return err
+		},
+	},
+	{
+		version: 5,
+		up: execStatements(
+			`ALTER TABLE devices ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+		),
+	},
+	{
+		version: 6,
+		up: execStatements(
+			`CREATE TABLE deleted_devices (
+				serial_num TEXT NOT NULL PRIMARY KEY,
+				model      TEXT NOT NULL,
+				ip         TEXT NOT NULL,
+				ipv6       TEXT NOT NULL,
+				segment    TEXT NOT NULL,
+				version    INTEGER NOT NULL,
+				deleted_at INTEGER NOT NULL
+			)`,
+			`CREATE INDEX deleted_devices_deleted_at_idx ON deleted_devices (deleted_at)`,
+		),
+	},
+	{
+		version: 7,
+		up: execStatements(append(append(detailColumns("devices"), detailColumns("deleted_devices")...),
+			`CREATE INDEX devices_status_idx ON devices (status, serial_num)`,
+			`CREATE INDEX devices_site_idx ON devices (site, rack, serial_num)`,
+			`CREATE TABLE device_labels (
+				serial_num TEXT NOT NULL,
+				name       TEXT NOT NULL,
+				value      TEXT NOT NULL,
+				PRIMARY KEY (serial_num, name)
+			)`,
+			`CREATE INDEX device_labels_name_idx ON device_labels (name, value, serial_num)`,
+		)...),
+	},
+	{
+		// The lifecycle statuses were renamed, see models.LegacyStatuses.
+		version: 8,
+		up: execStatements(
+			`ALTER TABLE devices ADD COLUMN status_reason TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE deleted_devices ADD COLUMN status_reason TEXT NOT NULL DEFAULT ''`,
+			`UPDATE devices SET status = 'provisioning' WHERE status = 'planned'`,
+			`UPDATE devices SET status = 'decommissioned' WHERE status = 'retired'`,
+			`UPDATE deleted_devices SET status = 'provisioning' WHERE status = 'planned'`,
+			`UPDATE deleted_devices SET status = 'decommissioned' WHERE status = 'retired'`,
+		),
+	},
+	{
+		// A device has several MAC addresses, stored as a JSON array and
+		// indexed in device_macs. The mac column is left unused.
+		version: 9,
+		up: func(tx *sql.Tx) error {
+			// The devices could share a MAC address until then.
+			claims := `SELECT mac AS address, serial_num FROM devices WHERE mac <> ''`
+			if err := checkUnique(tx, claims, models.ErrMACConflict); err != nil {
+				return err
+			}
+			return execStatements(
+				`ALTER TABLE devices ADD COLUMN macs TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE deleted_devices ADD COLUMN macs TEXT NOT NULL DEFAULT ''`,
+				`UPDATE devices SET macs = '["' || mac || '"]' WHERE mac <> ''`,
+				`UPDATE deleted_devices SET macs = '["' || mac || '"]' WHERE mac <> ''`,
+				`CREATE TABLE device_macs (
+					mac        TEXT NOT NULL PRIMARY KEY,
+					serial_num TEXT NOT NULL
+				)`,
+				`CREATE INDEX device_macs_serial_num_idx ON device_macs (serial_num)`,
+				`INSERT INTO device_macs (mac, serial_num)
+					SELECT mac, serial_num FROM devices WHERE mac <> ''`,
+			)(tx)
+		},
+	},
+}
+
+// detailColumns adds the columns of version 7 to a table of devices. The
+// labels are stored as a JSON object, and indexed in device_labels; the times
+// are Unix nanoseconds, 0 for the devices stored before they existed.
+func detailColumns(table string) []string {
+	var stmts []string
+	for _, column := range []string{"vendor", "firmware", "mac", "hostname", "site", "rack", "labels"} {
+		stmts = append(stmts, `ALTER TABLE `+table+` ADD COLUMN `+column+` TEXT NOT NULL DEFAULT ''`)
+	}
+	return append(stmts,
+		`ALTER TABLE `+table+` ADD COLUMN status TEXT NOT NULL DEFAULT 'active'`,
+		`ALTER TABLE `+table+` ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE `+table+` ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0`,
+	)
+}
+
+// checkUnique fails with conflict, naming the devices, when an address is held
+// by more than one device. claims selects the address and the serial_num of
+// every device holding one.
+func checkUnique(tx *sql.Tx, claims string, conflict error) error {
+	rows, err := tx.Query(`SELECT address, serial_num FROM (` + claims + `)
+		WHERE address IN (
+			SELECT address FROM (` + claims + `) GROUP BY address HAVING COUNT(DISTINCT serial_num) > 1
+		)
+		GROUP BY address, serial_num ORDER BY address, serial_num`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var shared []string
+	var address string
+	var holders []string
+	flush := func() {
+		if len(holders) > 0 {
+			last := len(holders) - 1
+			shared = append(shared, fmt.Sprintf("%q is used by %s and %s", address, strings.Join(holders[:last], ", "), holders[last]))
+		}
+	}
+	for rows.Next() {
+		var a, serialNum string
+		if err := rows.Scan(&a, &serialNum); err != nil {
+			return err
+		}
+		if a != address {
+			flush()
+			address, holders = a, nil
+		}
+		holders = append(holders, strconv.Quote(serialNum))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	flush()
+	if len(shared) > 0 {
+		return fmt.Errorf("devices share addresses, give each its own before upgrading: %s :%w", strings.Join(shared, "; "), conflict)
+	}
+	return nil
+}
+
+func backfillIPKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT serial_num, ip FROM devices`)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string][]byte)
+	for rows.Next() {
+		var serialNum, ip string
+		if err := rows.Scan(&serialNum, &ip); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		keys[serialNum] = ipKey(ip)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for serialNum, key := range keys {
+		if _, err := tx.Exec(`UPDATE devices SET ip_bin = ? WHERE serial_num = ?`, key, serialNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func OpenSQLRepo(driver, dsn string) (*SQLRepo, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", driver, err)
+	}
+	repo, err := NewSQLRepo(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+func NewSQLRepo(db *sql.DB) (*SQLRepo, error) {
+	repo := &SQLRepo{db: db}
+	if err := repo.migrate(); err != nil {
+		return nil, fmt.Errorf("migrate database: %w", err)
+	}
+	return repo, nil
+}
+
+func (r *SQLRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLRepo) migrate() error {
+	return r.migrateTo(migrations[len(migrations)-1].version)
+}
+
+// migrateTo applies the migrations up to version, the later ones are left for
+// a later release.
+func (r *SQLRepo) migrateTo(version int) error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = r.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > version {
+			continue
+		}
+		if err := r.apply(m); err != nil {
+			return fmt.Errorf("version %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepo) apply(m migration) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withTx runs fn in a transaction bound to ctx, the transaction is rolled
+// back and its statements fail once ctx is done.
+func (r *SQLRepo) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deviceRow is a device scanned from deviceColumns, its MAC addresses, labels
+// and times are still in their column encoding.
+type deviceRow struct {
+	models.Device
+	macs, labels         string
+	createdAt, updatedAt int64
+}
+
+func (d *deviceRow) dest() []any {
+	return []any{
+		&d.SerialNum, &d.Model, &d.IP, &d.IPv6, &d.Segment, &d.Vendor, &d.Firmware, &d.macs, &d.Hostname,
+		&d.Site, &d.Rack, &d.Status, &d.StatusReason, &d.labels, &d.Version, &d.createdAt, &d.updatedAt,
+	}
+}
+
+func (d *deviceRow) device() (models.Device, error) {
+	if d.macs != "" {
+		if err := json.Unmarshal([]byte(d.macs), &d.MACs); err != nil {
+			return models.Device{}, fmt.Errorf("macs of %q: %w", d.SerialNum, err)
+		}
+	}
+	if d.labels != "" {
+		if err := json.Unmarshal([]byte(d.labels), &d.Labels); err != nil {
+			return models.Device{}, fmt.Errorf("labels of %q: %w", d.SerialNum, err)
+		}
+	}
+	d.CreatedAt, d.UpdatedAt = fromUnixNano(d.createdAt), fromUnixNano(d.updatedAt)
+	return d.Device, nil
+}
+
+func scanDevice(row interface{ Scan(...any) error }) (models.Device, error) {
+	var d deviceRow
+	if err := row.Scan(d.dest()...); err != nil {
+		return models.Device{}, err
+	}
+	return d.device()
+}
+
+func labelsColumn(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(labels)
+	return string(b)
+}
+
+func macsColumn(macs []string) string {
+	if len(macs) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(macs)
+	return string(b)
+}
+
+// unixNano keeps the zero time as 0, which time.UnixNano leaves undefined.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// indexLabels replaces the labels of a device in device_labels.
+func indexLabels(tx *sql.Tx, device models.Device) error {
+	if err := unindexLabels(tx, device.SerialNum); err != nil {
+		return err
+	}
+	for name, value := range device.Labels {
+		if _, err := tx.Exec(
+			`INSERT INTO device_labels (serial_num, name, value) VALUES (?, ?, ?)`, device.SerialNum, name, value,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexLabels(tx *sql.Tx, serialNumber string) error {
+	_, err := tx.Exec(`DELETE FROM device_labels WHERE serial_num = ?`, serialNumber)
+	return err
+}
+
+// claimAddresses indexes the addresses of a device and fails with
+// models.ErrIPConflict when one of them is taken in its segment.
+func claimAddresses(tx *sql.Tx, device models.Device) error {
+	for _, ip := range device.Addresses() {
+		res, err := tx.Exec(
+			`INSERT INTO device_addresses (segment, address, serial_num) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+			device.Segment, ip, device.SerialNum,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			var owner string
+			err := tx.QueryRow(
+				`SELECT serial_num FROM device_addresses WHERE segment = ? AND address = ?`, device.Segment, ip,
+			).Scan(&owner)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%q is used by %q :%w", ip, owner, models.ErrIPConflict)
+		}
+	}
+	return nil
+}
+
+func releaseAddresses(tx *sql.Tx, serialNumber string) error {
+	_, err := tx.Exec(`DELETE FROM device_addresses WHERE serial_num = ?`, serialNumber)
+	return err
+}
+
+// claimMACs indexes the MAC addresses of a device and fails with
+// models.ErrMACConflict when one of them is taken.
+func claimMACs(tx *sql.Tx, device models.Device) error {
+	for _, mac := range device.MACs {
+		res, err := tx.Exec(
+			`INSERT INTO device_macs (mac, serial_num) VALUES (?, ?) ON CONFLICT DO NOTHING`, mac, device.SerialNum,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			var owner string
+			if err := tx.QueryRow(`SELECT serial_num FROM device_macs WHERE mac = ?`, mac).Scan(&owner); err != nil {
+				return err
+			}
+			return fmt.Errorf("%q is used by %q :%w", mac, owner, models.ErrMACConflict)
+		}
+	}
+	return nil
+}
+
+func releaseMACs(tx *sql.Tx, serialNumber string) error {
+	_, err := tx.Exec(`DELETE FROM device_macs WHERE serial_num = ?`, serialNumber)
+	return err
+}
+
+func (r *SQLRepo) CreateDevice(ctx context.Context, device models.Device) error {
+	device.CreatedAt = time.Now().UTC()
+	device.UpdatedAt = device.CreatedAt
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		return createDevice(tx, device)
+	})
+}
+
+// createDevice inserts the device at version 1 with the times it has.
+func createDevice(tx *sql.Tx, device models.Device) error {
+	device.MACs = device.HardwareAddresses()
+	res, err := tx.Exec(
+		`INSERT INTO devices (serial_num, model, ip, ip_bin, ipv6, ipv6_bin, segment, vendor, firmware, macs, hostname,
+			site, rack, status, status_reason, labels, version, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT (serial_num) DO NOTHING`,
+		device.SerialNum, device.Model, device.IP, ipKey(device.IP), device.IPv6, ipKey(device.IPv6), device.Segment,
+		device.Vendor, device.Firmware, macsColumn(device.MACs), device.Hostname, device.Site, device.Rack, storedStatus(device),
+		device.StatusReason, labelsColumn(device.Labels), unixNano(device.CreatedAt), unixNano(device.UpdatedAt),
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%q :%w", device.SerialNum, models.ErrAlredyExist)
+	}
+	if err := claimAddresses(tx, device); err != nil {
+		return err
+	}
+	if err := claimMACs(tx, device); err != nil {
+		return err
+	}
+	return indexLabels(tx, device)
+}
+
+func (r *SQLRepo) GetDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	device, err := scanDevice(r.db.QueryRowContext(ctx,
+		`SELECT `+deviceColumns+` FROM devices WHERE serial_num = ?`, serialNumber,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Device{}, fmt.Errorf("%q :%w", serialNumber, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.Device{}, err
+	}
+	return device, nil
+}
+
+func (r *SQLRepo) GetDeviceByIP(ctx context.Context, ip, segment string) (models.Device, error) {
+	device, err := scanDevice(r.db.QueryRowContext(ctx,
+		`SELECT `+deviceColumns+` FROM devices WHERE serial_num =
+			(SELECT serial_num FROM device_addresses WHERE segment = ? AND address = ?)`,
+		segment, ip,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Device{}, fmt.Errorf("%q :%w", ip, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.Device{}, err
+	}
+	return device, nil
+}
+
+func (r *SQLRepo) GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error) {
+	device, err := scanDevice(r.db.QueryRowContext(ctx,
+		`SELECT `+deviceColumns+` FROM devices WHERE serial_num = (SELECT serial_num FROM device_macs WHERE mac = ?)`, mac,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Device{}, fmt.Errorf("%q :%w", mac, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.Device{}, err
+	}
+	return device, nil
+}
+
+func (r *SQLRepo) DeleteDevice(ctx context.Context, serialNumber string) error {
+	return r.CompareAndDeleteDevice(ctx, serialNumber, models.AnyVersion)
+}
+
+func (r *SQLRepo) CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		return deleteDevice(tx, serialNumber, version, time.Now().UTC())
+	})
+}
+
+// deleteDevice moves the device to the trash as deleted at the given time.
+func deleteDevice(tx *sql.Tx, serialNumber string, version int64, at time.Time) error {
+	if err := trashDevice(tx, serialNumber, version, at); err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`DELETE FROM devices WHERE serial_num = ? AND (? = 0 OR version = ?)`,
+		serialNumber, version, version,
+	)
+	if err != nil {
+		return err
+	}
+	if err := expectOneRow(tx, res, serialNumber, version); err != nil {
+		return err
+	}
+	if err := releaseAddresses(tx, serialNumber); err != nil {
+		return err
+	}
+	if err := releaseMACs(tx, serialNumber); err != nil {
+		return err
+	}
+	return unindexLabels(tx, serialNumber)
+}
+
+func (r *SQLRepo) UpdateDevice(ctx context.Context, device models.Device) (models.Device, error) {
+	return r.CompareAndSwapDevice(ctx, device, models.AnyVersion)
+}
+
+func (r *SQLRepo) CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error) {
+	at := time.Now().UTC()
+	var stored models.Device
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if err := swapDevice(tx, device, version, at); err != nil {
+			return err
+		}
+		var err error
+		stored, err = scanDevice(tx.QueryRow(`SELECT `+deviceColumns+` FROM devices WHERE serial_num = ?`, device.SerialNum))
+		return err
+	})
+	if err != nil {
+		return models.Device{}, err
+	}
+	return stored, nil
+}
+
+// swapDevice replaces the device as updated at the given time, but for its
+// status.
+func swapDevice(tx *sql.Tx, device models.Device, version int64, at time.Time) error {
+	device.MACs = device.HardwareAddresses()
+	res, err := tx.Exec(
+		`UPDATE devices SET model = ?, ip = ?, ip_bin = ?, ipv6 = ?, ipv6_bin = ?, segment = ?, vendor = ?, firmware = ?,
+			macs = ?, hostname = ?, site = ?, rack = ?, labels = ?, version = version + 1, updated_at = ?
+		WHERE serial_num = ? AND (? = 0 OR version = ?)`,
+		device.Model, device.IP, ipKey(device.IP), device.IPv6, ipKey(device.IPv6), device.Segment,
+		device.Vendor, device.Firmware, macsColumn(device.MACs), device.Hostname, device.Site, device.Rack,
+		labelsColumn(device.Labels), unixNano(at),
+		device.SerialNum, version, version,
+	)
+	if err != nil {
+		return err
+	}
+	if err := expectOneRow(tx, res, device.SerialNum, version); err != nil {
+		return err
+	}
+	if err := releaseAddresses(tx, device.SerialNum); err != nil {
+		return err
+	}
+	if err := claimAddresses(tx, device); err != nil {
+		return err
+	}
+	if err := releaseMACs(tx, device.SerialNum); err != nil {
+		return err
+	}
+	if err := claimMACs(tx, device); err != nil {
+		return err
+	}
+	return indexLabels(tx, device)
+}
+
+func (r *SQLRepo) TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error) {
+	at := time.Now().UTC()
+	var device models.Device
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE devices SET status = ?, status_reason = ?, version = version + 1, updated_at = ?
+			WHERE serial_num = ? AND (? = 0 OR version = ?)`,
+			status, reason, unixNano(at), serialNumber, version, version,
+		)
+		if err != nil {
+			return err
+		}
+		if err := expectOneRow(tx, res, serialNumber, version); err != nil {
+			return err
+		}
+		device, err = scanDevice(tx.QueryRow(`SELECT `+deviceColumns+` FROM devices WHERE serial_num = ?`, serialNumber))
+		return err
+	})
+	if err != nil {
+		return models.Device{}, err
+	}
+	return device, nil
+}
+
+// expectOneRow tells apart a missing device from one at another version when
+// a conditional statement matched nothing.
+func expectOneRow(tx *sql.Tx, res sql.Result, serialNumber string, version int64) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var current int64
+	err = tx.QueryRow(`SELECT version FROM devices WHERE serial_num = ?`, serialNumber).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%q :%w", serialNumber, models.ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%q is at version %d, not %d :%w", serialNumber, current, version, models.ErrVersionMismatch)
+}
+
+var sortColumns = map[string]string{
+	models.SortBySerialNum: "serial_num",
+	models.SortByModel:     "model",
+	models.SortByIP:        "ip_bin",
+	models.SortByVendor:    "vendor",
+	models.SortByFirmware:  "firmware",
+	models.SortByHostname:  "hostname",
+	models.SortBySite:      "site",
+	models.SortByRack:      "rack",
+	models.SortByStatus:    "status",
+	models.SortByCreatedAt: "created_at",
+	models.SortByUpdatedAt: "updated_at",
+}
+
+func (r *SQLRepo) ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error) {
+	if q.SortBy == "" {
+		q.SortBy = models.SortBySerialNum
+	}
+	column, ok := sortColumns[q.SortBy]
+	if !ok {
+		return models.ListResult{}, fmt.Errorf("unknown sort field %q", q.SortBy)
+	}
+
+	var where []string
+	var args []any
+	if q.Model != "" {
+		where = append(where, "model = ?")
+		args = append(args, q.Model)
+	}
+	if q.Segment != "" {
+		where = append(where, "segment = ?")
+		args = append(args, q.Segment)
+	}
+	for _, m := range []struct{ column, value string }{
+		{"vendor", q.Vendor}, {"firmware", q.Firmware}, {"hostname", q.Hostname},
+		{"site", q.Site}, {"rack", q.Rack}, {"status", q.Status},
+	} {
+		if m.value != "" {
+			where = append(where, m.column+" = ?")
+			args = append(args, m.value)
+		}
+	}
+	if q.MAC != "" {
+		where = append(where, "serial_num IN (SELECT serial_num FROM device_macs WHERE mac = ?)")
+		args = append(args, q.MAC)
+	}
+	for _, r := range q.Selector {
+		cond, condArgs := selectorCondition(r)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	if q.SerialPrefix != "" {
+		cond, condArgs := prefixCondition("serial_num", q.SerialPrefix)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	if q.IPPrefix != "" {
+		if _, network, err := net.ParseCIDR(q.IPPrefix); err == nil {
+			first, last := cidrRange(network)
+			where = append(where, "(ip_bin BETWEEN ? AND ? OR ipv6_bin BETWEEN ? AND ?)")
+			args = append(args, first, last, first, last)
+		} else {
+			cond, condArgs := prefixCondition("ip", q.IPPrefix)
+			cond6, cond6Args := prefixCondition("ipv6", q.IPPrefix)
+			where = append(where, "("+cond+" OR "+cond6+")")
+			args = append(args, condArgs...)
+			args = append(args, cond6Args...)
+		}
+	}
+
+	order, cmp := "ASC", ">"
+	if q.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		c, err := models.ParseCursor(q)
+		if err != nil {
+			return models.ListResult{}, err
+		}
+		var value any = c.Value
+		if q.SortBy == models.SortByIP {
+			value = ipKey(c.Value)
+		}
+		if slices.Contains(models.TimeSortFields, q.SortBy) {
+			if value, err = strconv.ParseInt(c.Value, 10, 64); err != nil {
+				return models.ListResult{}, models.ErrInvalidCursor
+			}
+		}
+		if q.SortBy == models.SortBySerialNum {
+			where = append(where, "serial_num "+cmp+" ?")
+			args = append(args, c.SerialNum)
+		} else {
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND serial_num %[2]s ?))", column, cmp))
+			args = append(args, value, value, c.SerialNum)
+		}
+	}
+
+	query := `SELECT ` + deviceColumns + ` FROM devices`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s", column, order)
+	if column != "serial_num" {
+		query += ", serial_num " + order
+	}
+	if q.Limit > 0 {
+		// One extra row tells whether there is a next page.
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.Limit+1, q.Offset)
+		q.Offset = 0
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return models.ListResult{}, err
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return models.ListResult{}, err
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return models.ListResult{}, err
+	}
+	return paginate(q, devices), nil
+}
+
+// selectorCondition is the condition of a label requirement, answered by
+// device_labels.
+func selectorCondition(r models.Requirement) (string, []any) {
+	in := "serial_num IN"
+	switch r.Op {
+	case models.SelectNotEquals, models.SelectNotIn, models.SelectDoesNotExist:
+		in = "serial_num NOT IN"
+	}
+	args := []any{r.Key}
+	if r.Op == models.SelectExists || r.Op == models.SelectDoesNotExist {
+		return in + " (SELECT serial_num FROM device_labels WHERE name = ?)", args
+	}
+	for _, value := range r.Values {
+		args = append(args, value)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(r.Values)), ", ")
+	return in + " (SELECT serial_num FROM device_labels WHERE name = ? AND value IN (" + placeholders + "))", args
+}
+
+// prefixCondition matches a prefix with a range instead of LIKE, which keeps
+// it case sensitive and lets the database use the column index.
+func prefixCondition(column, prefix string) (string, []any) {
+	end := []byte(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) == 0 {
+		return column + " >= ?", []any{prefix}
+	}
+	end[len(end)-1]++
+	return "(" + column + " >= ? AND " + column + " < ?)", []any{prefix, string(end)}
+}
@@ -0,0 +1,91 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type SQLRepoSuite struct {
+	suite.Suite
+	path string
+	repo *repositories.SQLRepo
+}
+
+func (s *SQLRepoSuite) SetupTest() {
+	s.path = filepath.Join(s.T().TempDir(), "devices.db")
+	repo, err := repositories.OpenSQLRepo("sqlite3", s.path)
+	s.Require().NoError(err)
+	s.repo = repo
+}
+
+func (s *SQLRepoSuite) TearDownTest() {
+	s.Require().NoError(s.repo.Close())
+}
+
+func (s *SQLRepoSuite) TestCRUD() {
+	device := models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1"}
+
+	s.Require().NoError(s.repo.CreateDevice(context.Background(), device))
+
+	got, err := s.repo.GetDevice(context.Background(), device.SerialNum)
+	s.Require().NoError(err)
+	device.Version = 1
+	device.Status = models.DefaultStatus
+	s.Equal(device, untimed(got))
+
+	device.IP = "1.1.1.2"
+	_, err = s.repo.UpdateDevice(context.Background(), device)
+	s.Require().NoError(err)
+
+	got, err = s.repo.GetDevice(context.Background(), device.SerialNum)
+	s.Require().NoError(err)
+	device.Version = 2
+	s.Equal(device, untimed(got))
+
+	s.Require().NoError(s.repo.DeleteDevice(context.Background(), device.SerialNum))
+
+	_, err = s.repo.GetDevice(context.Background(), device.SerialNum)
+	s.ErrorIs(err, models.ErrNotFound)
+}
+
+func (s *SQLRepoSuite) TestErrors() {
+	device := models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1"}
+	s.Require().NoError(s.repo.CreateDevice(context.Background(), device))
+
+	s.ErrorIs(s.repo.CreateDevice(context.Background(), device), models.ErrAlredyExist)
+	_, err := s.repo.UpdateDevice(context.Background(), models.Device{SerialNum: "124"})
+	s.ErrorIs(err, models.ErrNotFound)
+	s.ErrorIs(s.repo.DeleteDevice(context.Background(), "124"), models.ErrNotFound)
+}
+
+func (s *SQLRepoSuite) TestSurvivesReopen() {
+	device := models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1"}
+	s.Require().NoError(s.repo.CreateDevice(context.Background(), device))
+	s.Require().NoError(s.repo.Close())
+
+	repo, err := repositories.OpenSQLRepo("sqlite3", s.path)
+	s.Require().NoError(err)
+	s.repo = repo
+
+	got, err := s.repo.GetDevice(context.Background(), device.SerialNum)
+	s.Require().NoError(err)
+	device.Version = 1
+	device.Status = models.DefaultStatus
+	s.Equal(device, untimed(got))
+}
+
+func TestSQLRepoSuite(t *testing.T) {
+	suite.Run(t, new(SQLRepoSuite))
+}
+
+func TestOpenSQLRepoUnknownDriver(t *testing.T) {
+	_, err := repositories.OpenSQLRepo("unknown", "")
+	require.Error(t, err)
+}
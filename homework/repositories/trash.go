@@ -0,0 +1,197 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"homework/models"
+	"sort"
+	"time"
+)
+
+func sortDeleted(deleted []models.DeletedDevice) {
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].SerialNum < deleted[j].SerialNum
+	})
+}
+
+func (ds *RepoDevice) ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	var deleted []models.DeletedDevice
+	for _, d := range ds.trash {
+		d.Device = detach(d.Device)
+		deleted = append(deleted, d)
+	}
+	sortDeleted(deleted)
+	return deleted, nil
+}
+
+func (ds *RepoDevice) RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Device{}, err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.restore(serialNumber, ds.now().UTC())
+}
+
+// restore moves the device back from the trash, one version further and as
+// updated at the given time. Callers hold the lock.
+func (ds *RepoDevice) restore(serialNumber string, at time.Time) (models.Device, error) {
+	deleted, err := ds.checkRestore(serialNumber)
+	if err != nil {
+		return models.Device{}, err
+	}
+	device := deleted.Device
+	device.Version++
+	device.UpdatedAt = at
+	ds.put(device)
+	delete(ds.trash, serialNumber)
+	return detach(device), nil
+}
+
+// checkRestore reports why the device cannot be restored. Callers hold the
+// lock.
+func (ds *RepoDevice) checkRestore(serialNumber string) (models.DeletedDevice, error) {
+	deleted, ok := ds.trash[serialNumber]
+	if !ok {
+		return deleted, fmt.Errorf("%q is not in the trash :%w", serialNumber, models.ErrNotFound)
+	}
+	return deleted, ds.checkCreate(deleted.Device)
+}
+
+func (ds *RepoDevice) PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.purge(before), nil
+}
+
+// expired returns the devices deleted before the given time. Callers hold the
+// lock.
+func (ds *RepoDevice) expired(before time.Time) []models.DeletedDevice {
+	var expired []models.DeletedDevice
+	for _, d := range ds.trash {
+		if d.DeletedAt.Before(before) {
+			expired = append(expired, d)
+		}
+	}
+	sortDeleted(expired)
+	return expired
+}
+
+// purge drops the devices deleted before the given time. Callers hold the
+// lock.
+func (ds *RepoDevice) purge(before time.Time) []models.DeletedDevice {
+	purged := ds.expired(before)
+	for _, d := range purged {
+		delete(ds.trash, d.SerialNum)
+	}
+	if len(purged) > 0 {
+		ds.changes++
+	}
+	return purged
+}
+
+func scanDeleted(row interface{ Scan(...any) error }) (models.DeletedDevice, error) {
+	var d deviceRow
+	var deletedAt int64
+	if err := row.Scan(append(d.dest(), &deletedAt)...); err != nil {
+		return models.DeletedDevice{}, err
+	}
+	device, err := d.device()
+	return models.DeletedDevice{Device: device, DeletedAt: time.Unix(0, deletedAt).UTC()}, err
+}
+
+// trashDevice copies the device into deleted_devices, replacing an earlier
+// device of the same serial number, if it has the given version.
+func trashDevice(tx *sql.Tx, serialNumber string, version int64, at time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO deleted_devices (`+deviceColumns+`, deleted_at)
+		SELECT `+deviceColumns+`, ? FROM devices WHERE serial_num = ? AND (? = 0 OR version = ?)
+		ON CONFLICT (serial_num) DO UPDATE SET model = excluded.model, ip = excluded.ip, ipv6 = excluded.ipv6,
+			segment = excluded.segment, vendor = excluded.vendor, firmware = excluded.firmware, macs = excluded.macs,
+			hostname = excluded.hostname, site = excluded.site, rack = excluded.rack, status = excluded.status,
+			status_reason = excluded.status_reason, labels = excluded.labels, version = excluded.version, created_at = excluded.created_at,
+			updated_at = excluded.updated_at, deleted_at = excluded.deleted_at`,
+		at.UnixNano(), serialNumber, version, version,
+	)
+	return err
+}
+
+func (r *SQLRepo) ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error) {
+	return queryDeleted(r.db.QueryContext(ctx, `SELECT `+deviceColumns+`, deleted_at FROM deleted_devices ORDER BY serial_num`))
+}
+
+func queryDeleted(rows *sql.Rows, err error) ([]models.DeletedDevice, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted []models.DeletedDevice
+	for rows.Next() {
+		d, err := scanDeleted(rows)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, d)
+	}
+	return deleted, rows.Err()
+}
+
+func (r *SQLRepo) RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	var device models.Device
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		deleted, err := scanDeleted(tx.QueryRow(
+			`SELECT `+deviceColumns+`, deleted_at FROM deleted_devices WHERE serial_num = ?`, serialNumber,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%q is not in the trash :%w", serialNumber, models.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		device = deleted.Device
+		device.Version++
+		device.UpdatedAt = time.Now().UTC()
+		if err := createDevice(tx, device); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE devices SET version = ? WHERE serial_num = ?`, device.Version, serialNumber); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM deleted_devices WHERE serial_num = ?`, serialNumber)
+		return err
+	})
+	if err != nil {
+		return models.Device{}, err
+	}
+	return device, nil
+}
+
+func (r *SQLRepo) PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error) {
+	var purged []models.DeletedDevice
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		purged, err = queryDeleted(tx.Query(
+			`SELECT `+deviceColumns+`, deleted_at FROM deleted_devices WHERE deleted_at < ? ORDER BY serial_num`, before.UnixNano(),
+		))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM deleted_devices WHERE deleted_at < ?`, before.UnixNano())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
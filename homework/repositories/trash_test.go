@@ -0,0 +1,162 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func deletedSerials(t *testing.T, repo repositories.Repository) []string {
+	deleted, err := repo.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	var result []string
+	for _, d := range deleted {
+		result = append(result, d.SerialNum)
+	}
+	return result
+}
+
+func TestTrash(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now().Add(-time.Second)
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+			_, err := repo.UpdateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1"})
+			require.NoError(t, err)
+			require.NoError(t, repo.DeleteDevice(context.Background(), "1"))
+
+			_, err = repo.GetDevice(context.Background(), "1")
+			assert.ErrorIs(t, err, models.ErrNotFound)
+			assert.Empty(t, listAll(t, repo))
+			deleted, err := repo.ListDeletedDevices(context.Background())
+			require.NoError(t, err)
+			require.Len(t, deleted, 1)
+			assert.Equal(t, models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1", Status: models.DefaultStatus, Version: 2}, untimed(deleted[0].Device))
+			assert.True(t, deleted[0].DeletedAt.After(start))
+
+			restored, err := repo.RestoreDevice(context.Background(), "1")
+			require.NoError(t, err)
+			assert.Equal(t, models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1", Status: models.DefaultStatus, Version: 3}, untimed(restored))
+			// A restored device keeps its creation time and is updated now.
+			assert.Equal(t, deleted[0].CreatedAt, restored.CreatedAt)
+			assert.True(t, restored.UpdatedAt.After(deleted[0].UpdatedAt))
+			assert.False(t, restored.UpdatedAt.Before(deleted[0].DeletedAt))
+			got, err := repo.GetDevice(context.Background(), "1")
+			require.NoError(t, err)
+			assert.Equal(t, restored, got)
+			_, err = repo.GetDeviceByIP(context.Background(), "10.0.0.1", "")
+			assert.NoError(t, err)
+			assert.Empty(t, deletedSerials(t, repo))
+
+			_, err = repo.RestoreDevice(context.Background(), "1")
+			assert.ErrorIs(t, err, models.ErrNotFound)
+		})
+	}
+}
+
+func TestRestoreConflicts(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}))
+			require.NoError(t, repo.DeleteDevice(context.Background(), "1"))
+			require.NoError(t, repo.DeleteDevice(context.Background(), "2"))
+
+			// The addresses of a deleted device are free again.
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.3"}))
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.2"}))
+
+			_, err := repo.RestoreDevice(context.Background(), "1")
+			assert.ErrorIs(t, err, models.ErrAlredyExist)
+			_, err = repo.RestoreDevice(context.Background(), "2")
+			assert.ErrorIs(t, err, models.ErrIPConflict)
+			assert.Equal(t, []string{"1", "2"}, deletedSerials(t, repo))
+		})
+	}
+}
+
+func TestPurgeDeletedDevices(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}))
+			_, err := repo.DeleteDevices(context.Background(), []string{"1", "2"}, models.BatchAtomic)
+			require.NoError(t, err)
+
+			purged, err := repo.PurgeDeletedDevices(context.Background(), time.Now().Add(-time.Hour))
+			require.NoError(t, err)
+			assert.Empty(t, purged)
+
+			purged, err = repo.PurgeDeletedDevices(context.Background(), time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			require.Len(t, purged, 2)
+			assert.Equal(t, "1", purged[0].SerialNum)
+			assert.Empty(t, deletedSerials(t, repo))
+			_, err = repo.RestoreDevice(context.Background(), "1")
+			assert.ErrorIs(t, err, models.ErrNotFound)
+		})
+	}
+}
+
+func TestAtomicDeleteRollsBackTheTrash(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+			errs, err := repo.DeleteDevices(context.Background(), []string{"1", "nope"}, models.BatchAtomic)
+			require.NoError(t, err)
+			assert.ErrorIs(t, errs[1], models.ErrNotFound)
+
+			_, err = repo.GetDevice(context.Background(), "1")
+			assert.NoError(t, err)
+			assert.Empty(t, deletedSerials(t, repo))
+		})
+	}
+}
+
+func TestTrashSurvivesRestarts(t *testing.T) {
+	files := newWALFiles(t)
+	repo := files.open(t, 0)
+	writeSomeDevices(t, repo)
+	want, err := repo.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, want)
+
+	reopened := files.open(t, 0)
+	got, err := reopened.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Compaction keeps the trash in the snapshot.
+	require.NoError(t, reopened.Compact())
+	got, err = files.open(t, 0).ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	mem := repositories.NewRepoDevice()
+	_, err = mem.ReadSnapshot(files.snapshot)
+	require.NoError(t, err)
+	got, err = mem.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	path := filepath.Join(t.TempDir(), "devices.db")
+	sqlRepo, err := repositories.OpenSQLRepo("sqlite3", path)
+	require.NoError(t, err)
+	require.NoError(t, sqlRepo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	require.NoError(t, sqlRepo.DeleteDevice(context.Background(), "1"))
+	want, err = sqlRepo.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, sqlRepo.Close())
+	sqlRepo, err = repositories.OpenSQLRepo("sqlite3", path)
+	require.NoError(t, err)
+	defer sqlRepo.Close()
+	got, err = sqlRepo.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,73 @@
+package repositories_test
+
+import (
+	"context"
+	"homework/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAndSwapDevice(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		device := models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}
+		require.NoError(t, repo.CreateDevice(context.Background(), device))
+
+		device.Model = "m2"
+		stored, err := repo.CompareAndSwapDevice(context.Background(), device, 1)
+		require.NoError(t, err, name)
+		require.Equal(t, int64(2), stored.Version, name)
+		require.Equal(t, models.DefaultStatus, stored.Status, name)
+
+		device.Model = "m3"
+		_, err = repo.CompareAndSwapDevice(context.Background(), device, 1)
+		require.ErrorIs(t, err, models.ErrVersionMismatch, name)
+
+		got, err := repo.GetDevice(context.Background(), "1")
+		require.NoError(t, err)
+		require.Equal(t, "m2", got.Model, name)
+		require.Equal(t, int64(2), got.Version, name)
+
+		stored, err = repo.UpdateDevice(context.Background(), device)
+		require.NoError(t, err, name)
+		got, err = repo.GetDevice(context.Background(), "1")
+		require.NoError(t, err)
+		require.Equal(t, int64(3), got.Version, name)
+		require.Equal(t, got, stored, "%s: the update returns the stored device", name)
+
+		_, err = repo.CompareAndSwapDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}, 1)
+		require.ErrorIs(t, err, models.ErrNotFound, name)
+	}
+}
+
+func TestCompareAndDeleteDevice(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+
+		require.ErrorIs(t, repo.CompareAndDeleteDevice(context.Background(), "1", 2), models.ErrVersionMismatch, name)
+		require.NoError(t, repo.CompareAndDeleteDevice(context.Background(), "1", 1), name)
+		require.ErrorIs(t, repo.CompareAndDeleteDevice(context.Background(), "1", 1), models.ErrNotFound, name)
+	}
+}
+
+func TestDeviceTimes(t *testing.T) {
+	for name, repo := range newBackends(t) {
+		start := time.Now()
+		// The times of the client are ignored.
+		device := models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", CreatedAt: start.Add(-time.Hour)}
+		require.NoError(t, repo.CreateDevice(context.Background(), device))
+		created, err := repo.GetDevice(context.Background(), "1")
+		require.NoError(t, err)
+		require.False(t, created.CreatedAt.Before(start), name)
+		require.Equal(t, created.CreatedAt, created.UpdatedAt, name)
+
+		device.Model = "m2"
+		_, err = repo.UpdateDevice(context.Background(), device)
+		require.NoError(t, err, name)
+		updated, err := repo.GetDevice(context.Background(), "1")
+		require.NoError(t, err)
+		require.Equal(t, created.CreatedAt, updated.CreatedAt, name)
+		require.False(t, updated.UpdatedAt.Before(created.UpdatedAt), name)
+	}
+}
@@ -0,0 +1,457 @@
+package repositories
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"homework/models"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	walHeaderSize = 8
+	// walMaxRecord bounds the length read from a header, anything longer is
+	// a corrupt header.
+	walMaxRecord = 64 << 20
+)
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walFormat is bumped whenever a write could fail where an older one of the
+// same record succeeded. Format 1 made the MAC addresses unique.
+const walFormat = 1
+
+const (
+	opCreate        = "create"
+	opUpdate        = "update"
+	opDelete        = "delete"
+	opCreateDevices = "create_devices"
+	opUpdateDevices = "update_devices"
+	opDeleteDevices = "delete_devices"
+	opRestore       = "restore"
+	opPurge         = "purge"
+	opTransition    = "transition"
+)
+
+// walRecord is one write of the log. Applying the records in order to the
+// snapshot they follow rebuilds the repository, including the writes that
+// failed, which fail again the same way.
+type walRecord struct {
+	Format     int              `json:"format,omitempty"`
+	Seq        uint64           `json:"seq"`
+	Op         string           `json:"op"`
+	Devices    []models.Device  `json:"devices,omitempty"`
+	SerialNums []string         `json:"serial_nums,omitempty"`
+	Version    int64            `json:"version,omitempty"`
+	Mode       models.BatchMode `json:"mode,omitempty"`
+	// Status and Reason are the ones of a transition.
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// At is the time of a write or the purge limit, in Unix nanoseconds.
+	// Writes logged before the devices had times have none: their devices
+	// get the zero time, and count as deleted long ago.
+	At int64 `json:"at,omitempty"`
+}
+
+func (rec walRecord) time() time.Time {
+	if rec.At == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, rec.At).UTC()
+}
+
+// check tells why a single write would fail, so it is not logged at all.
+// Callers hold the lock.
+func (rec walRecord) check(ds *RepoDevice) error {
+	switch rec.Op {
+	case opCreate:
+		return ds.checkCreate(rec.Devices[0])
+	case opUpdate:
+		_, err := ds.checkUpdate(rec.Devices[0], rec.Version)
+		return err
+	case opDelete, opTransition:
+		_, err := ds.current(rec.SerialNums[0], rec.Version)
+		return err
+	case opRestore:
+		_, err := ds.checkRestore(rec.SerialNums[0])
+		return err
+	}
+	return nil
+}
+
+// diverged reports the item whose replay failed although its write did not:
+// a single write is checked before it is logged, and a batch item could not
+// fail on a MAC address before format 1.
+func (rec walRecord) diverged(errs []error) error {
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		switch rec.Op {
+		case opCreateDevices, opUpdateDevices, opDeleteDevices:
+			if rec.Format >= 1 || !errors.Is(err, models.ErrMACConflict) {
+				continue
+			}
+		}
+		if i < len(rec.Devices) {
+			return fmt.Errorf("device %q: %w", rec.Devices[i].SerialNum, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// apply performs the write on ds and returns one error per item. Callers hold
+// the lock.
+func (rec walRecord) apply(ds *RepoDevice) []error {
+	ds.seq = rec.Seq
+	switch rec.Op {
+	case opCreate:
+		return []error{ds.create(rec.Devices[0], rec.time())}
+	case opUpdate:
+		return []error{ds.update(rec.Devices[0], rec.Version, rec.time())}
+	case opDelete:
+		return []error{ds.delete(rec.SerialNums[0], rec.Version, rec.time())}
+	case opCreateDevices:
+		return ds.createDevices(rec.Devices, rec.Mode, rec.time())
+	case opUpdateDevices:
+		return ds.updateDevices(rec.Devices, rec.Mode, rec.time())
+	case opDeleteDevices:
+		return ds.deleteDevices(rec.SerialNums, rec.Mode, rec.time())
+	case opRestore:
+		_, err := ds.restore(rec.SerialNums[0], rec.time())
+		return []error{err}
+	case opPurge:
+		ds.purge(rec.time())
+		return []error{nil}
+	case opTransition:
+		_, err := ds.transition(rec.SerialNums[0], rec.Status, rec.Reason, rec.Version, rec.time())
+		return []error{err}
+	default:
+		return []error{fmt.Errorf("unknown log operation %q", rec.Op)}
+	}
+}
+
+// WALRepo is a RepoDevice whose writes are appended to a write-ahead log and
+// synced before they are applied. On open it loads the last snapshot and
+// replays the log written since; the log is compacted into a new snapshot
+// once it grows past compactSize.
+//
+// Every record is framed by its length and its CRC-32C, a torn or corrupt
+// record ends the log: it is reported and cut off with everything after it.
+type WALRepo struct {
+	*RepoDevice
+
+	file         *os.File
+	size         int64
+	snapshotPath string
+	compactSize  int64
+	// broken is set when the log could not be restored to a clean end after a
+	// failed write, every later write fails with it.
+	broken error
+}
+
+// OpenWALRepo opens the log at walPath, creating it if needed, on top of the
+// snapshot at snapshotPath. A compactSize of 0 disables automatic compaction.
+func OpenWALRepo(walPath, snapshotPath string, compactSize int64) (*WALRepo, error) {
+	repo := NewRepoDevice()
+	restored, _, err := loadSnapshot(snapshotPath)
+	switch {
+	case err == nil:
+		repo = restored
+	case !errors.Is(err, models.ErrNotFound):
+		return nil, err
+	}
+
+	file, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w := &WALRepo{RepoDevice: repo, file: file, snapshotPath: snapshotPath, compactSize: compactSize}
+	if err := w.replay(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// replay applies the records following the snapshot and truncates the log
+// after the last intact one. It fails on a record which no longer applies, as
+// the ones written while devices could share a MAC address.
+func (w *WALRepo) replay() error {
+	r := bufio.NewReader(w.file)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			log.Printf("write-ahead log: dropping the tail after byte %d: %v", offset, err)
+			break
+		}
+		offset += n
+		if rec.Seq > w.seq {
+			if err := rec.diverged(rec.apply(w.RepoDevice)); err != nil {
+				return fmt.Errorf("write-ahead log record %d: %w", rec.Seq, err)
+			}
+		}
+	}
+
+	if err := w.file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = offset
+	return w.file.Sync()
+}
+
+// readRecord returns io.EOF at a clean end of the log, any other error means
+// the record is torn or corrupt.
+func readRecord(r io.Reader) (walRecord, int64, error) {
+	var rec walRecord
+	var header [walHeaderSize]byte
+	n, err := io.ReadFull(r, header[:])
+	if n == 0 && errors.Is(err, io.EOF) {
+		return rec, 0, io.EOF
+	}
+	if err != nil {
+		return rec, 0, fmt.Errorf("torn header: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > walMaxRecord {
+		return rec, 0, fmt.Errorf("record of %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, fmt.Errorf("torn record: %w", err)
+	}
+	if crc32.Checksum(payload, walTable) != binary.BigEndian.Uint32(header[4:]) {
+		return rec, 0, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, 0, err
+	}
+	return rec, int64(walHeaderSize + length), nil
+}
+
+func encodeRecord(rec walRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(payload, walTable))
+	copy(b[walHeaderSize:], payload)
+	return b, nil
+}
+
+// write logs rec and applies it, all under the lock of the repository so the
+// log holds the writes in the order they were applied.
+func (w *WALRepo) write(ctx context.Context, rec walRecord) ([]error, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeLocked(ctx, rec)
+}
+
+// writeLocked is write for the callers which hold the lock to read the
+// outcome of rec. A record is not logged once ctx is done, after that it is
+// applied whatever happens to ctx.
+func (w *WALRepo) writeLocked(ctx context.Context, rec walRecord) ([]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if w.broken != nil {
+		return nil, w.broken
+	}
+	if err := rec.check(w.RepoDevice); err != nil {
+		return []error{err}, nil
+	}
+	rec.Format, rec.Seq = walFormat, w.seq+1
+	if err := w.append(rec); err != nil {
+		return nil, err
+	}
+	errs := rec.apply(w.RepoDevice)
+
+	if w.compactSize > 0 && w.size >= w.compactSize {
+		if err := w.compact(); err != nil {
+			log.Printf("write-ahead log: compaction failed: %v", err)
+		}
+	}
+	return errs, nil
+}
+
+func (w *WALRepo) append(rec walRecord) error {
+	b, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(b)
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		// Leave no partial record behind for the next one to follow.
+		if terr := w.file.Truncate(w.size); terr != nil {
+			w.broken = fmt.Errorf("write-ahead log is unusable: %v", terr)
+		} else if _, serr := w.file.Seek(w.size, io.SeekStart); serr != nil {
+			w.broken = fmt.Errorf("write-ahead log is unusable: %v", serr)
+		}
+		return err
+	}
+	w.size += int64(len(b))
+	return nil
+}
+
+// compact writes a snapshot of every device and empties the log. A crash in
+// between leaves records the snapshot already holds, replay skips them by
+// their sequence number. Callers hold the lock.
+func (w *WALRepo) compact() error {
+	if err := writeFileAtomic(w.snapshotPath, w.capture()); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		w.broken = fmt.Errorf("write-ahead log is unusable: %v", err)
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		w.broken = fmt.Errorf("write-ahead log is unusable: %v", err)
+		return err
+	}
+	w.size = 0
+	return w.file.Sync()
+}
+
+// Compact writes a snapshot and empties the log.
+func (w *WALRepo) Compact() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.compact()
+}
+
+// Restore replaces every device by the snapshot at path and compacts the log,
+// so the restore survives a restart.
+func (w *WALRepo) Restore(path string) (models.SnapshotInfo, error) {
+	restored, info, err := loadSnapshot(path)
+	if err != nil {
+		return info, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.install(restored)
+	return info, w.compact()
+}
+
+// ReadSnapshot is Restore, restoring past the log would be undone by the next
+// replay.
+func (w *WALRepo) ReadSnapshot(path string) (models.SnapshotInfo, error) {
+	return w.Restore(path)
+}
+
+// Snapshotter saves and restores named snapshots next to the one the log
+// follows. It needs no Start, the log compaction replaces the periodic
+// snapshots.
+func (w *WALRepo) Snapshotter() *Snapshotter {
+	s := NewSnapshotter(w.RepoDevice, w.snapshotPath, 0)
+	s.restore = w.Restore
+	return s
+}
+
+// Close compacts the log and closes it.
+func (w *WALRepo) Close() error {
+	err := w.Compact()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func single(errs []error, err error) error {
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
+
+func (w *WALRepo) CreateDevice(ctx context.Context, device models.Device) error {
+	return single(w.write(ctx, walRecord{Op: opCreate, Devices: []models.Device{device}, At: w.now().UnixNano()}))
+}
+
+func (w *WALRepo) UpdateDevice(ctx context.Context, device models.Device) (models.Device, error) {
+	return w.CompareAndSwapDevice(ctx, device, models.AnyVersion)
+}
+
+func (w *WALRepo) CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rec := walRecord{Op: opUpdate, Devices: []models.Device{device}, Version: version, At: w.now().UnixNano()}
+	if err := single(w.writeLocked(ctx, rec)); err != nil {
+		return models.Device{}, err
+	}
+	return detach(w.devices[device.SerialNum]), nil
+}
+
+func (w *WALRepo) DeleteDevice(ctx context.Context, serialNumber string) error {
+	return w.CompareAndDeleteDevice(ctx, serialNumber, models.AnyVersion)
+}
+
+func (w *WALRepo) CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error {
+	return single(w.write(ctx, walRecord{Op: opDelete, SerialNums: []string{serialNumber}, Version: version, At: w.now().UnixNano()}))
+}
+
+func (w *WALRepo) CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	return w.write(ctx, walRecord{Op: opCreateDevices, Devices: devices, Mode: mode, At: w.now().UnixNano()})
+}
+
+func (w *WALRepo) UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	return w.write(ctx, walRecord{Op: opUpdateDevices, Devices: devices, Mode: mode, At: w.now().UnixNano()})
+}
+
+func (w *WALRepo) DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error) {
+	return w.write(ctx, walRecord{Op: opDeleteDevices, SerialNums: serialNumbers, Mode: mode, At: w.now().UnixNano()})
+}
+
+func (w *WALRepo) RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := single(w.writeLocked(ctx, walRecord{Op: opRestore, SerialNums: []string{serialNumber}, At: w.now().UnixNano()})); err != nil {
+		return models.Device{}, err
+	}
+	return detach(w.devices[serialNumber]), nil
+}
+
+func (w *WALRepo) PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	purged := w.expired(before)
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	if _, err := w.writeLocked(ctx, walRecord{Op: opPurge, At: before.UnixNano()}); err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (w *WALRepo) TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rec := walRecord{Op: opTransition, SerialNums: []string{serialNumber}, Status: status, Reason: reason, Version: version, At: w.now().UnixNano()}
+	if err := single(w.writeLocked(ctx, rec)); err != nil {
+		return models.Device{}, err
+	}
+	return detach(w.devices[serialNumber]), nil
+}
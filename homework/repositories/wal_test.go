@@ -0,0 +1,219 @@
+package repositories_test
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"homework/models"
+	"homework/repositories"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type walFiles struct {
+	wal, snapshot string
+}
+
+func newWALFiles(t *testing.T) walFiles {
+	dir := t.TempDir()
+	return walFiles{wal: filepath.Join(dir, "devices.wal"), snapshot: filepath.Join(dir, "devices.json")}
+}
+
+// open opens the log without closing it at the end of the test, so a test can
+// reopen the files as a restarted process after a crash would.
+func (f walFiles) open(t *testing.T, compactSize int64) *repositories.WALRepo {
+	repo, err := repositories.OpenWALRepo(f.wal, f.snapshot, compactSize)
+	require.NoError(t, err)
+	return repo
+}
+
+func listAll(t *testing.T, repo repositories.Repository) []models.Device {
+	result, err := repo.ListDevices(context.Background(), models.ListQuery{})
+	require.NoError(t, err)
+	return result.Devices
+}
+
+func writeSomeDevices(t *testing.T, repo repositories.Repository) {
+	for _, d := range listFixture {
+		require.NoError(t, repo.CreateDevice(context.Background(), d))
+	}
+	_, err := repo.UpdateDevice(context.Background(), models.Device{SerialNum: "a-1", Model: "m9", IP: "10.0.0.99"})
+	require.NoError(t, err)
+	require.NoError(t, repo.CompareAndDeleteDevice(context.Background(), "b-2", 1))
+	_, err = repo.TransitionDevice(context.Background(), "a-1", models.StatusMaintenance, "fan swap", models.AnyVersion)
+	require.NoError(t, err)
+	_, err = repo.CreateDevices(context.Background(), []models.Device{
+		{SerialNum: "c-1", Model: "m3", IP: "10.0.3.1"},
+		{SerialNum: "c-2", Model: "m3", IP: "10.0.0.99"},
+	}, models.BatchBestEffort)
+	require.NoError(t, err)
+	_, err = repo.DeleteDevices(context.Background(), []string{"a-2", "nope"}, models.BatchAtomic)
+	require.NoError(t, err)
+}
+
+func TestWALReplay(t *testing.T) {
+	files := newWALFiles(t)
+	repo := files.open(t, 0)
+	writeSomeDevices(t, repo)
+	want := listAll(t, repo)
+
+	reopened := files.open(t, 0)
+	assert.Equal(t, want, listAll(t, reopened))
+	_, err := reopened.GetDeviceByIP(context.Background(), "10.0.0.99", "")
+	assert.NoError(t, err)
+}
+
+func TestWALSkipsFailedWrites(t *testing.T) {
+	files := newWALFiles(t)
+	repo := files.open(t, 0)
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	info, err := os.Stat(files.wal)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.2"}), models.ErrAlredyExist)
+	_, err = repo.CompareAndSwapDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.2"}, 7)
+	assert.ErrorIs(t, err, models.ErrVersionMismatch)
+	assert.ErrorIs(t, repo.DeleteDevice(context.Background(), "2"), models.ErrNotFound)
+
+	after, err := os.Stat(files.wal)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), after.Size())
+}
+
+func TestWALTornTail(t *testing.T) {
+	tails := map[string]func(b []byte) []byte{
+		"partial header": func(b []byte) []byte { return append(b, 0, 0, 1) },
+		"partial record": func(b []byte) []byte { return append(b, 0, 0, 0, 40, 1, 2, 3, 4, '{') },
+		"bad checksum": func(b []byte) []byte {
+			b[len(b)-2] ^= 0xff
+			return b
+		},
+	}
+	for name, tear := range tails {
+		t.Run(name, func(t *testing.T) {
+			files := newWALFiles(t)
+			repo := files.open(t, 0)
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+			require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}))
+
+			b, err := os.ReadFile(files.wal)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(files.wal, tear(b), 0o600))
+
+			reopened := files.open(t, 0)
+			want := []string{"1", "2"}
+			if name == "bad checksum" {
+				want = []string{"1"}
+			}
+			assert.Equal(t, want, serials(listAll(t, reopened)))
+
+			// The log goes on after the last intact record.
+			require.NoError(t, reopened.CreateDevice(context.Background(), models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3"}))
+			assert.Equal(t, append(want, "3"), serials(listAll(t, files.open(t, 0))))
+		})
+	}
+}
+
+func TestWALCompaction(t *testing.T) {
+	files := newWALFiles(t)
+	repo := files.open(t, 1)
+	writeSomeDevices(t, repo)
+	want := listAll(t, repo)
+
+	info, err := os.Stat(files.wal)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+	assert.FileExists(t, files.snapshot)
+
+	assert.Equal(t, want, listAll(t, files.open(t, 0)))
+}
+
+func TestWALSkipsRecordsInSnapshot(t *testing.T) {
+	files := newWALFiles(t)
+	repo := files.open(t, 0)
+	writeSomeDevices(t, repo)
+	want := listAll(t, repo)
+
+	// A crash between writing the snapshot and emptying the log.
+	_, err := repo.Snapshotter().Snapshot("")
+	require.NoError(t, err)
+
+	assert.Equal(t, want, listAll(t, files.open(t, 0)))
+}
+
+func TestWALRestore(t *testing.T) {
+	files := newWALFiles(t)
+	repo := files.open(t, 0)
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	snapshots := repo.Snapshotter()
+	_, err := snapshots.Snapshot("one.json")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}))
+	_, err = snapshots.Restore("one.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, serials(listAll(t, repo)))
+
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "3", Model: "m", IP: "10.0.0.3"}))
+	assert.Equal(t, []string{"1", "3"}, serials(listAll(t, files.open(t, 0))))
+	require.NoError(t, repo.Close())
+}
+
+// writeLog writes records as a log of an older release, framed by their
+// length and CRC-32C.
+func writeLog(t *testing.T, path string, records ...string) {
+	var b []byte
+	for _, rec := range records {
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header[:4], uint32(len(rec)))
+		binary.BigEndian.PutUint32(header[4:], crc32.Checksum([]byte(rec), crc32.MakeTable(crc32.Castagnoli)))
+		b = append(append(b, header...), rec...)
+	}
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+}
+
+func TestWALSharedMACs(t *testing.T) {
+	// Devices could share a MAC address when these records were written.
+	logs := map[string][]string{
+		"create": {
+			`{"seq": 1, "op": "create", "devices": [{"serial_num": "1", "model": "m", "ip": "10.0.0.1", "mac": "00:00:5e:00:53:01"}]}`,
+			`{"seq": 2, "op": "create", "devices": [{"serial_num": "2", "model": "m", "ip": "10.0.0.2", "mac": "00:00:5e:00:53:01"}]}`,
+		},
+		"batch": {
+			`{"seq": 1, "op": "create", "devices": [{"serial_num": "1", "model": "m", "ip": "10.0.0.1", "mac": "00:00:5e:00:53:01"}]}`,
+			`{"seq": 2, "op": "create_devices", "mode": "best_effort", "devices": [
+				{"serial_num": "3", "model": "m", "ip": "10.0.0.3"},
+				{"serial_num": "2", "model": "m", "ip": "10.0.0.2", "mac": "00:00:5e:00:53:01"}
+			]}`,
+		},
+	}
+	for name, records := range logs {
+		files := newWALFiles(t)
+		writeLog(t, files.wal, records...)
+
+		_, err := repositories.OpenWALRepo(files.wal, files.snapshot, 0)
+		require.ErrorIs(t, err, models.ErrMACConflict, name)
+		assert.ErrorContains(t, err, `record 2: device "2": "00:00:5e:00:53:01" is used by "1"`, name)
+	}
+
+	// A batch item failing on its MAC address since they are unique fails
+	// again on replay, as it did when written.
+	files := newWALFiles(t)
+	writeLog(t, files.wal,
+		`{"seq": 1, "op": "create", "devices": [{"serial_num": "1", "model": "m", "ip": "10.0.0.1", "macs": ["00:00:5e:00:53:01"]}]}`,
+		`{"format": 1, "seq": 2, "op": "create_devices", "mode": "best_effort", "devices": [
+			{"serial_num": "3", "model": "m", "ip": "10.0.0.3"},
+			{"serial_num": "2", "model": "m", "ip": "10.0.0.2", "macs": ["00:00:5e:00:53:01"]}
+		]}`,
+	)
+	repo := files.open(t, 0)
+	assert.Equal(t, []string{"1", "3"}, serials(listAll(t, repo)))
+	errs, err := repo.CreateDevices(context.Background(), []models.Device{{SerialNum: "4", Model: "m", IP: "10.0.0.4", MACs: []string{"00:00:5e:00:53:01"}}}, models.BatchBestEffort)
+	require.NoError(t, err)
+	require.ErrorIs(t, errs[0], models.ErrMACConflict)
+	assert.Equal(t, []string{"1", "3"}, serials(listAll(t, files.open(t, 0))))
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"homework/models"
+)
+
+// AuditLog is an append-only trail of device changes, see
+// repositories.MemoryAuditLog and repositories.FileAuditLog.
+type AuditLog interface {
+	// Append sets the sequence number and the time of the entries.
+	Append(entries ...models.AuditEntry) error
+	History(serialNumber string) ([]models.AuditEntry, error)
+	Since(q models.AuditQuery) ([]models.AuditEntry, error)
+}
+
+// Audit decorates next so that every device it creates, updates, deletes,
+// restores, purges or transitions is appended to the log with the principal's
+// name and the request ID of the context.
+func Audit(next Service, log AuditLog) Service {
+	return &tracked{next: next, record: func(ctx context.Context, changes []change) error {
+		actor, requestID := principalOf(ctx).Name, RequestIDFrom(ctx)
+		entries := make([]models.AuditEntry, len(changes))
+		for i, c := range changes {
+			entries[i] = models.AuditEntry{
+				Actor:     actor,
+				Action:    c.Action,
+				SerialNum: c.SerialNum,
+				Before:    c.Before,
+				After:     c.After,
+				RequestID: requestID,
+				Reason:    c.Reason,
+			}
+		}
+		if err := log.Append(entries...); err != nil {
+			return fmt.Errorf("the change was made but not audited: %w", err)
+		}
+		return nil
+	}}
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the ID of the request ctx belongs to, empty if it has
+// none.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
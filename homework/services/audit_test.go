@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"homework/models"
+	"homework/repositories"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	log := repositories.NewMemoryAuditLog()
+	usecase := Audit(NewService(repositories.NewRepoDevice()), log)
+	ctx := WithRequestID(WithPrincipal(context.Background(), models.Principal{Name: "ci", Role: models.RoleAdmin}), "req-1")
+
+	require.NoError(t, usecase.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	_, err := usecase.UpdateDevice(ctx, models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1"})
+	require.NoError(t, err)
+	_, err = usecase.PatchDevice(ctx, "1", models.MergePatchType, []byte(`{"model":"m3"}`), 2)
+	require.NoError(t, err)
+	require.NoError(t, usecase.DeleteDevice(ctx, "1"))
+
+	// Failed changes are not recorded.
+	assert.Error(t, usecase.DeleteDevice(ctx, "1"))
+	assert.Error(t, usecase.CreateDevice(ctx, models.Device{SerialNum: "2"}))
+
+	history, err := log.History("1")
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	for _, entry := range history {
+		assert.Equal(t, "ci", entry.Actor)
+		assert.Equal(t, "req-1", entry.RequestID)
+	}
+	assert.Equal(t, models.AuditCreate, history[0].Action)
+	assert.Nil(t, history[0].Before)
+	assert.Equal(t, int64(1), history[0].After.Version)
+	assert.Equal(t, models.AuditUpdate, history[1].Action)
+	assert.Equal(t, "m", history[1].Before.Model)
+	assert.Equal(t, "m2", history[1].After.Model)
+	assert.Equal(t, "m3", history[2].After.Model)
+	assert.Equal(t, models.AuditDelete, history[3].Action)
+	assert.Equal(t, "m3", history[3].Before.Model)
+	assert.Nil(t, history[3].After)
+
+	_, err = log.History("2")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func TestAuditBatches(t *testing.T) {
+	log := repositories.NewMemoryAuditLog()
+	usecase := Audit(NewService(repositories.NewRepoDevice()), log)
+	ctx := WithPrincipal(context.Background(), models.Principal{Name: "ci", Role: models.RoleAdmin})
+
+	errs, err := usecase.CreateDevices(ctx, []models.Device{
+		{SerialNum: "1", Model: "m", IP: "10.0.0.1"},
+		{SerialNum: "2", Model: "m", IP: "10.0.0.1"},
+	}, models.BatchBestEffort)
+	require.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], models.ErrIPConflict)
+
+	results, err := usecase.ImportDevices(ctx, []models.Device{
+		{SerialNum: "1", Model: "m2", IP: "10.0.0.1"},
+		{SerialNum: "3", Model: "m", IP: "10.0.0.3"},
+	}, models.ImportUpsert, false)
+	require.NoError(t, err)
+	assert.NoError(t, errors.Join(results[0].Err, results[1].Err))
+
+	_, err = usecase.DeleteDevices(ctx, []string{"1", "3"}, models.BatchAtomic)
+	require.NoError(t, err)
+
+	entries, err := log.Since(models.AuditQuery{})
+	require.NoError(t, err)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action+" "+entry.SerialNum)
+	}
+	assert.Equal(t, []string{"create 1", "update 1", "create 3", "delete 1", "delete 3"}, actions)
+	assert.Equal(t, "m", entries[1].Before.Model)
+	assert.Equal(t, "m2", entries[1].After.Model)
+
+	// A dry run changes nothing.
+	_, err = usecase.ImportDevices(ctx, []models.Device{{SerialNum: "4", Model: "m", IP: "10.0.0.4"}}, models.ImportCreate, true)
+	require.NoError(t, err)
+	entries, err = log.Since(models.AuditQuery{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"homework/models"
+	"strings"
+	"time"
+)
+
+// tokenHeader is the only JOSE header a bearer token may have, tokens are
+// JWTs signed with HMAC-SHA256.
+const tokenHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// tokenLeeway absorbs the clock skew between the issuer and the server.
+const tokenLeeway = 30 * time.Second
+
+// APIKey is a static credential, Name and Role make its principal.
+type APIKey struct {
+	Name string
+	Key  string
+	Role models.Role
+}
+
+// Authenticator checks static API keys and locally signed bearer tokens.
+type Authenticator struct {
+	// apiKeys maps the SHA-256 of every key to its principal, so the keys do
+	// not stay in memory and a lookup leaks nothing about them.
+	apiKeys     map[[sha256.Size]byte]models.Principal
+	tokenSecret []byte
+	now         func() time.Time
+}
+
+// NewAuthenticator accepts keys and the tokens signed with tokenSecret. An
+// empty secret disables tokens.
+func NewAuthenticator(keys []APIKey, tokenSecret string) *Authenticator {
+	a := &Authenticator{
+		apiKeys:     make(map[[sha256.Size]byte]models.Principal, len(keys)),
+		tokenSecret: []byte(tokenSecret),
+		now:         time.Now,
+	}
+	for _, key := range keys {
+		a.apiKeys[sha256.Sum256([]byte(key.Key))] = models.Principal{Name: key.Name, Method: models.AuthAPIKey, Role: key.Role}
+	}
+	return a
+}
+
+// Enabled reports whether any credential can be accepted at all.
+func (a *Authenticator) Enabled() bool {
+	return len(a.apiKeys) > 0 || len(a.tokenSecret) > 0
+}
+
+func (a *Authenticator) AuthenticateAPIKey(key string) (models.Principal, error) {
+	principal, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok || key == "" {
+		return models.Principal{}, fmt.Errorf("unknown API key :%w", models.ErrUnauthorized)
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) AuthenticateToken(token string) (models.Principal, error) {
+	claims, err := a.verifyToken(token)
+	if err != nil {
+		return models.Principal{}, fmt.Errorf("%s :%w", err, models.ErrUnauthorized)
+	}
+	return models.Principal{Name: claims.Subject, Method: models.AuthToken, Role: claims.Role}, nil
+}
+
+func (a *Authenticator) verifyToken(token string) (models.TokenClaims, error) {
+	var claims models.TokenClaims
+	if len(a.tokenSecret) == 0 {
+		return claims, fmt.Errorf("bearer tokens are not accepted")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, a.sign(parts[0]+"."+parts[1])) {
+		return claims, fmt.Errorf("invalid token signature")
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, fmt.Errorf("malformed token")
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return claims, fmt.Errorf("unsupported token algorithm")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, fmt.Errorf("malformed token")
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("malformed token claims")
+	}
+
+	now := a.now()
+	switch {
+	case claims.Subject == "":
+		return claims, fmt.Errorf("token has no subject")
+	case claims.ExpiresAt == 0:
+		return claims, fmt.Errorf("token has no expiry")
+	case now.Add(-tokenLeeway).Unix() >= claims.ExpiresAt:
+		return claims, fmt.Errorf("token expired")
+	case claims.NotBefore != 0 && now.Add(tokenLeeway).Unix() < claims.NotBefore:
+		return claims, fmt.Errorf("token not valid yet")
+	}
+	// Tokens issued before roles existed only read.
+	if claims.Role == "" {
+		claims.Role = models.RoleViewer
+	}
+	if !claims.Role.Valid() {
+		return claims, fmt.Errorf("unknown role %q", claims.Role)
+	}
+	return claims, nil
+}
+
+// SignToken issues a bearer token for claims.
+func (a *Authenticator) SignToken(claims models.TokenClaims) (string, error) {
+	if len(a.tokenSecret) == 0 {
+		return "", fmt.Errorf("no token secret configured")
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(tokenHeader)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(a.sign(signed)), nil
+}
+
+func (a *Authenticator) sign(s string) []byte {
+	mac := hmac.New(sha256.New, a.tokenSecret)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p models.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the caller of the request ctx belongs to, ok is false
+// for anonymous requests.
+func PrincipalFrom(ctx context.Context) (models.Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(models.Principal)
+	return p, ok
+}
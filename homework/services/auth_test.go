@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/base64"
+	"homework/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestAuthenticator(now time.Time) *Authenticator {
+	a := NewAuthenticator([]APIKey{{Name: "ci", Key: "ci-key-0123456789", Role: models.RoleOperator}}, testSecret)
+	a.now = func() time.Time { return now }
+	return a
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a := newTestAuthenticator(time.Now())
+
+	p, err := a.AuthenticateAPIKey("ci-key-0123456789")
+	require.NoError(t, err)
+	assert.Equal(t, models.Principal{Name: "ci", Method: models.AuthAPIKey, Role: models.RoleOperator}, p)
+
+	_, err = a.AuthenticateAPIKey("ci-key-0123456780")
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+	_, err = a.AuthenticateAPIKey("")
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	a := newTestAuthenticator(now)
+
+	token, err := a.SignToken(models.TokenClaims{Subject: "alice", ExpiresAt: now.Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	p, err := a.AuthenticateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, models.Principal{Name: "alice", Method: models.AuthToken, Role: models.RoleViewer}, p)
+
+	sign := func(claims models.TokenClaims) string {
+		token, err := a.SignToken(claims)
+		require.NoError(t, err)
+		return token
+	}
+	other, err := NewAuthenticator(nil, "another-secret-0123456789").SignToken(models.TokenClaims{Subject: "alice", ExpiresAt: now.Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	invalid := map[string]string{
+		"expired":        sign(models.TokenClaims{Subject: "alice", ExpiresAt: now.Add(-time.Minute).Unix()}),
+		"no expiry":      sign(models.TokenClaims{Subject: "alice"}),
+		"no subject":     sign(models.TokenClaims{ExpiresAt: now.Add(time.Hour).Unix()}),
+		"not yet valid":  sign(models.TokenClaims{Subject: "alice", NotBefore: now.Add(time.Hour).Unix(), ExpiresAt: now.Add(2 * time.Hour).Unix()}),
+		"other secret":   other,
+		"alg none":       none,
+		"tampered":       parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"root","exp":9999999999}`)) + "." + parts[2],
+		"malformed":      "not-a-token",
+		"empty":          "",
+		"two parts only": parts[0] + "." + parts[1],
+		"unknown role":   sign(models.TokenClaims{Subject: "alice", Role: "root", ExpiresAt: now.Add(time.Hour).Unix()}),
+	}
+	for name, token := range invalid {
+		_, err := a.AuthenticateToken(token)
+		assert.ErrorIs(t, err, models.ErrUnauthorized, name)
+	}
+
+	// A few seconds of clock skew are tolerated.
+	p, err = a.AuthenticateToken(sign(models.TokenClaims{Subject: "bob", ExpiresAt: now.Add(-10 * time.Second).Unix()}))
+	require.NoError(t, err)
+	assert.Equal(t, "bob", p.Name)
+}
+
+func TestAuthenticatorEnabled(t *testing.T) {
+	assert.False(t, NewAuthenticator(nil, "").Enabled())
+	assert.True(t, NewAuthenticator(nil, testSecret).Enabled())
+
+	_, err := NewAuthenticator([]APIKey{{Name: "ci", Key: "ci-key-0123456789", Role: models.RoleOperator}}, "").AuthenticateToken("a.b.c")
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+}
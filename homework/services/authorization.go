@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"homework/models"
+	"time"
+)
+
+// MethodRoles is the role each Service method needs.
+var MethodRoles = map[string]models.Role{
+	"GetDevice":              models.RoleViewer,
+	"ListDevices":            models.RoleViewer,
+	"GetDeviceByIP":          models.RoleViewer,
+	"GetDeviceByMAC":         models.RoleViewer,
+	"CreateDevice":           models.RoleOperator,
+	"UpdateDevice":           models.RoleOperator,
+	"CompareAndSwapDevice":   models.RoleOperator,
+	"PatchDevice":            models.RoleOperator,
+	"DeleteDevice":           models.RoleOperator,
+	"CompareAndDeleteDevice": models.RoleOperator,
+	"CreateDevices":          models.RoleOperator,
+	"UpdateDevices":          models.RoleOperator,
+	"DeleteDevices":          models.RoleOperator,
+	"ImportDevices":          models.RoleOperator,
+	"ListDeletedDevices":     models.RoleViewer,
+	"RestoreDevice":          models.RoleOperator,
+	"PurgeDeletedDevices":    models.RoleAdmin,
+	"TransitionDevice":       models.RoleOperator,
+}
+
+// Anonymous is the principal of a context without one.
+var Anonymous = models.Principal{Name: "anonymous", Role: models.RoleViewer}
+
+// principalOf returns the principal of ctx, Anonymous if it has none.
+func principalOf(ctx context.Context) models.Principal {
+	if principal, ok := PrincipalFrom(ctx); ok {
+		return principal
+	}
+	return Anonymous
+}
+
+// authorized lets the principal of a call use the methods of next its role
+// allows.
+type authorized struct {
+	next Service
+}
+
+// Authorize decorates next so that the methods the role of the principal of
+// the context does not allow fail with models.ErrForbidden.
+func Authorize(next Service) Service {
+	return &authorized{next: next}
+}
+
+// Allowed reports whether principal may call the Service method.
+func Allowed(principal models.Principal, method string) error {
+	required, ok := MethodRoles[method]
+	if !ok {
+		required = models.RoleAdmin
+	}
+	if !principal.Role.Allows(required) {
+		return fmt.Errorf("%q cannot %s without the %s role :%w", principal.Name, method, required, models.ErrForbidden)
+	}
+	return nil
+}
+
+func (a *authorized) GetDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "GetDevice"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.GetDevice(ctx, serialNumber)
+}
+
+func (a *authorized) ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error) {
+	if err := Allowed(principalOf(ctx), "ListDevices"); err != nil {
+		return models.ListResult{}, err
+	}
+	return a.next.ListDevices(ctx, q)
+}
+
+func (a *authorized) GetDeviceByIP(ctx context.Context, ip, segment string) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "GetDeviceByIP"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.GetDeviceByIP(ctx, ip, segment)
+}
+
+func (a *authorized) GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "GetDeviceByMAC"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.GetDeviceByMAC(ctx, mac)
+}
+
+func (a *authorized) CreateDevice(ctx context.Context, device models.Device) error {
+	if err := Allowed(principalOf(ctx), "CreateDevice"); err != nil {
+		return err
+	}
+	return a.next.CreateDevice(ctx, device)
+}
+
+func (a *authorized) UpdateDevice(ctx context.Context, device models.Device) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "UpdateDevice"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.UpdateDevice(ctx, device)
+}
+
+func (a *authorized) CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "CompareAndSwapDevice"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.CompareAndSwapDevice(ctx, device, version)
+}
+
+func (a *authorized) PatchDevice(ctx context.Context, serialNumber, patchType string, patch []byte, version int64) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "PatchDevice"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.PatchDevice(ctx, serialNumber, patchType, patch, version)
+}
+
+func (a *authorized) DeleteDevice(ctx context.Context, serialNumber string) error {
+	if err := Allowed(principalOf(ctx), "DeleteDevice"); err != nil {
+		return err
+	}
+	return a.next.DeleteDevice(ctx, serialNumber)
+}
+
+func (a *authorized) CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error {
+	if err := Allowed(principalOf(ctx), "CompareAndDeleteDevice"); err != nil {
+		return err
+	}
+	return a.next.CompareAndDeleteDevice(ctx, serialNumber, version)
+}
+
+func (a *authorized) CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	if err := Allowed(principalOf(ctx), "CreateDevices"); err != nil {
+		return nil, err
+	}
+	return a.next.CreateDevices(ctx, devices, mode)
+}
+
+func (a *authorized) UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	if err := Allowed(principalOf(ctx), "UpdateDevices"); err != nil {
+		return nil, err
+	}
+	return a.next.UpdateDevices(ctx, devices, mode)
+}
+
+func (a *authorized) DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error) {
+	if err := Allowed(principalOf(ctx), "DeleteDevices"); err != nil {
+		return nil, err
+	}
+	return a.next.DeleteDevices(ctx, serialNumbers, mode)
+}
+
+func (a *authorized) ImportDevices(ctx context.Context, devices []models.Device, mode models.ImportMode, dryRun bool) ([]models.ImportResult, error) {
+	if err := Allowed(principalOf(ctx), "ImportDevices"); err != nil {
+		return nil, err
+	}
+	return a.next.ImportDevices(ctx, devices, mode, dryRun)
+}
+
+func (a *authorized) ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error) {
+	if err := Allowed(principalOf(ctx), "ListDeletedDevices"); err != nil {
+		return nil, err
+	}
+	return a.next.ListDeletedDevices(ctx)
+}
+
+func (a *authorized) RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "RestoreDevice"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.RestoreDevice(ctx, serialNumber)
+}
+
+func (a *authorized) PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error) {
+	if err := Allowed(principalOf(ctx), "PurgeDeletedDevices"); err != nil {
+		return nil, err
+	}
+	return a.next.PurgeDeletedDevices(ctx, before)
+}
+
+func (a *authorized) TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error) {
+	if err := Allowed(principalOf(ctx), "TransitionDevice"); err != nil {
+		return models.Device{}, err
+	}
+	return a.next.TransitionDevice(ctx, serialNumber, status, reason, version)
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"homework/controllers/mocks"
+	"homework/models"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMethodRolesCoverService(t *testing.T) {
+	service := reflect.TypeOf((*Service)(nil)).Elem()
+	for i := 0; i < service.NumMethod(); i++ {
+		name := service.Method(i).Name
+		assert.Contains(t, MethodRoles, name)
+	}
+	assert.Len(t, MethodRoles, service.NumMethod())
+}
+
+func TestAuthorize(t *testing.T) {
+	next := new(mocks.Service)
+	device := models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}
+	next.On("GetDevice", mock.Anything, "1").Return(device, nil)
+	next.On("ListDevices", mock.Anything, models.ListQuery{}).Return(models.ListResult{}, nil)
+	next.On("CreateDevice", mock.Anything, device).Return(nil)
+	next.On("DeleteDevice", mock.Anything, "1").Return(nil)
+
+	// The forbidden calls never reach next, which has no expectation for them.
+	viewer := Authorize(next)
+	ctx := WithPrincipal(context.Background(), models.Principal{Name: "noc", Role: models.RoleViewer})
+	_, err := viewer.GetDevice(ctx, "1")
+	assert.NoError(t, err)
+	_, err = viewer.ListDevices(ctx, models.ListQuery{})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, viewer.CreateDevice(ctx, device), models.ErrForbidden)
+	assert.ErrorIs(t, viewer.DeleteDevice(ctx, "1"), models.ErrForbidden)
+	_, err = viewer.PatchDevice(ctx, "1", models.MergePatchType, []byte(`{}`), models.AnyVersion)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = viewer.DeleteDevices(ctx, []string{"1"}, models.BatchAtomic)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = viewer.ImportDevices(ctx, nil, models.ImportCreate, true)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	next.AssertNotCalled(t, "CreateDevice", mock.Anything, mock.Anything)
+
+	for _, role := range []models.Role{models.RoleOperator, models.RoleAdmin} {
+		ctx := WithPrincipal(context.Background(), models.Principal{Name: "ci", Role: role})
+		assert.NoError(t, viewer.CreateDevice(ctx, device), role)
+		assert.NoError(t, viewer.DeleteDevice(ctx, "1"), role)
+	}
+	next.AssertNumberOfCalls(t, "CreateDevice", 2)
+
+	// A principal without a role may not do anything, a context without
+	// principal is a viewer.
+	_, err = viewer.GetDevice(WithPrincipal(context.Background(), models.Principal{Name: "nobody"}), "1")
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = viewer.GetDevice(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, viewer.DeleteDevice(context.Background(), "1"), models.ErrForbidden)
+}
+
+func TestAllowedUnknownMethodNeedsAdmin(t *testing.T) {
+	assert.ErrorIs(t, Allowed(models.Principal{Role: models.RoleOperator}, "Purge"), models.ErrForbidden)
+	assert.NoError(t, Allowed(models.Principal{Role: models.RoleAdmin}, "Purge"))
+}
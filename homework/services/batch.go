@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"homework/models"
+)
+
+// MaxBatchSize bounds the number of items of a single batch request.
+const MaxBatchSize = 1000
+
+func (u *Usercase) CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	if err := validateBatch(len(devices), mode); err != nil {
+		return nil, err
+	}
+	errs, valid, index := validateItems(devices)
+	return runBatch(errs, index, mode, func() ([]error, error) {
+		return u.devices.CreateDevices(ctx, valid, mode)
+	})
+}
+
+func (u *Usercase) UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	if err := validateBatch(len(devices), mode); err != nil {
+		return nil, err
+	}
+	errs, valid, index := validateItems(devices)
+	valid, index, err := u.keepStatuses(ctx, errs, valid, index)
+	if err != nil {
+		return nil, err
+	}
+	return runBatch(errs, index, mode, func() ([]error, error) {
+		return u.devices.UpdateDevices(ctx, valid, mode)
+	})
+}
+
+func (u *Usercase) DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error) {
+	if err := validateBatch(len(serialNumbers), mode); err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(serialNumbers))
+	var valid []string
+	var index []int
+	for i, serialNum := range serialNumbers {
+		if serialNum == "" {
+			var verr ValidationError
+			verr.Add("serial_num", CodeRequired, "Invalid serial number")
+			errs[i] = verr.Err()
+			continue
+		}
+		valid = append(valid, serialNum)
+		index = append(index, i)
+	}
+	return runBatch(errs, index, mode, func() ([]error, error) {
+		return u.devices.DeleteDevices(ctx, valid, mode)
+	})
+}
+
+func validateBatch(n int, mode models.BatchMode) error {
+	var verr ValidationError
+	if mode != models.BatchAtomic && mode != models.BatchBestEffort {
+		verr.Add("mode", CodeInvalidMode, fmt.Sprintf("mode must be %q or %q", models.BatchAtomic, models.BatchBestEffort))
+	}
+	if n == 0 || n > MaxBatchSize {
+		verr.Add("items", CodeOutOfRange, fmt.Sprintf("a batch holds between 1 and %d items", MaxBatchSize))
+	}
+	return verr.Err()
+}
+
+// validateItems validates and normalizes every device, returning the errors of
+// the invalid ones and the valid ones along with their position in devices.
+func validateItems(devices []models.Device) ([]error, []models.Device, []int) {
+	errs := make([]error, len(devices))
+	var valid []models.Device
+	var index []int
+	for i, d := range devices {
+		if err := ValidateDevice(d); err != nil {
+			errs[i] = err
+			continue
+		}
+		valid = append(valid, NormalizeDevice(d))
+		index = append(index, i)
+	}
+	return errs, valid, index
+}
+
+// keepStatuses fails the updates changing the status of their device, see
+// checkStatusKept, and returns the others along with their position.
+func (u *Usercase) keepStatuses(ctx context.Context, errs []error, valid []models.Device, index []int) ([]models.Device, []int, error) {
+	var kept []models.Device
+	var keptIndex []int
+	for j, d := range valid {
+		err := u.checkStatusKept(ctx, d, models.AnyVersion)
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			errs[index[j]] = err
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		kept = append(kept, d)
+		keptIndex = append(keptIndex, index[j])
+	}
+	return kept, keptIndex, nil
+}
+
+// runBatch hands the valid items to the repository and merges its results
+// back into errs. An atomic batch with invalid items never reaches it.
+func runBatch(errs []error, index []int, mode models.BatchMode, run func() ([]error, error)) ([]error, error) {
+	if mode == models.BatchAtomic && models.BatchFailed(errs) {
+		models.AbortBatch(errs)
+		return errs, nil
+	}
+	if len(index) == 0 {
+		return errs, nil
+	}
+	results, err := run()
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range index {
+		errs[i] = results[j]
+	}
+	return errs, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	repoMock "homework/services/mocks"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDevicesValidatesEachItem(t *testing.T) {
+	repo := new(repoMock.Repository)
+	valid := models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}
+	repo.On("CreateDevices", mock.Anything, []models.Device{valid}, models.BatchBestEffort).Return([]error{nil}, nil)
+
+	errs, err := NewService(repo).CreateDevices(context.Background(), []models.Device{{SerialNum: "2"}, valid}, models.BatchBestEffort)
+	require.NoError(t, err)
+
+	var verr *ValidationError
+	assert.ErrorAs(t, errs[0], &verr)
+	assert.NoError(t, errs[1])
+	repo.AssertExpectations(t)
+}
+
+func TestCreateDevicesAtomicWithInvalidItem(t *testing.T) {
+	repo := new(repoMock.Repository)
+
+	errs, err := NewService(repo).CreateDevices(context.Background(), []models.Device{
+		{SerialNum: "1", Model: "m", IP: "10.0.0.1"},
+		{SerialNum: "batch", Model: "m", IP: "10.0.0.2"},
+	}, models.BatchAtomic)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, errs[0], models.ErrBatchAborted)
+	var verr *ValidationError
+	require.ErrorAs(t, errs[1], &verr)
+	assert.Equal(t, CodeReserved, verr.Violations[0].Code)
+	repo.AssertNotCalled(t, "CreateDevices", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBatchValidation(t *testing.T) {
+	usecase := NewService(new(repoMock.Repository))
+	var verr *ValidationError
+
+	_, err := usecase.DeleteDevices(context.Background(), []string{"1"}, "sometimes")
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, "mode", verr.Violations[0].Field)
+
+	_, err = usecase.UpdateDevices(context.Background(), nil, models.BatchAtomic)
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, CodeOutOfRange, verr.Violations[0].Code)
+
+	_, err = usecase.CreateDevices(context.Background(), make([]models.Device, MaxBatchSize+1), models.BatchAtomic)
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestDeleteDevicesMergesResults(t *testing.T) {
+	repo := new(repoMock.Repository)
+	repo.On("DeleteDevices", mock.Anything, []string{"1", "3"}, models.BatchBestEffort).Return([]error{models.ErrNotFound, nil}, nil)
+
+	errs, err := NewService(repo).DeleteDevices(context.Background(), []string{"1", "", "3"}, models.BatchBestEffort)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, errs[0], models.ErrNotFound)
+	var verr *ValidationError
+	assert.ErrorAs(t, errs[1], &verr)
+	assert.NoError(t, errs[2])
+}
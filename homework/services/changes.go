@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	"time"
+)
+
+// change is a successful write of a device, Action is one of the
+// models.Audit actions. Reason is the one given for a transition.
+type change struct {
+	Action    string
+	SerialNum string
+	Before    *models.Device
+	After     *models.Device
+	Reason    string
+}
+
+// tracked hands the changes made through next to record. The device before
+// and after a change is read around it, a concurrent write of the same device
+// can slip in between. A change that cannot be recorded is not undone, it
+// fails with the error of record.
+type tracked struct {
+	next   Service
+	record func(ctx context.Context, changes []change) error
+}
+
+// current returns the stored device, nil if there is none. It reads past the
+// end of ctx, so a change made just before is still seen.
+func (t *tracked) current(ctx context.Context, serialNumber string) *models.Device {
+	device, err := t.next.GetDevice(context.WithoutCancel(ctx), serialNumber)
+	if err != nil {
+		return nil
+	}
+	return &device
+}
+
+func newChange(action, serialNumber string, before, after *models.Device) change {
+	return change{Action: action, SerialNum: serialNumber, Before: before, After: after}
+}
+
+func (t *tracked) commit(ctx context.Context, changes ...change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return t.record(ctx, changes)
+}
+
+func (t *tracked) GetDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	return t.next.GetDevice(ctx, serialNumber)
+}
+
+func (t *tracked) ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error) {
+	return t.next.ListDevices(ctx, q)
+}
+
+func (t *tracked) GetDeviceByIP(ctx context.Context, ip, segment string) (models.Device, error) {
+	return t.next.GetDeviceByIP(ctx, ip, segment)
+}
+
+func (t *tracked) GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error) {
+	return t.next.GetDeviceByMAC(ctx, mac)
+}
+
+func (t *tracked) CreateDevice(ctx context.Context, device models.Device) error {
+	if err := t.next.CreateDevice(ctx, device); err != nil {
+		return err
+	}
+	return t.commit(ctx, newChange(models.AuditCreate, device.SerialNum, nil, t.current(ctx, device.SerialNum)))
+}
+
+func (t *tracked) UpdateDevice(ctx context.Context, device models.Device) (models.Device, error) {
+	before := t.current(ctx, device.SerialNum)
+	after, err := t.next.UpdateDevice(ctx, device)
+	if err != nil {
+		return after, err
+	}
+	return after, t.commit(ctx, newChange(models.AuditUpdate, device.SerialNum, before, &after))
+}
+
+func (t *tracked) CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error) {
+	before := t.current(ctx, device.SerialNum)
+	after, err := t.next.CompareAndSwapDevice(ctx, device, version)
+	if err != nil {
+		return after, err
+	}
+	return after, t.commit(ctx, newChange(models.AuditUpdate, device.SerialNum, before, &after))
+}
+
+func (t *tracked) PatchDevice(ctx context.Context, serialNumber, patchType string, patch []byte, version int64) (models.Device, error) {
+	before := t.current(ctx, serialNumber)
+	after, err := t.next.PatchDevice(ctx, serialNumber, patchType, patch, version)
+	if err != nil {
+		return after, err
+	}
+	return after, t.commit(ctx, newChange(models.AuditUpdate, serialNumber, before, &after))
+}
+
+func (t *tracked) DeleteDevice(ctx context.Context, serialNumber string) error {
+	before := t.current(ctx, serialNumber)
+	if err := t.next.DeleteDevice(ctx, serialNumber); err != nil {
+		return err
+	}
+	return t.commit(ctx, newChange(models.AuditDelete, serialNumber, before, nil))
+}
+
+func (t *tracked) CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error {
+	before := t.current(ctx, serialNumber)
+	if err := t.next.CompareAndDeleteDevice(ctx, serialNumber, version); err != nil {
+		return err
+	}
+	return t.commit(ctx, newChange(models.AuditDelete, serialNumber, before, nil))
+}
+
+func (t *tracked) CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	errs, err := t.next.CreateDevices(ctx, devices, mode)
+	if err != nil {
+		return errs, err
+	}
+	var changes []change
+	for i, device := range devices {
+		if errs[i] == nil {
+			changes = append(changes, newChange(models.AuditCreate, device.SerialNum, nil, t.current(ctx, device.SerialNum)))
+		}
+	}
+	return errs, t.commit(ctx, changes...)
+}
+
+func (t *tracked) UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	before := make([]*models.Device, len(devices))
+	for i, device := range devices {
+		before[i] = t.current(ctx, device.SerialNum)
+	}
+	errs, err := t.next.UpdateDevices(ctx, devices, mode)
+	if err != nil {
+		return errs, err
+	}
+	var changes []change
+	for i, device := range devices {
+		if errs[i] == nil {
+			changes = append(changes, newChange(models.AuditUpdate, device.SerialNum, before[i], t.current(ctx, device.SerialNum)))
+		}
+	}
+	return errs, t.commit(ctx, changes...)
+}
+
+func (t *tracked) DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error) {
+	before := make([]*models.Device, len(serialNumbers))
+	for i, serialNum := range serialNumbers {
+		before[i] = t.current(ctx, serialNum)
+	}
+	errs, err := t.next.DeleteDevices(ctx, serialNumbers, mode)
+	if err != nil {
+		return errs, err
+	}
+	var changes []change
+	for i, serialNum := range serialNumbers {
+		if errs[i] == nil {
+			changes = append(changes, newChange(models.AuditDelete, serialNum, before[i], nil))
+		}
+	}
+	return errs, t.commit(ctx, changes...)
+}
+
+func (t *tracked) ImportDevices(ctx context.Context, devices []models.Device, mode models.ImportMode, dryRun bool) ([]models.ImportResult, error) {
+	if dryRun {
+		return t.next.ImportDevices(ctx, devices, mode, dryRun)
+	}
+	before := make([]*models.Device, len(devices))
+	for i, device := range devices {
+		before[i] = t.current(ctx, device.SerialNum)
+	}
+	results, err := t.next.ImportDevices(ctx, devices, mode, dryRun)
+	if err != nil {
+		return results, err
+	}
+	var changes []change
+	// imported holds the devices as left by the earlier rows, for a device
+	// imported twice.
+	imported := make(map[string]*models.Device)
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		serialNum := devices[i].SerialNum
+		after := t.current(ctx, serialNum)
+		if previous, ok := imported[serialNum]; ok {
+			before[i] = previous
+		}
+		imported[serialNum] = after
+		switch result.Action {
+		case models.ImportCreated:
+			changes = append(changes, newChange(models.AuditCreate, serialNum, nil, after))
+		case models.ImportUpdated:
+			changes = append(changes, newChange(models.AuditUpdate, serialNum, before[i], after))
+		}
+	}
+	return results, t.commit(ctx, changes...)
+}
+
+func (t *tracked) ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error) {
+	return t.next.ListDeletedDevices(ctx)
+}
+
+func (t *tracked) RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	device, err := t.next.RestoreDevice(ctx, serialNumber)
+	if err != nil {
+		return device, err
+	}
+	return device, t.commit(ctx, newChange(models.AuditRestore, serialNumber, nil, &device))
+}
+
+func (t *tracked) PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error) {
+	purged, err := t.next.PurgeDeletedDevices(ctx, before)
+	if err != nil {
+		return purged, err
+	}
+	changes := make([]change, len(purged))
+	for i := range purged {
+		changes[i] = newChange(models.AuditPurge, purged[i].SerialNum, &purged[i].Device, nil)
+	}
+	return purged, t.commit(ctx, changes...)
+}
+
+func (t *tracked) TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error) {
+	before := t.current(ctx, serialNumber)
+	after, err := t.next.TransitionDevice(ctx, serialNumber, status, reason, version)
+	if err != nil {
+		return after, err
+	}
+	c := newChange(models.AuditTransition, serialNumber, before, &after)
+	c.Reason = reason
+	return after, t.commit(ctx, c)
+}
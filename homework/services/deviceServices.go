@@ -1,68 +1,544 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"homework/models"
+	"homework/repositories"
 	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 )
 
+// Service is the use cases on the devices. The context carries the principal
+// and the request ID of the call, and its end cancels it.
 type Service interface {
-	GetDevice(string) (models.Device, error)
-	CreateDevice(models.Device) error
-	DeleteDevice(string) error
-	UpdateDevice(models.Device) error
+	GetDevice(ctx context.Context, serialNumber string) (models.Device, error)
+	CreateDevice(ctx context.Context, device models.Device) error
+	DeleteDevice(ctx context.Context, serialNumber string) error
+	// UpdateDevice and CompareAndSwapDevice replace the device and return
+	// the stored result.
+	UpdateDevice(ctx context.Context, device models.Device) (models.Device, error)
+	ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error)
+	GetDeviceByIP(ctx context.Context, ip, segment string) (models.Device, error)
+	GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error)
+	CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error)
+	CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error
+	// PatchDevice applies a patch of the given media type to the stored
+	// device and returns the result.
+	PatchDevice(ctx context.Context, serialNumber, patchType string, patch []byte, version int64) (models.Device, error)
+	// The batch operations validate every item on its own, an invalid item
+	// fails like one rejected by the repository.
+	CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error)
+	UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error)
+	DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error)
+	ImportDevices(ctx context.Context, devices []models.Device, mode models.ImportMode, dryRun bool) ([]models.ImportResult, error)
+	ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error)
+	RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error)
+	PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error)
+	// TransitionDevice moves the device to another lifecycle status for the
+	// given reason.
+	TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error)
 }
 
+// ReservedSerialNums cannot be used by devices because they name routes under
+// /devices.
+var ReservedSerialNums = []string{"batch", "by-ip", "by-mac", "export", "import", "trash"}
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 1000
+)
+
+const (
+	// MaxFieldLength bounds the free-form text fields of a device.
+	MaxFieldLength = 255
+	MaxLabels      = 64
+	MaxMACs        = 16
+	// MaxLabelLength bounds both the names of the label keys, past their
+	// prefix, and the values of the labels.
+	MaxLabelLength = 63
+)
+
 type Usercase struct {
-	devices Service
+	devices repositories.Repository
 }
 
-
-func NewService(devices Service) *Usercase {
+func NewService(devices repositories.Repository) *Usercase {
 	return &Usercase{
 		devices: devices,
 	}
 }
 
+func (u *Usercase) CreateDevice(ctx context.Context, device models.Device) error {
+	if err := ValidateDevice(device); err != nil {
+		return err
+	}
+	return u.devices.CreateDevice(ctx, NormalizeDevice(device))
+}
+
+func (u *Usercase) GetDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	return u.devices.GetDevice(ctx, serialNumber)
+}
+
+func (u *Usercase) DeleteDevice(ctx context.Context, serialNumber string) error {
+	return u.devices.DeleteDevice(ctx, serialNumber)
+}
+
+func (u *Usercase) UpdateDevice(ctx context.Context, device models.Device) (models.Device, error) {
+	if err := ValidateDevice(device); err != nil {
+		return models.Device{}, err
+	}
+	device = NormalizeDevice(device)
+	if err := u.checkStatusKept(ctx, device, models.AnyVersion); err != nil {
+		return models.Device{}, err
+	}
+	return u.devices.UpdateDevice(ctx, device)
+}
+
+func (u *Usercase) CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error) {
+	if err := ValidateDevice(device); err != nil {
+		return models.Device{}, err
+	}
+	device = NormalizeDevice(device)
+	if err := u.checkStatusKept(ctx, device, version); err != nil {
+		return models.Device{}, err
+	}
+	return u.devices.CompareAndSwapDevice(ctx, device, version)
+}
+
+// checkStatusKept fails like a patch when a replacement of the stored device
+// changes its status or the reason of it, which only a transition does.
+// Leaving them out keeps them. A device that is missing, or not at version,
+// is left to the repository to report.
+func (u *Usercase) checkStatusKept(ctx context.Context, device models.Device, version int64) error {
+	if device.Status == "" && device.StatusReason == "" {
+		return nil
+	}
+	current, err := u.devices.GetDevice(ctx, device.SerialNum)
+	if errors.Is(err, models.ErrNotFound) || err == nil && version != models.AnyVersion && current.Version != version {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return statusKept(device, current)
+}
+
+func statusKept(device, current models.Device) error {
+	if device.Status != "" && device.Status != current.Status ||
+		device.StatusReason != "" && device.StatusReason != current.StatusReason {
+		var verr ValidationError
+		verr.Add("status", CodeImmutable, "status only changes through a transition")
+		return &verr
+	}
+	return nil
+}
+
+func (u *Usercase) CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error {
+	return u.devices.CompareAndDeleteDevice(ctx, serialNumber, version)
+}
 
-func (u *Usercase) CreateDevice(device models.Device) (error) {
-	return u.devices.CreateDevice(device)
+// retryWrite runs write on the stored device. A write at a version fails
+// with models.ErrVersionMismatch once the device is at another one, an
+// unconditional write is run again on the device another writer left until
+// it goes through or ctx ends.
+func (u *Usercase) retryWrite(ctx context.Context, serialNumber string, version int64, write func(current models.Device) (models.Device, error)) (models.Device, error) {
+	for {
+		current, err := u.devices.GetDevice(ctx, serialNumber)
+		if err != nil {
+			return models.Device{}, err
+		}
+		if version != models.AnyVersion && current.Version != version {
+			return models.Device{}, fmt.Errorf("%q is at version %d, not %d :%w", serialNumber, current.Version, version, models.ErrVersionMismatch)
+		}
+		device, err := write(current)
+		if errors.Is(err, models.ErrVersionMismatch) && version == models.AnyVersion {
+			if err := ctx.Err(); err != nil {
+				return models.Device{}, err
+			}
+			continue
+		}
+		return device, err
+	}
 }
 
-func (u *Usercase) GetDevice(serialNumber string) (models.Device, error) {
-	return u.devices.GetDevice(serialNumber)
+func (u *Usercase) PatchDevice(ctx context.Context, serialNumber, patchType string, patch []byte, version int64) (models.Device, error) {
+	var apply func(doc, patch []byte) ([]byte, error)
+	switch patchType {
+	case models.MergePatchType:
+		apply = MergePatch
+	case models.JSONPatchType:
+		apply = JSONPatch
+	default:
+		return models.Device{}, fmt.Errorf("%q :%w", patchType, models.ErrUnsupportedPatch)
+	}
+
+	return u.retryWrite(ctx, serialNumber, version, func(current models.Device) (models.Device, error) {
+		patched, err := patchDevice(current, apply, patch)
+		if err != nil {
+			return models.Device{}, err
+		}
+		if err := ValidateDevice(patched); err != nil {
+			return models.Device{}, err
+		}
+		return u.devices.CompareAndSwapDevice(ctx, NormalizeDevice(patched), current.Version)
+	})
 }
 
-func (u *Usercase) DeleteDevice(serialNumber string) (error) {
-	return u.devices.DeleteDevice(serialNumber)
+func patchDevice(current models.Device, apply func(doc, patch []byte) ([]byte, error), patch []byte) (models.Device, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return models.Device{}, err
+	}
+	doc, err = apply(doc, patch)
+	if err != nil {
+		return models.Device{}, err
+	}
+
+	var patched models.Device
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return models.Device{}, fmt.Errorf("patched device: %v :%w", err, models.ErrInvalidPatch)
+	}
+	var verr ValidationError
+	if patched.SerialNum != current.SerialNum {
+		verr.Add("serial_num", CodeImmutable, "serial number cannot be changed")
+	}
+	if canonicalStatus(patched.Status) != current.Status || patched.StatusReason != current.StatusReason {
+		verr.Add("status", CodeImmutable, "status only changes through a transition")
+	}
+	if err := verr.Err(); err != nil {
+		return models.Device{}, err
+	}
+	return patched, nil
+}
+
+func (u *Usercase) GetDeviceByIP(ctx context.Context, ip, segment string) (models.Device, error) {
+	if net.ParseIP(ip) == nil {
+		var verr ValidationError
+		verr.Add("ip", CodeInvalidIP, "Invalid IP")
+		return models.Device{}, &verr
+	}
+	return u.devices.GetDeviceByIP(ctx, canonicalIP(ip), segment)
 }
 
-func (u *Usercase) UpdateDevice(device models.Device) (error) {
-	return u.devices.UpdateDevice(device)
+// GetDeviceByMAC finds the device with the MAC address, in any of the forms
+// net.ParseMAC reads.
+func (u *Usercase) GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error) {
+	if _, err := net.ParseMAC(mac); err != nil {
+		var verr ValidationError
+		verr.Add("mac", CodeInvalidMAC, "Invalid MAC address")
+		return models.Device{}, &verr
+	}
+	return u.devices.GetDeviceByMAC(ctx, canonicalMAC(mac))
+}
+
+func (u *Usercase) ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error) {
+	if q.Limit == 0 {
+		q.Limit = DefaultListLimit
+	}
+	if q.SortBy == "" {
+		q.SortBy = models.SortBySerialNum
+	}
+	q.IPPrefix = strings.ToLower(q.IPPrefix)
+	q.Hostname = canonicalHostname(q.Hostname)
+	if err := validateListQuery(q); err != nil {
+		return models.ListResult{}, err
+	}
+	q.MAC = canonicalMAC(q.MAC)
+	q.Status = canonicalStatus(q.Status)
+	return u.devices.ListDevices(ctx, q)
+}
+
+func validateListQuery(q models.ListQuery) error {
+	var verr ValidationError
+
+	if q.Limit < 0 || q.Limit > MaxListLimit {
+		verr.Add("limit", CodeOutOfRange, fmt.Sprintf("limit must be between 1 and %d", MaxListLimit))
+	}
+	if q.Offset < 0 {
+		verr.Add("offset", CodeOutOfRange, "offset must not be negative")
+	}
+	if q.Cursor != "" {
+		if q.Offset > 0 {
+			verr.Add("cursor", CodeConflict, "cursor and offset cannot be combined")
+		}
+		if _, err := models.ParseCursor(q); err != nil {
+			verr.Add("cursor", CodeInvalidCursor, "cursor is malformed or was issued for another sort order")
+		}
+	}
+	if !slices.Contains(models.SortFields, q.SortBy) {
+		verr.Add("sort", CodeUnknownField, fmt.Sprintf("cannot sort by %q", q.SortBy))
+	}
+	if strings.Contains(q.IPPrefix, "/") {
+		if _, _, err := net.ParseCIDR(q.IPPrefix); err != nil {
+			verr.Add("ip", CodeInvalidCIDR, "Invalid CIDR")
+		}
+	}
+	if q.MAC != "" {
+		if _, err := net.ParseMAC(q.MAC); err != nil {
+			verr.Add("mac", CodeInvalidMAC, "Invalid MAC address")
+		}
+	}
+	if q.Status != "" && !checkStatus(q.Status) {
+		verr.Add("status", CodeInvalidStatus, fmt.Sprintf("status must be one of %s", strings.Join(models.Statuses, ", ")))
+	}
+	for _, r := range q.Selector {
+		if message := checkLabelKey(r.Key); message != "" {
+			verr.Add("selector", CodeInvalidLabel, message)
+		}
+		for _, value := range r.Values {
+			if message := checkLabelValue(value); message != "" {
+				verr.Add("selector", CodeInvalidLabel, message)
+			}
+		}
+	}
+
+	return verr.Err()
 }
 
 func ValidateDevice(d models.Device) error {
+	var verr ValidationError
+
 	if d.SerialNum == "" {
-		return errors.New("Invalid serial number")
-		//&models.ResponseError{Err: errors.New("Invalid serial number") }
+		verr.Add("serial_num", CodeRequired, "Invalid serial number")
+	} else if slices.Contains(ReservedSerialNums, d.SerialNum) {
+		verr.Add("serial_num", CodeReserved, fmt.Sprintf("%q is reserved", d.SerialNum))
+	} else if !checkSerialNum(d.SerialNum) {
+		verr.Add("serial_num", CodeInvalidSerialNum, "serial number must be a single URL path segment, without '/', '?', '#', '%', spaces or control characters")
 	}
 
 	if d.Model == "" {
-		return errors.New("Invalid model")
-		//&models.ResponseError{Err: errors.New("Invalid model") }
+		verr.Add("model", CodeRequired, "Invalid model")
 	}
 
-	if d.IP == "" || !checkIPv4(d.IP) {
-		return errors.New("Invalid IP")
-		//&models.ResponseError{Err: errors.New("Invalid IP") }
+	ip := net.ParseIP(d.IP)
+	if d.IP == "" {
+		verr.Add("ip", CodeRequired, "Invalid IP")
+	} else if ip == nil {
+		verr.Add("ip", CodeInvalidIP, "Invalid IP")
 	}
 
-	return nil
+	if d.IPv6 != "" {
+		if !checkIPv6(d.IPv6) {
+			verr.Add("ipv6", CodeInvalidIP, "Invalid IPv6")
+		} else if ip != nil && ip.To4() == nil {
+			verr.Add("ipv6", CodeDualStack, "ipv6 is only allowed when ip is an IPv4 address")
+		}
+	}
+
+	for _, field := range []struct{ name, value string }{
+		{"vendor", d.Vendor}, {"firmware", d.Firmware}, {"site", d.Site}, {"rack", d.Rack}, {"status_reason", d.StatusReason},
+	} {
+		if len(field.value) > MaxFieldLength {
+			verr.Add(field.name, CodeOutOfRange, fmt.Sprintf("%s must be at most %d bytes long", field.name, MaxFieldLength))
+		}
+	}
+	if d.Rack != "" && d.Site == "" {
+		verr.Add("site", CodeRequired, "a rack needs a site")
+	}
+
+	if d.MAC != "" {
+		if _, err := net.ParseMAC(d.MAC); err != nil {
+			verr.Add("mac", CodeInvalidMAC, "Invalid MAC address")
+		}
+	}
+	validateMACs(&verr, d)
+	if d.Hostname != "" && !checkHostname(canonicalHostname(d.Hostname)) {
+		verr.Add("hostname", CodeInvalidHostname, "Invalid hostname")
+	}
+	if d.Status != "" && !checkStatus(d.Status) {
+		verr.Add("status", CodeInvalidStatus, fmt.Sprintf("status must be one of %s", strings.Join(models.Statuses, ", ")))
+	}
+	validateLabels(&verr, d.Labels)
+
+	return verr.Err()
+}
+
+func validateMACs(verr *ValidationError, d models.Device) {
+	if len(d.HardwareAddresses()) > MaxMACs {
+		verr.Add("macs", CodeOutOfRange, fmt.Sprintf("a device has at most %d MAC addresses", MaxMACs))
+	}
+	// The legacy MAC may repeat one of MACs, it is the same address.
+	seen := make(map[string]bool, len(d.MACs))
+	for i, mac := range d.MACs {
+		field := fmt.Sprintf("macs.%d", i)
+		if _, err := net.ParseMAC(mac); err != nil {
+			verr.Add(field, CodeInvalidMAC, "Invalid MAC address")
+			continue
+		}
+		if seen[canonicalMAC(mac)] {
+			verr.Add(field, CodeConflict, fmt.Sprintf("%q is listed twice", mac))
+		}
+		seen[canonicalMAC(mac)] = true
+	}
+}
+
+func validateLabels(verr *ValidationError, labels map[string]string) {
+	if len(labels) > MaxLabels {
+		verr.Add("labels", CodeOutOfRange, fmt.Sprintf("a device has at most %d labels", MaxLabels))
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	// Sorted, so the violations come in a stable order.
+	slices.Sort(keys)
+	for _, key := range keys {
+		field := "labels." + key
+		if key == "" {
+			field = "labels"
+		}
+		message := checkLabelKey(key)
+		if message == "" {
+			message = checkLabelValue(labels[key])
+		}
+		if message != "" {
+			verr.Add(field, CodeInvalidLabel, message)
+		}
+	}
+}
+
+// checkLabelKey tells what is wrong with a label key, empty if nothing. The
+// keys are the ones of Kubernetes: a name, optionally prefixed by a DNS
+// subdomain and a slash.
+func checkLabelKey(key string) string {
+	prefix, name, ok := strings.Cut(key, "/")
+	if !ok {
+		prefix, name = "", key
+	}
+	switch {
+	case key == "":
+		return "label key must not be empty"
+	case ok && !checkHostname(prefix):
+		return "label key prefix must be a DNS subdomain"
+	case len(name) > MaxLabelLength:
+		return fmt.Sprintf("label name must be at most %d bytes long", MaxLabelLength)
+	case !checkLabelName(name):
+		return "label name must be letters, digits, '-', '_' and '.', starting and ending with a letter or a digit"
+	}
+	return ""
+}
+
+// checkLabelValue tells what is wrong with a label value, empty if nothing.
+// A value is empty or has the syntax of a name.
+func checkLabelValue(value string) string {
+	switch {
+	case len(value) > MaxLabelLength:
+		return fmt.Sprintf("label value must be at most %d bytes long", MaxLabelLength)
+	case value != "" && !checkLabelName(value):
+		return "label value must be letters, digits, '-', '_' and '.', starting and ending with a letter or a digit"
+	}
+	return ""
+}
+
+func checkLabelName(name string) bool {
+	if name == "" || !isAlphanumeric(name[0]) || !isAlphanumeric(name[len(name)-1]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; !isAlphanumeric(c) && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
 }
 
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
 
+// checkSerialNum accepts the serial numbers that name their resource as is,
+// /devices/{serial_num} is split at the slashes and never unescaped.
+func checkSerialNum(serialNum string) bool {
+	return serialNum != "." && serialNum != ".." && url.PathEscape(serialNum) == serialNum
+}
 
-func checkIPv4(ip string) bool {
+// checkHostname accepts the names of RFC 1123: dot separated labels of
+// letters, digits and inner hyphens.
+func checkHostname(hostname string) bool {
+	if len(hostname) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func checkIPv6(ip string) bool {
 	parsedIP := net.ParseIP(ip)
-	return parsedIP != nil && parsedIP.To4() != nil
+	return parsedIP != nil && parsedIP.To4() == nil
+}
+
+// NormalizeDevice rewrites the addresses of a valid device to their canonical
+// form, so "::FFFF:10.0.0.1" and "10.0.0.1" are stored alike. The MAC addresses
+// and the hostname get theirs too, the legacy MAC joins the others, a legacy
+// status gets its new name and an empty set of labels is dropped.
+func NormalizeDevice(d models.Device) models.Device {
+	d.IP = canonicalIP(d.IP)
+	d.IPv6 = canonicalIP(d.IPv6)
+	var macs []string
+	for _, mac := range d.HardwareAddresses() {
+		if mac = canonicalMAC(mac); !slices.Contains(macs, mac) {
+			macs = append(macs, mac)
+		}
+	}
+	d.MACs, d.MAC = macs, ""
+	d.Status = canonicalStatus(d.Status)
+	d.Hostname = canonicalHostname(d.Hostname)
+	if len(d.Labels) == 0 {
+		d.Labels = nil
+	}
+	return d
+}
+
+// checkStatus accepts the lifecycle statuses and their legacy names.
+func checkStatus(status string) bool {
+	_, legacy := models.LegacyStatuses[status]
+	return legacy || slices.Contains(models.Statuses, status)
+}
+
+// canonicalStatus renames a legacy status to its successor.
+func canonicalStatus(status string) string {
+	if successor, ok := models.LegacyStatuses[status]; ok {
+		return successor
+	}
+	return status
+}
+
+// canonicalMAC writes a MAC address in lower case with colons, whatever
+// separators it was given with.
+func canonicalMAC(mac string) string {
+	if parsedMAC, err := net.ParseMAC(mac); err == nil {
+		return parsedMAC.String()
+	}
+	return mac
+}
+
+// canonicalHostname lower cases a hostname and drops the dot of a fully
+// qualified one.
+func canonicalHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
+
+func canonicalIP(ip string) string {
+	if parsedIP := net.ParseIP(ip); parsedIP != nil {
+		return parsedIP.String()
+	}
+	return ip
 }
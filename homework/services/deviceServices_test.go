@@ -1,23 +1,27 @@
 package services
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"homework/models"
 	repoMock "homework/services/mocks"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateDevice(t *testing.T) {
 	mockService := new(repoMock.Repository)
 	device := models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1"}
-	mockService.On("CreateDevice", device).Return(nil)
+	mockService.On("CreateDevice", mock.Anything, device).Return(nil)
 
 	usecase := NewService(mockService)
 
-	err := usecase.CreateDevice(device)
+	err := usecase.CreateDevice(context.Background(), device)
 
 	assert.NoError(t, err)
 }
@@ -25,11 +29,11 @@ func TestCreateDevice(t *testing.T) {
 func TestUpdateDevice(t *testing.T) {
 	mockService := new(repoMock.Repository)
 	device := models.Device{SerialNum: "123", Model: "model2", IP: "1.1.1.1"}
-	mockService.On("UpdateDevice", device).Return(nil)
+	mockService.On("UpdateDevice", mock.Anything, device).Return(device, nil)
 
 	usecase := NewService(mockService)
 
-	err := usecase.UpdateDevice(device)
+	_, err := usecase.UpdateDevice(context.Background(), device)
 
 	assert.NoError(t, err)
 }
@@ -37,11 +41,11 @@ func TestUpdateDevice(t *testing.T) {
 func TestGetDevice(t *testing.T) {
 	mockService := new(repoMock.Repository)
 	device := models.Device{SerialNum: "123", Model: "model2", IP: "1.1.1.1"}
-	mockService.On("GetDevice", device.SerialNum).Return(device, nil)
+	mockService.On("GetDevice", mock.Anything, device.SerialNum).Return(device, nil)
 
 	usecase := NewService(mockService)
 
-	_, err := usecase.GetDevice(device.SerialNum)
+	_, err := usecase.GetDevice(context.Background(), device.SerialNum)
 
 	assert.NoError(t, err)
 }
@@ -49,11 +53,11 @@ func TestGetDevice(t *testing.T) {
 func TestDeleteDevice(t *testing.T) {
 	mockService := new(repoMock.Repository)
 	device := models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1"}
-	mockService.On("DeleteDevice", device.SerialNum).Return(nil)
+	mockService.On("DeleteDevice", mock.Anything, device.SerialNum).Return(nil)
 
 	usecase := NewService(mockService)
 
-	err := usecase.DeleteDevice(device.SerialNum)
+	err := usecase.DeleteDevice(context.Background(), device.SerialNum)
 
 	assert.NoError(t, err)
 }
@@ -75,6 +79,14 @@ func TestValidate(t *testing.T) {
 			models.Device{SerialNum: "67890", Model: "Model2", IP: "10.0.0.1"},
 			nil,
 		},
+		{
+			models.Device{SerialNum: "67891", Model: "Model2", IP: "2001:db8::1"},
+			nil,
+		},
+		{
+			models.Device{SerialNum: "67892", Model: "Model2", IP: "10.0.0.1", IPv6: "2001:db8::1"},
+			nil,
+		},
 	}
 	for _, test := range tests {
 		err := ValidateDevice(test.device)
@@ -97,21 +109,40 @@ func TestValidateError1(t *testing.T) {
 		{
 			"invalid ip",
 			models.Device{SerialNum: "54321", Model: "Model3", IP: "192.168.0"},
-			errors.New("Invalid IP"),
+			&ValidationError{[]Violation{{"ip", CodeInvalidIP, "Invalid IP"}}},
 			//&models.ResponseError{Err: errors.New("Invalid IP")},
 		},
 		{
 			"invalid serial number",
 			models.Device{SerialNum :"", Model: "Model5", IP: "192.168.0.1"},
-			errors.New("Invalid serial number"),
+			&ValidationError{[]Violation{{"serial_num", CodeRequired, "Invalid serial number"}}},
 			//&models.ResponseError{Err: errors.New("Invalid serial number")},
 		},
 		{
 			"invalid model",
 			models.Device{SerialNum: "54321", Model:"",IP: "10.0.0.1"},
-			errors.New("Invalid model"),
+			&ValidationError{[]Violation{{"model", CodeRequired, "Invalid model"}}},
 			//&models.ResponseError{Err: errors.New("Invalid model")},
 		},
+		{
+			"ipv4 in the ipv6 field",
+			models.Device{SerialNum: "54321", Model: "Model3", IP: "10.0.0.1", IPv6: "10.0.0.2"},
+			&ValidationError{[]Violation{{"ipv6", CodeInvalidIP, "Invalid IPv6"}}},
+		},
+		{
+			"second ipv6 address",
+			models.Device{SerialNum: "54321", Model: "Model3", IP: "2001:db8::1", IPv6: "2001:db8::2"},
+			&ValidationError{[]Violation{{"ipv6", CodeDualStack, "ipv6 is only allowed when ip is an IPv4 address"}}},
+		},
+		{
+			"every field is reported",
+			models.Device{},
+			&ValidationError{[]Violation{
+				{"serial_num", CodeRequired, "Invalid serial number"},
+				{"model", CodeRequired, "Invalid model"},
+				{"ip", CodeRequired, "Invalid IP"},
+			}},
+		},
 	}
 	for _, test := range tests {
 		err := ValidateDevice(test.device)
@@ -121,3 +152,287 @@ func TestValidateError1(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateDeviceInvalid(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	usecase := NewService(mockService)
+
+	err := usecase.CreateDevice(context.Background(), models.Device{SerialNum: "123", IP: "1.1.1"})
+
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Violations, 2)
+	assert.Equal(t, "Invalid model; Invalid IP", err.Error())
+	mockService.AssertNotCalled(t, "CreateDevice", mock.Anything, mock.Anything)
+}
+
+func TestListDevicesDefaults(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	want := models.ListResult{Devices: []models.Device{{SerialNum: "123", Model: "model1", IP: "1.1.1.1"}}}
+	mockService.On("ListDevices", mock.Anything, models.ListQuery{Limit: DefaultListLimit, SortBy: models.SortBySerialNum}).Return(want, nil)
+
+	usecase := NewService(mockService)
+
+	got, err := usecase.ListDevices(context.Background(), models.ListQuery{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestListDevicesInvalidQuery(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	usecase := NewService(mockService)
+
+	_, err := usecase.ListDevices(context.Background(), models.ListQuery{
+		Limit:    MaxListLimit + 1,
+		Offset:   1,
+		Cursor:   "garbage",
+		SortBy:   "color",
+		IPPrefix: "10.0.0.0/99",
+	})
+
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	codes := make([]string, 0, len(verr.Violations))
+	for _, v := range verr.Violations {
+		codes = append(codes, v.Code)
+	}
+	assert.Equal(t, []string{CodeOutOfRange, CodeConflict, CodeInvalidCursor, CodeUnknownField, CodeInvalidCIDR}, codes)
+	mockService.AssertNotCalled(t, "ListDevices", mock.Anything, mock.Anything)
+}
+
+func TestNormalizeDevice(t *testing.T) {
+	tests := []struct {
+		in   models.Device
+		want models.Device
+	}{
+		{
+			models.Device{SerialNum: "1", IP: "::FFFF:10.0.0.1"},
+			models.Device{SerialNum: "1", IP: "10.0.0.1"},
+		},
+		{
+			models.Device{SerialNum: "2", IP: "10.0.0.1", IPv6: "2001:0DB8:0000:0000:0000:0000:0000:0001"},
+			models.Device{SerialNum: "2", IP: "10.0.0.1", IPv6: "2001:db8::1"},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, NormalizeDevice(test.in))
+	}
+}
+
+func TestCreateDeviceNormalizesAddresses(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	mockService.On("CreateDevice", mock.Anything, models.Device{SerialNum: "123", Model: "model1", IP: "2001:db8::1"}).Return(nil)
+
+	usecase := NewService(mockService)
+
+	err := usecase.CreateDevice(context.Background(), models.Device{SerialNum: "123", Model: "model1", IP: "2001:DB8::0:1"})
+
+	assert.NoError(t, err)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetDeviceByIP(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	device := models.Device{SerialNum: "123", Model: "model1", IP: "2001:db8::1"}
+	mockService.On("GetDeviceByIP", mock.Anything, "2001:db8::1", "lab").Return(device, nil)
+
+	usecase := NewService(mockService)
+
+	got, err := usecase.GetDeviceByIP(context.Background(), "2001:DB8:0::1", "lab")
+	assert.NoError(t, err)
+	assert.Equal(t, device, got)
+
+	_, err = usecase.GetDeviceByIP(context.Background(), "not-an-ip", "")
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestCompareAndSwapDevice(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	device := models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1"}
+	mockService.On("CompareAndSwapDevice", mock.Anything, device, int64(3)).Return(device, nil)
+
+	usecase := NewService(mockService)
+
+	_, err := usecase.CompareAndSwapDevice(context.Background(), device, 3)
+	assert.NoError(t, err)
+
+	var verr *ValidationError
+	_, err = usecase.CompareAndSwapDevice(context.Background(), models.Device{SerialNum: "123"}, 3)
+	assert.ErrorAs(t, err, &verr)
+	mockService.AssertNumberOfCalls(t, "CompareAndSwapDevice", 1)
+}
+
+func TestValidateDeviceDetails(t *testing.T) {
+	device := models.Device{
+		SerialNum: "1", Model: "m", IP: "10.0.0.1",
+		Vendor:   strings.Repeat("v", MaxFieldLength+1),
+		MAC:      "00:00:5e:00:53",
+		Hostname: "-edge",
+		Rack:     "a1",
+		Status:   "broken",
+		Labels:   map[string]string{"": "x", "env": strings.Repeat("p", MaxLabelLength+1)},
+	}
+	var verr *ValidationError
+	require.ErrorAs(t, ValidateDevice(device), &verr)
+	var fields, codes []string
+	for _, v := range verr.Violations {
+		fields = append(fields, v.Field)
+		codes = append(codes, v.Code)
+	}
+	assert.Equal(t, []string{"vendor", "site", "mac", "hostname", "status", "labels", "labels.env"}, fields)
+	assert.Equal(t, []string{CodeOutOfRange, CodeRequired, CodeInvalidMAC, CodeInvalidHostname, CodeInvalidStatus, CodeInvalidLabel, CodeInvalidLabel}, codes)
+
+	assert.NoError(t, ValidateDevice(models.Device{
+		SerialNum: "1", Model: "m", IP: "10.0.0.1", Vendor: "acme", Firmware: "1.2.3", MAC: "00-00-5E-00-53-01",
+		Hostname: "Edge-1.AMS.example.", Site: "ams", Rack: "a1", Status: models.StatusMaintenance, Labels: map[string]string{"env": ""},
+	}))
+}
+
+func TestNormalizeDeviceDetails(t *testing.T) {
+	got := NormalizeDevice(models.Device{SerialNum: "1", MAC: "0000.5E00.5301", Hostname: "Edge-1.AMS.example.", Labels: map[string]string{}})
+	assert.Equal(t, models.Device{SerialNum: "1", MACs: []string{"00:00:5e:00:53:01"}, Hostname: "edge-1.ams.example"}, got)
+
+	// The legacy MAC joins the others once, whatever its form.
+	got = NormalizeDevice(models.Device{SerialNum: "1", MACs: []string{"00-00-5E-00-53-02", "00:00:5E:00:53:01"}, MAC: "0000.5E00.5301"})
+	assert.Equal(t, []string{"00:00:5e:00:53:02", "00:00:5e:00:53:01"}, got.MACs)
+	assert.Empty(t, got.MAC)
+}
+
+func TestListDevicesDetailFilters(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	mockService.On("ListDevices", mock.Anything, models.ListQuery{
+		Limit: DefaultListLimit, SortBy: models.SortBySerialNum, MAC: "00:00:5e:00:53:01", Hostname: "edge-1", Status: models.StatusActive,
+	}).Return(models.ListResult{}, nil)
+	usecase := NewService(mockService)
+
+	_, err := usecase.ListDevices(context.Background(), models.ListQuery{MAC: "00-00-5E-00-53-01", Hostname: "EDGE-1", Status: models.StatusActive})
+	assert.NoError(t, err)
+	mockService.AssertExpectations(t)
+
+	_, err = usecase.ListDevices(context.Background(), models.ListQuery{MAC: "nope", Status: "broken", Selector: models.Selector{{Key: "", Op: models.SelectExists}}})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	codes := make([]string, 0, len(verr.Violations))
+	for _, v := range verr.Violations {
+		codes = append(codes, v.Code)
+	}
+	assert.Equal(t, []string{CodeInvalidMAC, CodeInvalidStatus, CodeInvalidLabel}, codes)
+}
+
+func TestValidateDeviceLabels(t *testing.T) {
+	tests := []struct {
+		key, value string
+		valid      bool
+	}{
+		{"env", "prod", true},
+		{"app.kubernetes.io/name", "edge-proxy_2", true},
+		{"Tier", "", true},
+		{"-env", "prod", false},
+		{"env.", "prod", false},
+		{"team/", "ops", false},
+		{"Example.com/team", "ops", false},
+		{"a/b/c", "ops", false},
+		{"env", "prod line", false},
+		{"env", "-prod", false},
+		{strings.Repeat("k", MaxLabelLength+1), "v", false},
+		{"example.com/" + strings.Repeat("k", MaxLabelLength), "v", true},
+	}
+	for _, test := range tests {
+		err := ValidateDevice(models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Labels: map[string]string{test.key: test.value}})
+
+		if test.valid {
+			assert.NoError(t, err, test.key+"="+test.value)
+			continue
+		}
+		var verr *ValidationError
+		if assert.ErrorAs(t, err, &verr, test.key+"="+test.value) && assert.Len(t, verr.Violations, 1) {
+			assert.Equal(t, "labels."+test.key, verr.Violations[0].Field)
+			assert.Equal(t, CodeInvalidLabel, verr.Violations[0].Code)
+		}
+	}
+}
+
+func TestListDevicesSelectorValidation(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	usecase := NewService(mockService)
+
+	selector, err := models.ParseSelector("env in (prod,-staging),!_deprecated")
+	require.NoError(t, err)
+	_, err = usecase.ListDevices(context.Background(), models.ListQuery{Selector: selector})
+
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.Len(t, verr.Violations, 2)
+	for _, v := range verr.Violations {
+		assert.Equal(t, "selector", v.Field)
+		assert.Equal(t, CodeInvalidLabel, v.Code)
+	}
+	mockService.AssertNotCalled(t, "ListDevices", mock.Anything, mock.Anything)
+}
+
+func TestValidateDeviceMACs(t *testing.T) {
+	macs := []string{"00:00:5e:00:53:01", "nope", "00-00-5E-00-53-01"}
+	err := ValidateDevice(models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: macs})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, []Violation{
+		{Field: "macs.1", Code: CodeInvalidMAC, Message: "Invalid MAC address"},
+		{Field: "macs.2", Code: CodeConflict, Message: `"00-00-5E-00-53-01" is listed twice`},
+	}, verr.Violations)
+
+	many := make([]string, MaxMACs+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("00:00:5e:00:53:%02x", i)
+	}
+	err = ValidateDevice(models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: many})
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, "macs", verr.Violations[0].Field)
+	assert.Equal(t, CodeOutOfRange, verr.Violations[0].Code)
+
+	// The legacy MAC may repeat one of the others.
+	assert.NoError(t, ValidateDevice(models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: macs[:1], MAC: macs[0]}))
+}
+
+func TestGetDeviceByMAC(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	device := models.Device{SerialNum: "123", Model: "model1", IP: "10.0.0.1", MACs: []string{"00:00:5e:00:53:01"}}
+	mockService.On("GetDeviceByMAC", mock.Anything, "00:00:5e:00:53:01").Return(device, nil)
+
+	usecase := NewService(mockService)
+
+	got, err := usecase.GetDeviceByMAC(context.Background(), "0000.5E00.5301")
+	assert.NoError(t, err)
+	assert.Equal(t, device, got)
+
+	_, err = usecase.GetDeviceByMAC(context.Background(), "not-a-mac")
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	mockService.AssertExpectations(t)
+}
+
+func TestValidateDeviceSerialNum(t *testing.T) {
+	for _, serialNum := range []string{"a/b", "a?b", "a#b", "50%", "a b", "a\tb", ".", "..", "é"} {
+		err := ValidateDevice(models.Device{SerialNum: serialNum, Model: "m", IP: "10.0.0.1"})
+		var verr *ValidationError
+		require.ErrorAs(t, err, &verr, serialNum)
+		assert.Equal(t, []Violation{{
+			Field: "serial_num", Code: CodeInvalidSerialNum,
+			Message: "serial number must be a single URL path segment, without '/', '?', '#', '%', spaces or control characters",
+		}}, verr.Violations, serialNum)
+	}
+	for _, serialNum := range []string{"SN-1", "a.b_c~d", "rack:1"} {
+		assert.NoError(t, ValidateDevice(models.Device{SerialNum: serialNum, Model: "m", IP: "10.0.0.1"}), serialNum)
+	}
+}
+
+func TestValidateDeviceReservedSerialNum(t *testing.T) {
+	for _, serialNum := range ReservedSerialNums {
+		err := ValidateDevice(models.Device{SerialNum: serialNum, Model: "m", IP: "10.0.0.1"})
+		var verr *ValidationError
+		require.ErrorAs(t, err, &verr, serialNum)
+		assert.Equal(t, CodeReserved, verr.Violations[0].Code, serialNum)
+	}
+	assert.Subset(t, ReservedSerialNums, []string{"by-ip", "by-mac"})
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventTypes maps the audit actions to the type of their event.
+var eventTypes = map[string]string{
+	models.AuditCreate:     models.EventCreated,
+	models.AuditUpdate:     models.EventUpdated,
+	models.AuditDelete:     models.EventDeleted,
+	models.AuditRestore:    models.EventRestored,
+	models.AuditPurge:      models.EventPurged,
+	models.AuditTransition: models.EventTransitioned,
+}
+
+// Publish decorates next so that every device it changes is published on
+// bus, see Audit for how the changes are seen.
+func Publish(next Service, bus *EventBus) Service {
+	return &tracked{next: next, record: func(_ context.Context, changes []change) error {
+		events := make([]models.Event, len(changes))
+		for i, c := range changes {
+			events[i] = models.Event{Type: eventTypes[c.Action], SerialNum: c.SerialNum, Device: c.After}
+			if c.After == nil {
+				events[i].Device = c.Before
+			}
+		}
+		bus.Publish(events...)
+		return nil
+	}}
+}
+
+const (
+	// DefaultEventHistory is the number of events kept for the subscribers
+	// resuming after a disconnection.
+	DefaultEventHistory = 1024
+	// subscriberBuffer is the number of events a subscriber can lag behind
+	// before it is dropped.
+	subscriberBuffer = 256
+)
+
+// EventBus numbers the published events and fans them out to the
+// subscribers. It keeps the last events so a subscriber can resume after the
+// last one it saw. Numbers start over with every bus, Epoch tells the buses
+// apart.
+type EventBus struct {
+	epoch   string
+	mu      sync.Mutex
+	history []models.Event
+	size    int
+	lastID  uint64
+	subs    map[*Subscription]struct{}
+	hooks   []func(events []models.Event)
+	closed  bool
+	now     func() time.Time
+}
+
+// Subscription receives the events published after it was made. Events is
+// closed when the subscriber fell too far behind or the bus was closed.
+type Subscription struct {
+	Events <-chan models.Event
+	// Start is the ID of the last event published before the subscription.
+	Start  uint64
+	events chan models.Event
+}
+
+// NewEventBus keeps the last size events, DefaultEventHistory when size is
+// not positive.
+func NewEventBus(size int) *EventBus {
+	if size <= 0 {
+		size = DefaultEventHistory
+	}
+	return &EventBus{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:  size,
+		subs:  make(map[*Subscription]struct{}),
+		now:   time.Now,
+	}
+}
+
+// Epoch identifies the bus, the event numbers are only meaningful along with
+// it.
+func (b *EventBus) Epoch() string {
+	return b.epoch
+}
+
+// OnPublish registers fn to be called with every published batch of events,
+// before Publish returns. fn must not block.
+func (b *EventBus) OnPublish(fn func(events []models.Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hooks = append(b.hooks, fn)
+}
+
+// Publish numbers and timestamps the events and delivers them.
+func (b *EventBus) Publish(events ...models.Event) {
+	if len(events) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	now := b.now().UTC()
+	published := make([]models.Event, len(events))
+	for i, event := range events {
+		b.lastID++
+		event.ID = b.lastID
+		event.Time = now
+		published[i] = event
+	}
+	b.history = append(b.history, published...)
+	if len(b.history) > b.size {
+		b.history = append(b.history[:0:0], b.history[len(b.history)-b.size:]...)
+	}
+
+	for sub := range b.subs {
+		if !sub.send(published) {
+			b.drop(sub)
+		}
+	}
+	for _, fn := range b.hooks {
+		fn(published)
+	}
+}
+
+// Subscribe returns a subscription to the next events.
+func (b *EventBus) Subscribe() *Subscription {
+	_, _, sub := b.subscribe(0, false)
+	return sub
+}
+
+// Resume returns the kept events after lastID along with a subscription to
+// the next ones. complete is false when events after lastID were already
+// dropped from the history, or lastID was never published, the subscriber
+// missed some and must resynchronize.
+func (b *EventBus) Resume(lastID uint64) (missed []models.Event, complete bool, sub *Subscription) {
+	return b.subscribe(lastID, true)
+}
+
+func (b *EventBus) subscribe(lastID uint64, resume bool) (missed []models.Event, complete bool, sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make(chan models.Event, subscriberBuffer)
+	sub = &Subscription{Events: events, Start: b.lastID, events: events}
+	if b.closed {
+		close(events)
+		return nil, true, sub
+	}
+	b.subs[sub] = struct{}{}
+
+	if !resume {
+		return nil, true, sub
+	}
+	if lastID > b.lastID {
+		return nil, false, sub
+	}
+	for i, event := range b.history {
+		if event.ID > lastID {
+			// The history has no gap, it holds the events after lastID if
+			// it starts right after it.
+			return append([]models.Event(nil), b.history[i:]...), i > 0 || event.ID == lastID+1, sub
+		}
+	}
+	return nil, true, sub
+}
+
+// send reports false when the subscriber has no room left for the events.
+func (sub *Subscription) send(events []models.Event) bool {
+	for _, event := range events {
+		select {
+		case sub.events <- event:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Unsubscribe ends the subscription, it can be called more than once.
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(sub)
+}
+
+func (b *EventBus) drop(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Close ends every subscription, later events are not published.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.drop(sub)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, sub *Subscription, n int) []models.Event {
+	t.Helper()
+	events := make([]models.Event, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case event, ok := <-sub.Events:
+			require.True(t, ok, "subscription closed after %d events", i)
+			events = append(events, event)
+		default:
+			require.Failf(t, "missing event", "got %d events, want %d", i, n)
+		}
+	}
+	return events
+}
+
+func TestPublish(t *testing.T) {
+	bus := NewEventBus(0)
+	usecase := Publish(NewService(repositories.NewRepoDevice()), bus)
+	sub := bus.Subscribe()
+
+	require.NoError(t, usecase.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	_, err := usecase.UpdateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m2", IP: "10.0.0.1"})
+	require.NoError(t, err)
+	require.NoError(t, usecase.DeleteDevice(context.Background(), "1"))
+	_, err = usecase.RestoreDevice(context.Background(), "1")
+	require.NoError(t, err)
+	// Failed changes are not published.
+	assert.Error(t, usecase.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+
+	events := receive(t, sub, 4)
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+		assert.Equal(t, uint64(i+1), event.ID)
+		assert.Equal(t, "1", event.SerialNum)
+		assert.False(t, event.Time.IsZero())
+	}
+	assert.Equal(t, []string{models.EventCreated, models.EventUpdated, models.EventDeleted, models.EventRestored}, types)
+	assert.Equal(t, "m2", events[1].Device.Model)
+	// A deleted device is the one that is gone.
+	assert.Equal(t, "m2", events[2].Device.Model)
+	assert.Equal(t, int64(3), events[3].Device.Version)
+	assert.Empty(t, sub.Events)
+}
+
+func TestEventBusResume(t *testing.T) {
+	bus := NewEventBus(3)
+	for _, serialNum := range []string{"1", "2", "3", "4", "5"} {
+		bus.Publish(models.Event{Type: models.EventCreated, SerialNum: serialNum})
+	}
+
+	missed, complete, sub := bus.Resume(3)
+	assert.True(t, complete)
+	assert.Equal(t, uint64(5), sub.Start)
+	require.Len(t, missed, 2)
+	assert.Equal(t, "4", missed[0].SerialNum)
+	assert.Equal(t, "5", missed[1].SerialNum)
+
+	missed, complete, _ = bus.Resume(5)
+	assert.True(t, complete)
+	assert.Empty(t, missed)
+
+	// Event 2 was dropped from the history.
+	missed, complete, _ = bus.Resume(1)
+	assert.False(t, complete)
+	assert.Len(t, missed, 3)
+
+	missed, complete, _ = bus.Resume(0)
+	assert.False(t, complete)
+	assert.Len(t, missed, 3)
+
+	// Event 9 was never published.
+	_, complete, _ = bus.Resume(9)
+	assert.False(t, complete)
+
+	bus.Publish(models.Event{Type: models.EventDeleted, SerialNum: "1"})
+	events := receive(t, sub, 1)
+	assert.Equal(t, uint64(6), events[0].ID)
+}
+
+func TestEventBusDropsSlowSubscribers(t *testing.T) {
+	bus := NewEventBus(0)
+	slow := bus.Subscribe()
+	for i := 0; i <= subscriberBuffer; i++ {
+		bus.Publish(models.Event{Type: models.EventCreated})
+	}
+	for range slow.Events {
+	}
+
+	sub := bus.Subscribe()
+	bus.Close()
+	_, ok := <-sub.Events
+	assert.False(t, ok)
+	bus.Unsubscribe(sub)
+
+	// Nothing is published once the bus is closed.
+	var published []models.Event
+	bus.OnPublish(func(events []models.Event) { published = append(published, events...) })
+	bus.Publish(models.Event{Type: models.EventCreated})
+	assert.Empty(t, published)
+}
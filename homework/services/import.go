@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"homework/models"
+	"homework/repositories"
+)
+
+// ImportDevices creates, or with models.ImportUpsert also replaces, every
+// device in order, best effort, and returns one result per device. A dry run
+// checks the devices against the stored ones and the earlier devices of the
+// import without writing anything. Like a PUT, a replacement keeps the status.
+func (u *Usercase) ImportDevices(ctx context.Context, devices []models.Device, mode models.ImportMode, dryRun bool) ([]models.ImportResult, error) {
+	if mode != models.ImportCreate && mode != models.ImportUpsert {
+		var verr ValidationError
+		verr.Add("mode", CodeInvalidMode, fmt.Sprintf("mode must be %q or %q", models.ImportCreate, models.ImportUpsert))
+		return nil, verr.Err()
+	}
+
+	imp := importer{
+		devices: u.devices,
+		results: make([]models.ImportResult, len(devices)),
+		known:   make(map[string]bool),
+		claimed: make(map[string]string),
+		created: make(map[string]models.Device),
+	}
+	for i, d := range devices {
+		if err := ValidateDevice(d); err != nil {
+			imp.results[i].Err = err
+			continue
+		}
+		d = NormalizeDevice(d)
+
+		exists, err := imp.exists(ctx, d.SerialNum)
+		if err != nil {
+			return nil, err
+		}
+		if exists && mode == models.ImportCreate {
+			imp.results[i].Err = fmt.Errorf("%q :%w", d.SerialNum, models.ErrAlredyExist)
+			continue
+		}
+		action := models.ImportCreated
+		if exists {
+			action = models.ImportUpdated
+			// An upsert cannot skip the lifecycle either, see checkStatusKept.
+			var err error
+			if created, ok := imp.created[d.SerialNum]; ok {
+				err = statusKept(d, created)
+			} else {
+				err = u.checkStatusKept(ctx, d, models.AnyVersion)
+			}
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				imp.results[i].Err = err
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		imp.results[i].Action = action
+
+		if dryRun {
+			err := imp.checkAddresses(ctx, d)
+			if err != nil && !errors.Is(err, models.ErrIPConflict) && !errors.Is(err, models.ErrMACConflict) {
+				return nil, err
+			}
+			if imp.results[i].Err = err; err == nil {
+				imp.known[d.SerialNum] = true
+				imp.create(d, action)
+			}
+			continue
+		}
+
+		// Consecutive rows doing the same thing share a batch, the batches
+		// run in row order so a row sees the effect of the earlier ones.
+		if action != imp.action || len(imp.pending) == MaxBatchSize {
+			if err := imp.flush(ctx); err != nil {
+				return nil, err
+			}
+			imp.action = action
+		}
+		imp.pending = append(imp.pending, d)
+		imp.index = append(imp.index, i)
+		imp.known[d.SerialNum] = true
+		imp.create(d, action)
+	}
+	if err := imp.flush(ctx); err != nil {
+		return nil, err
+	}
+	return imp.results, nil
+}
+
+type importer struct {
+	devices repositories.Repository
+	results []models.ImportResult
+	// known caches whether a serial number exists, as of the rows seen so far.
+	known map[string]bool
+	// claimed maps the addresses taken by the rows of a dry run to their device.
+	claimed map[string]string
+	// created holds the devices created by the rows so far, which the later
+	// rows replace before they are stored.
+	created map[string]models.Device
+
+	action  string
+	pending []models.Device
+	index   []int
+}
+
+func (imp *importer) exists(ctx context.Context, serialNum string) (bool, error) {
+	if exists, ok := imp.known[serialNum]; ok {
+		return exists, nil
+	}
+	_, err := imp.devices.GetDevice(ctx, serialNum)
+	switch {
+	case err == nil:
+		imp.known[serialNum] = true
+	case errors.Is(err, models.ErrNotFound):
+		imp.known[serialNum] = false
+	default:
+		return false, err
+	}
+	return imp.known[serialNum], nil
+}
+
+// create records the device a row creates, with the status it gets.
+func (imp *importer) create(d models.Device, action string) {
+	if action != models.ImportCreated {
+		return
+	}
+	if d.Status == "" {
+		d.Status = models.DefaultStatus
+	}
+	imp.created[d.SerialNum] = d
+}
+
+// checkAddresses fails with models.ErrIPConflict, or models.ErrMACConflict,
+// when an address of a dry run device is already held by another one, and
+// claims its addresses otherwise.
+func (imp *importer) checkAddresses(ctx context.Context, d models.Device) error {
+	for _, ip := range d.Addresses() {
+		key := d.Segment + "\x00" + ip
+		owner, ok := imp.claimed[key]
+		if !ok {
+			holder, err := imp.devices.GetDeviceByIP(ctx, ip, d.Segment)
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				return err
+			}
+			owner = holder.SerialNum
+		}
+		if owner != "" && owner != d.SerialNum {
+			return fmt.Errorf("%q is used by %q :%w", ip, owner, models.ErrIPConflict)
+		}
+	}
+	for _, mac := range d.MACs {
+		owner, ok := imp.claimed["mac\x00"+mac]
+		if !ok {
+			holder, err := imp.devices.GetDeviceByMAC(ctx, mac)
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				return err
+			}
+			owner = holder.SerialNum
+		}
+		if owner != "" && owner != d.SerialNum {
+			return fmt.Errorf("%q is used by %q :%w", mac, owner, models.ErrMACConflict)
+		}
+	}
+	for _, ip := range d.Addresses() {
+		imp.claimed[d.Segment+"\x00"+ip] = d.SerialNum
+	}
+	for _, mac := range d.MACs {
+		imp.claimed["mac\x00"+mac] = d.SerialNum
+	}
+	return nil
+}
+
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.pending) == 0 {
+		return nil
+	}
+	var errs []error
+	var err error
+	if imp.action == models.ImportCreated {
+		errs, err = imp.devices.CreateDevices(ctx, imp.pending, models.BatchBestEffort)
+	} else {
+		errs, err = imp.devices.UpdateDevices(ctx, imp.pending, models.BatchBestEffort)
+	}
+	if err != nil {
+		return err
+	}
+	for j, i := range imp.index {
+		imp.results[i].Err = errs[j]
+		if errs[j] != nil && imp.action == models.ImportCreated {
+			delete(imp.known, imp.pending[j].SerialNum)
+			delete(imp.created, imp.pending[j].SerialNum)
+		}
+	}
+	imp.pending, imp.index = nil, nil
+	return nil
+}
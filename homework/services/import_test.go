@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportDevices(t *testing.T) {
+	repo := repositories.NewRepoDevice()
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	usecase := NewService(repo)
+
+	devices := []models.Device{
+		{SerialNum: "1", Model: "m2", IP: "10.0.0.1"},
+		{SerialNum: "2", Model: "m", IP: "10.0.0.2"},
+		{SerialNum: "3", Model: "m"},
+		{SerialNum: "4", Model: "m", IP: "10.0.0.2"},
+		{SerialNum: "2", Model: "m3", IP: "10.0.0.3"},
+	}
+
+	results, err := usecase.ImportDevices(context.Background(), devices, models.ImportCreate, false)
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, models.ErrAlredyExist)
+	assert.Equal(t, models.ImportCreated, results[1].Action)
+	assert.NoError(t, results[1].Err)
+	var verr *ValidationError
+	assert.ErrorAs(t, results[2].Err, &verr)
+	assert.ErrorIs(t, results[3].Err, models.ErrIPConflict)
+	assert.ErrorIs(t, results[4].Err, models.ErrAlredyExist)
+
+	results, err = usecase.ImportDevices(context.Background(), devices, models.ImportUpsert, false)
+	require.NoError(t, err)
+	assert.Equal(t, models.ImportUpdated, results[0].Action)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[3].Err, models.ErrIPConflict)
+	assert.Equal(t, models.ImportUpdated, results[4].Action)
+	assert.NoError(t, results[4].Err)
+
+	got, err := repo.GetDevice(context.Background(), "2")
+	require.NoError(t, err)
+	assert.Equal(t, "m3", got.Model)
+}
+
+func TestImportDevicesDryRun(t *testing.T) {
+	repo := repositories.NewRepoDevice()
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	usecase := NewService(repo)
+
+	results, err := usecase.ImportDevices(context.Background(), []models.Device{
+		{SerialNum: "1", Model: "m2", IP: "10.0.0.1"},
+		{SerialNum: "2", Model: "m", IP: "10.0.0.1"},
+		{SerialNum: "3", Model: "m", IP: "10.0.0.3"},
+		{SerialNum: "4", Model: "m", IP: "10.0.0.3"},
+		{SerialNum: "3", Model: "m", IP: "10.0.0.3"},
+	}, models.ImportUpsert, true)
+	require.NoError(t, err)
+
+	assert.Equal(t, models.ImportUpdated, results[0].Action)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, models.ErrIPConflict)
+	assert.Equal(t, models.ImportCreated, results[2].Action)
+	assert.ErrorIs(t, results[3].Err, models.ErrIPConflict)
+	assert.Equal(t, models.ImportUpdated, results[4].Action)
+
+	result, err := repo.ListDevices(context.Background(), models.ListQuery{})
+	require.NoError(t, err)
+	assert.Len(t, result.Devices, 1)
+	assert.Equal(t, "m", result.Devices[0].Model)
+}
+
+func TestImportDevicesMode(t *testing.T) {
+	_, err := NewService(repositories.NewRepoDevice()).ImportDevices(context.Background(), nil, "replace", false)
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestImportDevicesMACs(t *testing.T) {
+	repo := repositories.NewRepoDevice()
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", MACs: []string{"00:00:5e:00:53:01"}}))
+	usecase := NewService(repo)
+	devices := []models.Device{
+		{SerialNum: "2", Model: "m", IP: "10.0.0.2", MACs: []string{"00-00-5E-00-53-01"}},
+		{SerialNum: "3", Model: "m", IP: "10.0.0.3", MAC: "00:00:5e:00:53:03"},
+		{SerialNum: "4", Model: "m", IP: "10.0.0.4", MACs: []string{"00:00:5e:00:53:03"}},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		results, err := usecase.ImportDevices(context.Background(), devices, models.ImportCreate, dryRun)
+		require.NoError(t, err)
+		assert.ErrorIs(t, results[0].Err, models.ErrMACConflict, "dry run %t", dryRun)
+		assert.NoError(t, results[1].Err, "dry run %t", dryRun)
+		assert.ErrorIs(t, results[2].Err, models.ErrMACConflict, "dry run %t", dryRun)
+	}
+
+	got, err := repo.GetDeviceByMAC(context.Background(), "00:00:5e:00:53:03")
+	require.NoError(t, err)
+	assert.Equal(t, "3", got.SerialNum)
+}
+
+func TestImportDevicesKeepsStatus(t *testing.T) {
+	repo := repositories.NewRepoDevice()
+	require.NoError(t, repo.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	usecase := NewService(repo)
+	devices := []models.Device{
+		{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: models.StatusDecommissioned, StatusReason: "gone"},
+		{SerialNum: "2", Model: "m", IP: "10.0.0.2", Status: models.StatusProvisioning},
+		{SerialNum: "2", Model: "m", IP: "10.0.0.2", Status: models.StatusActive},
+		{SerialNum: "1", Model: "m2", IP: "10.0.0.1", Status: models.StatusActive},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		results, err := usecase.ImportDevices(context.Background(), devices, models.ImportUpsert, dryRun)
+		require.NoError(t, err)
+		var verr *ValidationError
+		require.ErrorAs(t, results[0].Err, &verr, "dry run %t", dryRun)
+		assert.Equal(t, CodeImmutable, verr.Violations[0].Code, "dry run %t", dryRun)
+		assert.NoError(t, results[1].Err, "dry run %t", dryRun)
+		// The device created by the import has the status of its row.
+		assert.ErrorAs(t, results[2].Err, &verr, "dry run %t", dryRun)
+		assert.Equal(t, models.ImportUpdated, results[3].Action, "dry run %t", dryRun)
+		assert.NoError(t, results[3].Err, "dry run %t", dryRun)
+	}
+
+	got, err := repo.GetDevice(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusActive, got.Status)
+	assert.Empty(t, got.StatusReason)
+	assert.Equal(t, "m2", got.Model)
+	got, err = repo.GetDevice(context.Background(), "2")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProvisioning, got.Status)
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"homework/models"
+	"slices"
+	"strings"
+)
+
+// transitions is the lifecycle graph, the statuses a device can move to from
+// each status. A decommissioned device stays so.
+var transitions = map[string][]string{
+	models.StatusProvisioning:   {models.StatusActive, models.StatusDecommissioned},
+	models.StatusActive:         {models.StatusMaintenance, models.StatusDecommissioned},
+	models.StatusMaintenance:    {models.StatusActive, models.StatusDecommissioned},
+	models.StatusDecommissioned: nil,
+}
+
+// Transitions returns the statuses a device can move to from status.
+func Transitions(status string) []string {
+	return slices.Clone(transitions[status])
+}
+
+// checkTransition tells why the device cannot move to status.
+func checkTransition(device models.Device, status string) error {
+	next := transitions[device.Status]
+	if slices.Contains(next, status) {
+		return nil
+	}
+	if len(next) == 0 {
+		return fmt.Errorf("%q is %s and cannot move to %s anymore :%w", device.SerialNum, device.Status, status, models.ErrInvalidTransition)
+	}
+	return fmt.Errorf("%q cannot move from %s to %s, only to %s :%w",
+		device.SerialNum, device.Status, status, strings.Join(next, " or "), models.ErrInvalidTransition)
+}
+
+func validateTransition(status, reason string) error {
+	var verr ValidationError
+	if !checkStatus(status) {
+		verr.Add("status", CodeInvalidStatus, fmt.Sprintf("status must be one of %s", strings.Join(models.Statuses, ", ")))
+	}
+	if strings.TrimSpace(reason) == "" {
+		verr.Add("reason", CodeRequired, "a transition needs a reason")
+	} else if len(reason) > MaxFieldLength {
+		verr.Add("reason", CodeOutOfRange, fmt.Sprintf("reason must be at most %d bytes long", MaxFieldLength))
+	}
+	return verr.Err()
+}
+
+// TransitionDevice moves the device to another lifecycle status along the
+// graph of transitions, it fails with models.ErrInvalidTransition for a move
+// the graph does not allow. The reason is kept with the status.
+func (u *Usercase) TransitionDevice(ctx context.Context, serialNumber, status, reason string, version int64) (models.Device, error) {
+	if err := validateTransition(status, reason); err != nil {
+		return models.Device{}, err
+	}
+	status = canonicalStatus(status)
+	return u.retryWrite(ctx, serialNumber, version, func(current models.Device) (models.Device, error) {
+		if err := checkTransition(current, status); err != nil {
+			return models.Device{}, err
+		}
+		// The version of current pins the status the transition starts from.
+		return u.devices.TransitionDevice(ctx, serialNumber, status, reason, current.Version)
+	})
+}
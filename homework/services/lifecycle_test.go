@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitionDevice(t *testing.T) {
+	tests := []struct {
+		from, to string
+		err      error
+	}{
+		{models.StatusProvisioning, models.StatusActive, nil},
+		{models.StatusProvisioning, models.StatusDecommissioned, nil},
+		{models.StatusProvisioning, models.StatusMaintenance, models.ErrInvalidTransition},
+		{models.StatusActive, models.StatusMaintenance, nil},
+		{models.StatusActive, models.StatusProvisioning, models.ErrInvalidTransition},
+		{models.StatusActive, models.StatusActive, models.ErrInvalidTransition},
+		{models.StatusMaintenance, models.StatusActive, nil},
+		{models.StatusMaintenance, models.StatusDecommissioned, nil},
+		{models.StatusDecommissioned, models.StatusActive, models.ErrInvalidTransition},
+	}
+	for _, test := range tests {
+		usecase := NewService(repositories.NewRepoDevice())
+		require.NoError(t, usecase.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: test.from}))
+
+		device, err := usecase.TransitionDevice(context.Background(), "1", test.to, "because", models.AnyVersion)
+
+		name := test.from + " to " + test.to
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, name)
+			continue
+		}
+		require.NoError(t, err, name)
+		assert.Equal(t, test.to, device.Status, name)
+		assert.Equal(t, "because", device.StatusReason, name)
+		assert.Equal(t, int64(2), device.Version, name)
+	}
+}
+
+func TestTransitionDeviceRejected(t *testing.T) {
+	usecase := NewService(repositories.NewRepoDevice())
+	require.NoError(t, usecase.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+
+	_, err := usecase.TransitionDevice(context.Background(), "1", "broken", " ", models.AnyVersion)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, []Violation{
+		{Field: "status", Code: CodeInvalidStatus, Message: "status must be one of provisioning, active, maintenance, decommissioned"},
+		{Field: "reason", Code: CodeRequired, Message: "a transition needs a reason"},
+	}, verr.Violations)
+
+	_, err = usecase.TransitionDevice(context.Background(), "1", models.StatusMaintenance, "fan swap", 2)
+	assert.ErrorIs(t, err, models.ErrVersionMismatch)
+	_, err = usecase.TransitionDevice(context.Background(), "2", models.StatusMaintenance, "fan swap", models.AnyVersion)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = usecase.TransitionDevice(context.Background(), "1", models.StatusProvisioning, "reinstall", models.AnyVersion)
+	assert.EqualError(t, err, `"1" cannot move from active to provisioning, only to maintenance or decommissioned :invalid transition`)
+
+	// A patch cannot skip the lifecycle.
+	_, err = usecase.PatchDevice(context.Background(), "1", models.MergePatchType, []byte(`{"status": "decommissioned"}`), models.AnyVersion)
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, CodeImmutable, verr.Violations[0].Code)
+}
+
+func TestAuditTransition(t *testing.T) {
+	log := repositories.NewMemoryAuditLog()
+	usecase := Audit(NewService(repositories.NewRepoDevice()), log)
+	ctx := WithPrincipal(context.Background(), models.Principal{Name: "ci", Role: models.RoleAdmin})
+	require.NoError(t, usecase.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+
+	_, err := usecase.TransitionDevice(ctx, "1", models.StatusMaintenance, "fan swap", models.AnyVersion)
+	require.NoError(t, err)
+	_, err = usecase.TransitionDevice(ctx, "1", models.StatusProvisioning, "reinstall", models.AnyVersion)
+	require.ErrorIs(t, err, models.ErrInvalidTransition)
+
+	history, err := log.History("1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, models.AuditTransition, history[1].Action)
+	assert.Equal(t, "fan swap", history[1].Reason)
+	assert.Equal(t, models.StatusActive, history[1].Before.Status)
+	assert.Equal(t, models.StatusMaintenance, history[1].After.Status)
+}
+
+func TestUpdateDeviceKeepsStatus(t *testing.T) {
+	usecase := NewService(repositories.NewRepoDevice())
+	ctx := context.Background()
+	require.NoError(t, usecase.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	require.NoError(t, usecase.CreateDevice(ctx, models.Device{SerialNum: "2", Model: "m", IP: "10.0.0.2"}))
+
+	// Like a patch, a replacement cannot skip the lifecycle.
+	var verr *ValidationError
+	_, err := usecase.UpdateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: models.StatusDecommissioned})
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, []Violation{{Field: "status", Code: CodeImmutable, Message: "status only changes through a transition"}}, verr.Violations)
+	_, err = usecase.CompareAndSwapDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", StatusReason: "gone"}, 1)
+	require.ErrorAs(t, err, &verr)
+
+	results, err := usecase.UpdateDevices(ctx, []models.Device{
+		{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: models.StatusMaintenance},
+		{SerialNum: "2", Model: "n", IP: "10.0.0.2"},
+	}, models.BatchBestEffort)
+	require.NoError(t, err)
+	require.ErrorAs(t, results[0], &verr)
+	assert.NoError(t, results[1])
+
+	// Repeating the status, or leaving it out, keeps it.
+	_, err = usecase.UpdateDevice(ctx, models.Device{SerialNum: "1", Model: "n", IP: "10.0.0.1", Status: models.StatusActive})
+	require.NoError(t, err)
+	_, err = usecase.UpdateDevice(ctx, models.Device{SerialNum: "1", Model: "o", IP: "10.0.0.1"})
+	require.NoError(t, err)
+	device, err := usecase.GetDevice(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusActive, device.Status)
+	assert.Equal(t, "o", device.Model)
+}
+
+func TestLegacyStatusNames(t *testing.T) {
+	usecase := NewService(repositories.NewRepoDevice())
+	ctx := context.Background()
+	require.NoError(t, usecase.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1", Status: "planned"}))
+
+	device, err := usecase.GetDevice(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProvisioning, device.Status)
+
+	device, err = usecase.TransitionDevice(ctx, "1", "retired", "never shipped", models.AnyVersion)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusDecommissioned, device.Status)
+
+	result, err := usecase.ListDevices(ctx, models.ListQuery{Status: "retired"})
+	require.NoError(t, err)
+	require.Len(t, result.Devices, 1)
+	assert.Equal(t, "1", result.Devices[0].SerialNum)
+}
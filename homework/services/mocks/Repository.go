@@ -3,9 +3,12 @@
 package mocks
 
 import (
+	context "context"
 	models "homework/models"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // Repository is an autogenerated mock type for the Repository type
@@ -13,13 +16,13 @@ type Repository struct {
 	mock.Mock
 }
 
-// CreateDevice provides a mock function with given fields: _a0
-func (_m *Repository) CreateDevice(_a0 models.Device) error {
-	ret := _m.Called(_a0)
+// CompareAndDeleteDevice provides a mock function with given fields: ctx, serialNumber, version
+func (_m *Repository) CompareAndDeleteDevice(ctx context.Context, serialNumber string, version int64) error {
+	ret := _m.Called(ctx, serialNumber, version)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(models.Device) error); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) error); ok {
+		r0 = rf(ctx, serialNumber, version)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -27,13 +30,37 @@ func (_m *Repository) CreateDevice(_a0 models.Device) error {
 	return r0
 }
 
-// DeleteDevice provides a mock function with given fields: _a0
-func (_m *Repository) DeleteDevice(_a0 string) error {
-	ret := _m.Called(_a0)
+// CompareAndSwapDevice provides a mock function with given fields: ctx, device, version
+func (_m *Repository) CompareAndSwapDevice(ctx context.Context, device models.Device, version int64) (models.Device, error) {
+	ret := _m.Called(ctx, device, version)
+
+	var r0 models.Device
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Device, int64) (models.Device, error)); ok {
+		return rf(ctx, device, version)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.Device, int64) models.Device); ok {
+		r0 = rf(ctx, device, version)
+	} else {
+		r0 = ret.Get(0).(models.Device)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.Device, int64) error); ok {
+		r1 = rf(ctx, device, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateDevice provides a mock function with given fields: ctx, device
+func (_m *Repository) CreateDevice(ctx context.Context, device models.Device) error {
+	ret := _m.Called(ctx, device)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, models.Device) error); ok {
+		r0 = rf(ctx, device)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -41,23 +68,25 @@ func (_m *Repository) DeleteDevice(_a0 string) error {
 	return r0
 }
 
-// GetDevice provides a mock function with given fields: _a0
-func (_m *Repository) GetDevice(_a0 string) (models.Device, error) {
-	ret := _m.Called(_a0)
+// CreateDevices provides a mock function with given fields: ctx, devices, mode
+func (_m *Repository) CreateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	ret := _m.Called(ctx, devices, mode)
 
-	var r0 models.Device
+	var r0 []error
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string) (models.Device, error)); ok {
-		return rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, []models.Device, models.BatchMode) ([]error, error)); ok {
+		return rf(ctx, devices, mode)
 	}
-	if rf, ok := ret.Get(0).(func(string) models.Device); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, []models.Device, models.BatchMode) []error); ok {
+		r0 = rf(ctx, devices, mode)
 	} else {
-		r0 = ret.Get(0).(models.Device)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(_a0)
+	if rf, ok := ret.Get(1).(func(context.Context, []models.Device, models.BatchMode) error); ok {
+		r1 = rf(ctx, devices, mode)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -65,13 +94,13 @@ func (_m *Repository) GetDevice(_a0 string) (models.Device, error) {
 	return r0, r1
 }
 
-// UpdateDevice provides a mock function with given fields: _a0
-func (_m *Repository) UpdateDevice(_a0 models.Device) error {
-	ret := _m.Called(_a0)
+// DeleteDevice provides a mock function with given fields: ctx, serialNumber
+func (_m *Repository) DeleteDevice(ctx context.Context, serialNumber string) error {
+	ret := _m.Called(ctx, serialNumber)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(models.Device) error); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, serialNumber)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -79,6 +108,278 @@ func (_m *Repository) UpdateDevice(_a0 models.Device) error {
 	return r0
 }
 
+// DeleteDevices provides a mock function with given fields: ctx, serialNumbers, mode
+func (_m *Repository) DeleteDevices(ctx context.Context, serialNumbers []string, mode models.BatchMode) ([]error, error) {
+	ret := _m.Called(ctx, serialNumbers, mode)
+
+	var r0 []error
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string, models.BatchMode) ([]error, error)); ok {
+		return rf(ctx, serialNumbers, mode)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string, models.BatchMode) []error); ok {
+		r0 = rf(ctx, serialNumbers, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string, models.BatchMode) error); ok {
+		r1 = rf(ctx, serialNumbers, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDevice provides a mock function with given fields: ctx, serialNumber
+func (_m *Repository) GetDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	ret := _m.Called(ctx, serialNumber)
+
+	var r0 models.Device
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (models.Device, error)); ok {
+		return rf(ctx, serialNumber)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) models.Device); ok {
+		r0 = rf(ctx, serialNumber)
+	} else {
+		r0 = ret.Get(0).(models.Device)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, serialNumber)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceByIP provides a mock function with given fields: ctx, ip, segment
+func (_m *Repository) GetDeviceByIP(ctx context.Context, ip string, segment string) (models.Device, error) {
+	ret := _m.Called(ctx, ip, segment)
+
+	var r0 models.Device
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (models.Device, error)); ok {
+		return rf(ctx, ip, segment)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) models.Device); ok {
+		r0 = rf(ctx, ip, segment)
+	} else {
+		r0 = ret.Get(0).(models.Device)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, ip, segment)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceByMAC provides a mock function with given fields: ctx, mac
+func (_m *Repository) GetDeviceByMAC(ctx context.Context, mac string) (models.Device, error) {
+	ret := _m.Called(ctx, mac)
+
+	var r0 models.Device
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (models.Device, error)); ok {
+		return rf(ctx, mac)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) models.Device); ok {
+		r0 = rf(ctx, mac)
+	} else {
+		r0 = ret.Get(0).(models.Device)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, mac)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListDeletedDevices provides a mock function with given fields: ctx
+func (_m *Repository) ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []models.DeletedDevice
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]models.DeletedDevice, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []models.DeletedDevice); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.DeletedDevice)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListDevices provides a mock function with given fields: ctx, q
+func (_m *Repository) ListDevices(ctx context.Context, q models.ListQuery) (models.ListResult, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 models.ListResult
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.ListQuery) (models.ListResult, error)); ok {
+		return rf(ctx, q)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.ListQuery) models.ListResult); ok {
+		r0 = rf(ctx, q)
+	} else {
+		r0 = ret.Get(0).(models.ListResult)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.ListQuery) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeDeletedDevices provides a mock function with given fields: ctx, before
+func (_m *Repository) PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error) {
+	ret := _m.Called(ctx, before)
+
+	var r0 []models.DeletedDevice
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) ([]models.DeletedDevice, error)); ok {
+		return rf(ctx, before)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) []models.DeletedDevice); ok {
+		r0 = rf(ctx, before)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.DeletedDevice)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreDevice provides a mock function with given fields: ctx, serialNumber
+func (_m *Repository) RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	ret := _m.Called(ctx, serialNumber)
+
+	var r0 models.Device
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (models.Device, error)); ok {
+		return rf(ctx, serialNumber)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) models.Device); ok {
+		r0 = rf(ctx, serialNumber)
+	} else {
+		r0 = ret.Get(0).(models.Device)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, serialNumber)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// TransitionDevice provides a mock function with given fields: ctx, serialNumber, status, reason, version
+func (_m *Repository) TransitionDevice(ctx context.Context, serialNumber string, status string, reason string, version int64) (models.Device, error) {
+	ret := _m.Called(ctx, serialNumber, status, reason, version)
+
+	var r0 models.Device
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, int64) (models.Device, error)); ok {
+		return rf(ctx, serialNumber, status, reason, version)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, int64) models.Device); ok {
+		r0 = rf(ctx, serialNumber, status, reason, version)
+	} else {
+		r0 = ret.Get(0).(models.Device)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, int64) error); ok {
+		r1 = rf(ctx, serialNumber, status, reason, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateDevice provides a mock function with given fields: ctx, device
+func (_m *Repository) UpdateDevice(ctx context.Context, device models.Device) (models.Device, error) {
+	ret := _m.Called(ctx, device)
+
+	var r0 models.Device
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Device) (models.Device, error)); ok {
+		return rf(ctx, device)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.Device) models.Device); ok {
+		r0 = rf(ctx, device)
+	} else {
+		r0 = ret.Get(0).(models.Device)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.Device) error); ok {
+		r1 = rf(ctx, device)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateDevices provides a mock function with given fields: ctx, devices, mode
+func (_m *Repository) UpdateDevices(ctx context.Context, devices []models.Device, mode models.BatchMode) ([]error, error) {
+	ret := _m.Called(ctx, devices, mode)
+
+	var r0 []error
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []models.Device, models.BatchMode) ([]error, error)); ok {
+		return rf(ctx, devices, mode)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []models.Device, models.BatchMode) []error); ok {
+		r0 = rf(ctx, devices, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []models.Device, models.BatchMode) error); ok {
+		r1 = rf(ctx, devices, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewRepository creates a new instance of Repository. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewRepository(t interface {
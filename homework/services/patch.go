@@ -0,0 +1,278 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"homework/models"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to a JSON document.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%v :%w", err, models.ErrInvalidPatch)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergeValue(t[key], value)
+	}
+	return t
+}
+
+type jsonPatchOp struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// JSONPatch applies an RFC 6902 JSON Patch to a JSON document. A failed
+// "test" operation is reported as models.ErrPatchTestFailed, any other
+// problem with the patch as models.ErrInvalidPatch.
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	var root any
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%v :%w", err, models.ErrInvalidPatch)
+	}
+
+	for i, op := range ops {
+		var err error
+		root, err = applyOp(root, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func applyOp(root any, op jsonPatchOp) (any, error) {
+	if op.Path == nil {
+		return nil, invalidPatch("missing path")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, invalidPatch("missing value")
+		}
+		if err := json.Unmarshal(*op.Value, &value); err != nil {
+			return nil, invalidPatch(err.Error())
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, invalidPatch("missing from")
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = getValue(root, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			// The copy must not share maps or slices with its source.
+			b, _ := json.Marshal(value)
+			_ = json.Unmarshal(b, &value)
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+				return nil, invalidPatch("cannot move a value into itself")
+			}
+			if root, err = removeValue(root, from); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return addValue(root, path, value)
+	case "remove":
+		return removeValue(root, path)
+	case "replace":
+		if _, err := getValue(root, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		return update(root, path, func(container any, key string) (any, error) {
+			if m, ok := container.(map[string]any); ok {
+				m[key] = value
+				return m, nil
+			}
+			a := container.([]any)
+			i, _ := arrayIndex(a, key, false)
+			a[i] = value
+			return a, nil
+		})
+	case "test":
+		current, err := getValue(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%q does not hold the tested value :%w", *op.Path, models.ErrPatchTestFailed)
+		}
+		return root, nil
+	default:
+		return nil, invalidPatch(fmt.Sprintf("unknown op %q", op.Op))
+	}
+}
+
+func invalidPatch(message string) error {
+	return fmt.Errorf("%s :%w", message, models.ErrInvalidPatch)
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, invalidPatch(fmt.Sprintf("%q is not a JSON pointer", pointer))
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(a []any, token string, appending bool) (int, error) {
+	if appending && token == "-" {
+		return len(a), nil
+	}
+	i, err := strconv.Atoi(token)
+	limit := len(a)
+	if appending {
+		limit++
+	}
+	if err != nil || i < 0 || i >= limit || (len(token) > 1 && token[0] == '0') {
+		return 0, invalidPatch(fmt.Sprintf("index %q is out of range", token))
+	}
+	return i, nil
+}
+
+func getValue(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			value, ok := n[token]
+			if !ok {
+				return nil, invalidPatch(fmt.Sprintf("member %q does not exist", token))
+			}
+			node = value
+		case []any:
+			i, err := arrayIndex(n, token, false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, invalidPatch(fmt.Sprintf("cannot traverse into %q", token))
+		}
+	}
+	return node, nil
+}
+
+// update walks to the container holding the last token of path and replaces
+// it by what fn returns, returning the new root.
+func update(node any, path []string, fn func(container any, key string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		switch node.(type) {
+		case map[string]any, []any:
+			return fn(node, path[0])
+		default:
+			return nil, invalidPatch(fmt.Sprintf("cannot traverse into %q", path[0]))
+		}
+	}
+
+	child, err := getValue(node, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := node.(map[string]any); ok {
+		m[path[0]] = child
+		return m, nil
+	}
+	a := node.([]any)
+	i, _ := arrayIndex(a, path[0], false)
+	a[i] = child
+	return a, nil
+}
+
+func addValue(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(root, path, func(container any, key string) (any, error) {
+		if m, ok := container.(map[string]any); ok {
+			m[key] = value
+			return m, nil
+		}
+		a := container.([]any)
+		i, err := arrayIndex(a, key, true)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, nil)
+		copy(a[i+1:], a[i:])
+		a[i] = value
+		return a, nil
+	})
+}
+
+func removeValue(root any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, invalidPatch("cannot remove the whole document")
+	}
+	return update(root, path, func(container any, key string) (any, error) {
+		if m, ok := container.(map[string]any); ok {
+			if _, ok := m[key]; !ok {
+				return nil, invalidPatch(fmt.Sprintf("member %q does not exist", key))
+			}
+			delete(m, key)
+			return m, nil
+		}
+		a := container.([]any)
+		i, err := arrayIndex(a, key, false)
+		if err != nil {
+			return nil, err
+		}
+		return append(a[:i], a[i+1:]...), nil
+	})
+}
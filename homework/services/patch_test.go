@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	repoMock "homework/services/mocks"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		// Examples from RFC 7396, appendix A.
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		got, err := MergePatch([]byte(test.doc), []byte(test.patch))
+		assert.NoError(t, err)
+		assert.JSONEq(t, test.want, string(got), test.patch)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		// Examples from RFC 6902, appendix A.
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+	}
+	for _, test := range tests {
+		got, err := JSONPatch([]byte(test.doc), []byte(test.patch))
+		assert.NoError(t, err, test.patch)
+		assert.JSONEq(t, test.want, string(got), test.patch)
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		err   error
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, models.ErrPatchTestFailed},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, models.ErrInvalidPatch},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/5","value":"qux"}]`, models.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, models.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, models.ErrInvalidPatch},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar"}]`, models.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"frobnicate","path":"/foo"}]`, models.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"foo","value":1}]`, models.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `{"op":"add"}`, models.ErrInvalidPatch},
+	}
+	for _, test := range tests {
+		_, err := JSONPatch([]byte(test.doc), []byte(test.patch))
+		assert.ErrorIs(t, err, test.err, test.patch)
+	}
+}
+
+func TestPatchDevice(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	stored := models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1", IPv6: "2001:db8::1", Version: 4}
+	mockService.On("GetDevice", mock.Anything, "123").Return(stored, nil)
+	mockService.On("CompareAndSwapDevice", mock.Anything, models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.2", Version: 4}, int64(4)).
+		Return(models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.2", Version: 5}, nil)
+
+	usecase := NewService(mockService)
+
+	got, err := usecase.PatchDevice(context.Background(), "123", models.MergePatchType, []byte(`{"ip": "1.1.1.2", "ipv6": null}`), models.AnyVersion)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.2", Version: 5}, got)
+	mockService.AssertExpectations(t)
+}
+
+func TestPatchDeviceRetriesOnConcurrentUpdate(t *testing.T) {
+	mockService := new(repoMock.Repository)
+	mockService.On("GetDevice", mock.Anything, "123").Return(models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1", Version: 1}, nil).Once()
+	mockService.On("GetDevice", mock.Anything, "123").Return(models.Device{SerialNum: "123", Model: "model2", IP: "1.1.1.1", Version: 2}, nil).Once()
+	mockService.On("CompareAndSwapDevice", mock.Anything, mock.Anything, int64(1)).Return(models.Device{}, models.ErrVersionMismatch).Once()
+	// The patched device comes back with the times of the update.
+	patched := models.Device{SerialNum: "123", Model: "model2", IP: "1.1.1.3", Status: models.StatusActive, Version: 3, UpdatedAt: time.Now().UTC()}
+	mockService.On("CompareAndSwapDevice", mock.Anything, mock.Anything, int64(2)).Return(patched, nil).Once()
+
+	usecase := NewService(mockService)
+
+	got, err := usecase.PatchDevice(context.Background(), "123", models.JSONPatchType, []byte(`[{"op": "replace", "path": "/ip", "value": "1.1.1.3"}]`), models.AnyVersion)
+
+	assert.NoError(t, err)
+	assert.Equal(t, patched, got)
+	mockService.AssertExpectations(t)
+}
+
+func TestPatchDeviceRejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		patchType string
+		patch     string
+		version   int64
+		err       error
+	}{
+		{"stale version", models.MergePatchType, `{"ip": "1.1.1.2"}`, 3, models.ErrVersionMismatch},
+		{"unknown field", models.MergePatchType, `{"colour": "red"}`, models.AnyVersion, models.ErrInvalidPatch},
+		{"unsupported type", "text/plain", `ip=1.1.1.2`, models.AnyVersion, models.ErrUnsupportedPatch},
+	}
+	for _, test := range tests {
+		mockService := new(repoMock.Repository)
+		mockService.On("GetDevice", mock.Anything, "123").Return(models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1", Version: 4}, nil)
+
+		_, err := NewService(mockService).PatchDevice(context.Background(), "123", test.patchType, []byte(test.patch), test.version)
+
+		assert.ErrorIs(t, err, test.err, test.name)
+		mockService.AssertNotCalled(t, "CompareAndSwapDevice", mock.Anything, mock.Anything, mock.Anything)
+	}
+
+	mockService := new(repoMock.Repository)
+	mockService.On("GetDevice", mock.Anything, "123").Return(models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1", Version: 4}, nil)
+	_, err := NewService(mockService).PatchDevice(context.Background(), "123", models.MergePatchType, []byte(`{"ip": "1.1.1"}`), models.AnyVersion)
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestPatchDeviceRetriesUntilContextEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockService := new(repoMock.Repository)
+	mockService.On("GetDevice", mock.Anything, "123").Return(models.Device{SerialNum: "123", Model: "model1", IP: "1.1.1.1", Version: 1}, nil)
+	// Another writer wins every race, the patch goes on until its caller
+	// gives up rather than failing a precondition it never set.
+	calls := 0
+	mockService.On("CompareAndSwapDevice", mock.Anything, mock.Anything, int64(1)).Return(models.Device{}, models.ErrVersionMismatch).Run(func(mock.Arguments) {
+		if calls++; calls == 5 {
+			cancel()
+		}
+	})
+
+	_, err := NewService(mockService).PatchDevice(ctx, "123", models.MergePatchType, []byte(`{"model": "model2"}`), models.AnyVersion)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, models.ErrVersionMismatch)
+	mockService.AssertNumberOfCalls(t, "CompareAndSwapDevice", 5)
+}
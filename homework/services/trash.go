@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	"log"
+	"time"
+)
+
+func (u *Usercase) ListDeletedDevices(ctx context.Context) ([]models.DeletedDevice, error) {
+	return u.devices.ListDeletedDevices(ctx)
+}
+
+// RestoreDevice brings a deleted device back. It fails with
+// models.ErrAlredyExist when a new device took its serial number and with
+// models.ErrIPConflict when one took its addresses.
+func (u *Usercase) RestoreDevice(ctx context.Context, serialNumber string) (models.Device, error) {
+	return u.devices.RestoreDevice(ctx, serialNumber)
+}
+
+func (u *Usercase) PurgeDeletedDevices(ctx context.Context, before time.Time) ([]models.DeletedDevice, error) {
+	return u.devices.PurgeDeletedDevices(ctx, before)
+}
+
+// maxPurgeInterval bounds how long a deleted device outlives its retention.
+const maxPurgeInterval = time.Hour
+
+// TrashPurger purges the devices deleted more than retention ago, checking
+// every retention or every hour, whichever is shorter.
+type TrashPurger struct {
+	service   Service
+	retention time.Duration
+	now       func() time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewTrashPurger(service Service, retention time.Duration) *TrashPurger {
+	return &TrashPurger{
+		service:   service,
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+// Purger is the principal of the periodic purges.
+var Purger = models.Principal{Name: "retention", Role: models.RoleAdmin}
+
+// Purge drops the devices whose retention is over.
+func (p *TrashPurger) Purge(ctx context.Context) ([]models.DeletedDevice, error) {
+	return p.service.PurgeDeletedDevices(ctx, p.now().Add(-p.retention))
+}
+
+// Start purges once, then periodically until Stop, as Purger.
+func (p *TrashPurger) Start() {
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(WithPrincipal(context.Background(), Purger))
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(min(p.retention, maxPurgeInterval))
+		defer ticker.Stop()
+		for {
+			purged, err := p.Purge(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("purging the trash: %v", err)
+			} else if len(purged) > 0 {
+				log.Printf("Purged %d deleted devices", len(purged))
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic purges, interrupting the one in progress.
+func (p *TrashPurger) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+		<-p.done
+		p.cancel = nil
+	}
+}
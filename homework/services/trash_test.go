@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"homework/models"
+	"homework/repositories"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrashPurger(t *testing.T) {
+	usecase := NewService(repositories.NewRepoDevice())
+	require.NoError(t, usecase.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	require.NoError(t, usecase.DeleteDevice(context.Background(), "1"))
+
+	purger := NewTrashPurger(usecase, 24*time.Hour)
+	purged, err := purger.Purge(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+
+	purger.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	purged, err = purger.Purge(context.Background())
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	assert.Equal(t, "1", purged[0].SerialNum)
+
+	deleted, err := usecase.ListDeletedDevices(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+}
+
+func TestTrashPurgerStartStop(t *testing.T) {
+	usecase := NewService(repositories.NewRepoDevice())
+	require.NoError(t, usecase.CreateDevice(context.Background(), models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	require.NoError(t, usecase.DeleteDevice(context.Background(), "1"))
+
+	// Start purges right away.
+	purger := NewTrashPurger(usecase, time.Nanosecond)
+	purger.Start()
+	assert.Eventually(t, func() bool {
+		deleted, err := usecase.ListDeletedDevices(context.Background())
+		return err == nil && len(deleted) == 0
+	}, time.Second, time.Millisecond)
+	purger.Stop()
+	purger.Stop()
+}
+
+func TestAuditTrash(t *testing.T) {
+	log := repositories.NewMemoryAuditLog()
+	usecase := Audit(NewService(repositories.NewRepoDevice()), log)
+	ctx := WithPrincipal(context.Background(), models.Principal{Name: "ci", Role: models.RoleAdmin})
+
+	require.NoError(t, usecase.CreateDevice(ctx, models.Device{SerialNum: "1", Model: "m", IP: "10.0.0.1"}))
+	require.NoError(t, usecase.DeleteDevice(ctx, "1"))
+	restored, err := usecase.RestoreDevice(ctx, "1")
+	require.NoError(t, err)
+	require.NoError(t, usecase.DeleteDevice(ctx, "1"))
+	_, err = usecase.PurgeDeletedDevices(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	history, err := log.History("1")
+	require.NoError(t, err)
+	var actions []string
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{"create", "delete", "restore", "delete", "purge"}, actions)
+	assert.Equal(t, &restored, history[2].After)
+	assert.Equal(t, int64(2), history[4].Before.Version)
+	assert.Nil(t, history[4].After)
+}
@@ -0,0 +1,60 @@
+package services
+
+import "strings"
+
+const (
+	CodeRequired  = "required"
+	CodeInvalidIP = "invalid_ip"
+	CodeDualStack = "dual_stack"
+	CodeImmutable = "immutable"
+	CodeReserved  = "reserved"
+
+	CodeInvalidSerialNum = "invalid_serial_num"
+
+	CodeInvalidMAC      = "invalid_mac"
+	CodeInvalidHostname = "invalid_hostname"
+	CodeInvalidStatus   = "invalid_status"
+	CodeInvalidLabel    = "invalid_label"
+
+	CodeOutOfRange    = "out_of_range"
+	CodeConflict      = "conflict"
+	CodeInvalidCursor = "invalid_cursor"
+	CodeUnknownField  = "unknown_field"
+	CodeInvalidCIDR   = "invalid_cidr"
+	CodeInvalidMode   = "invalid_mode"
+	CodeInvalidURL    = "invalid_url"
+	CodeInvalidType   = "invalid_type"
+)
+
+// Violation describes a single invalid field of a request.
+type Violation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every violation found in a device instead of
+// stopping at the first one.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Add(field, code, message string) {
+	e.Violations = append(e.Violations, Violation{Field: field, Code: code, Message: message})
+}
+
+// Err returns nil when nothing was collected, so callers never get a typed nil.
+func (e *ValidationError) Err() error {
+	if len(e.Violations) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"homework/models"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of a webhook delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the secret of the webhook.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// minWebhookSecret is the shortest secret accepted, in bytes.
+const minWebhookSecret = 16
+
+// webhookQueue is the number of events a webhook can lag behind, later ones
+// are dropped until it catches up.
+const webhookQueue = 1024
+
+// RetryPolicy tells how a failed delivery is retried: up to Attempts tries,
+// waiting Initial after the first failure and twice as long after every next
+// one, at most Max. Every try gets Timeout.
+type RetryPolicy struct {
+	Attempts int
+	Initial  time.Duration
+	Max      time.Duration
+	Timeout  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{Attempts: 6, Initial: time.Second, Max: time.Minute, Timeout: 10 * time.Second}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Initial
+	for i := 1; i < attempt && wait < p.Max; i++ {
+		wait *= 2
+	}
+	return min(wait, p.Max)
+}
+
+// SignWebhook returns the signature of a delivery body sent at timestamp.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher delivers the events to the webhooks subscribed to them.
+// Every webhook has its own queue and worker, so a slow or failing receiver
+// delays nobody else. Deliveries of a webhook are made in order, each one
+// retried per the policy before the next one.
+type WebhookDispatcher struct {
+	client *http.Client
+	policy RetryPolicy
+	now    func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	hooks  map[string]*webhookWorker
+	wg     sync.WaitGroup
+}
+
+type webhookWorker struct {
+	webhook models.Webhook
+	queue   chan models.Event
+	stop    chan struct{}
+}
+
+func NewWebhookDispatcher(client *http.Client, policy RetryPolicy) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		client: client,
+		policy: policy,
+		now:    time.Now,
+		ctx:    ctx,
+		cancel: cancel,
+		hooks:  make(map[string]*webhookWorker),
+	}
+}
+
+// ValidateWebhook checks the URL and the event types of a webhook, and the
+// secret when it has one.
+func ValidateWebhook(w models.Webhook) error {
+	var verr ValidationError
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.Add("url", CodeInvalidURL, "url must be an absolute http or https URL")
+	}
+	if w.Secret != "" && len(w.Secret) < minWebhookSecret {
+		verr.Add("secret", CodeOutOfRange, fmt.Sprintf("secret must be at least %d bytes", minWebhookSecret))
+	}
+	for _, t := range w.Types {
+		if !slices.Contains(models.EventTypes, t) {
+			verr.Add("types", CodeInvalidType, fmt.Sprintf("%q is not an event type", t))
+		}
+	}
+	return verr.Err()
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Add subscribes a webhook and returns it with its ID, and with the secret
+// generated for it when it had none.
+func (d *WebhookDispatcher) Add(w models.Webhook) (models.Webhook, error) {
+	if err := ValidateWebhook(w); err != nil {
+		return w, err
+	}
+	w.ID = randomHex(8)
+	if w.Secret == "" {
+		w.Secret = randomHex(32)
+	}
+	worker := &webhookWorker{webhook: w, queue: make(chan models.Event, webhookQueue), stop: make(chan struct{})}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[w.ID] = worker
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.run(worker)
+	}()
+	return w, nil
+}
+
+// Remove unsubscribes a webhook, the delivery in progress is abandoned.
+func (d *WebhookDispatcher) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	worker, ok := d.hooks[id]
+	if !ok {
+		return fmt.Errorf("webhook %q :%w", id, models.ErrNotFound)
+	}
+	delete(d.hooks, id)
+	close(worker.stop)
+	return nil
+}
+
+// List returns the webhooks without their secrets.
+func (d *WebhookDispatcher) List() []models.Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	webhooks := make([]models.Webhook, 0, len(d.hooks))
+	for _, worker := range d.hooks {
+		w := worker.webhook
+		w.Secret = ""
+		webhooks = append(webhooks, w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].URL+webhooks[i].ID < webhooks[j].URL+webhooks[j].ID
+	})
+	return webhooks
+}
+
+// Deliver queues the events for the webhooks subscribed to them without
+// blocking, it is meant for EventBus.OnPublish.
+func (d *WebhookDispatcher) Deliver(events []models.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, worker := range d.hooks {
+		for _, event := range events {
+			if len(worker.webhook.Types) > 0 && !slices.Contains(worker.webhook.Types, event.Type) {
+				continue
+			}
+			select {
+			case worker.queue <- event:
+			default:
+				log.Printf("webhook %s: queue full, dropping event %d", worker.webhook.ID, event.ID)
+			}
+		}
+	}
+}
+
+// Close stops every worker, the queued events are not delivered.
+func (d *WebhookDispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *WebhookDispatcher) run(worker *webhookWorker) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-worker.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case event := <-worker.queue:
+			if err := d.deliver(ctx, worker.webhook, event); err != nil && ctx.Err() == nil {
+				log.Printf("webhook %s: giving up on event %d: %v", worker.webhook.ID, event.ID, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver posts the event until the receiver answers 2xx, the policy runs
+// out or the receiver rejects it with a 4xx other than 408 and 429.
+func (d *WebhookDispatcher) deliver(ctx context.Context, w models.Webhook, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(ctx, w, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= d.policy.Attempts {
+			return err
+		}
+		timer := time.NewTimer(d.policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, w models.Webhook, event models.Event, body []byte) (retry bool, err error) {
+	if d.policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.policy.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(event.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(w.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+}
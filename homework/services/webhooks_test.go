@@ -0,0 +1,146 @@
+package services
+
+import (
+	"encoding/json"
+	"homework/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testWebhookSecret = "0123456789abcdef0123"
+
+// receiver records the deliveries it accepts and answers the first ones with
+// the given statuses.
+type receiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	events   chan models.Event
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+	rcv := &receiver{t: t, statuses: statuses, events: make(chan models.Event, 16)}
+	srv := httptest.NewServer(rcv)
+	t.Cleanup(srv.Close)
+	return rcv, srv.URL
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(rcv.t, err)
+	timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	require.NoError(rcv.t, err)
+	assert.Equal(rcv.t, "sha256="+SignWebhook(testWebhookSecret, timestamp, body), r.Header.Get(WebhookSignatureHeader))
+
+	rcv.mu.Lock()
+	rcv.attempts++
+	status := http.StatusNoContent
+	if len(rcv.statuses) > 0 {
+		status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+	}
+	rcv.mu.Unlock()
+	if status < 300 {
+		var event models.Event
+		require.NoError(rcv.t, json.Unmarshal(body, &event))
+		assert.Equal(rcv.t, event.Type, r.Header.Get(WebhookEventHeader))
+		rcv.events <- event
+	}
+	w.WriteHeader(status)
+}
+
+func (rcv *receiver) next(t *testing.T) models.Event {
+	t.Helper()
+	select {
+	case event := <-rcv.events:
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no delivery")
+		return models.Event{}
+	}
+}
+
+func (rcv *receiver) attemptCount() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.attempts
+}
+
+var testRetryPolicy = RetryPolicy{Attempts: 3, Initial: time.Millisecond, Max: 5 * time.Millisecond, Timeout: time.Second}
+
+func TestWebhookDelivery(t *testing.T) {
+	rcv, url := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	dispatcher := NewWebhookDispatcher(http.DefaultClient, testRetryPolicy)
+	defer dispatcher.Close()
+	bus := NewEventBus(0)
+	bus.OnPublish(dispatcher.Deliver)
+
+	webhook, err := dispatcher.Add(models.Webhook{URL: url, Secret: testWebhookSecret, Types: []string{models.EventDeleted}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, webhook.ID)
+
+	bus.Publish(
+		models.Event{Type: models.EventCreated, SerialNum: "1"},
+		models.Event{Type: models.EventDeleted, SerialNum: "1"},
+	)
+	event := rcv.next(t)
+	assert.Equal(t, models.EventDeleted, event.Type)
+	assert.Equal(t, uint64(2), event.ID)
+	assert.Equal(t, 3, rcv.attemptCount())
+
+	assert.Equal(t, []models.Webhook{{ID: webhook.ID, URL: url, Types: []string{models.EventDeleted}}}, dispatcher.List())
+	require.NoError(t, dispatcher.Remove(webhook.ID))
+	assert.ErrorIs(t, dispatcher.Remove(webhook.ID), models.ErrNotFound)
+	assert.Empty(t, dispatcher.List())
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	rcv, url := newReceiver(t, http.StatusBadRequest, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	dispatcher := NewWebhookDispatcher(http.DefaultClient, testRetryPolicy)
+	defer dispatcher.Close()
+	_, err := dispatcher.Add(models.Webhook{URL: url, Secret: testWebhookSecret})
+	require.NoError(t, err)
+
+	// The rejected event is not retried, the failing one is retried until
+	// the policy runs out, and the next one goes through.
+	dispatcher.Deliver([]models.Event{
+		{ID: 1, Type: models.EventCreated},
+		{ID: 2, Type: models.EventCreated},
+		{ID: 3, Type: models.EventCreated},
+	})
+	assert.Equal(t, uint64(3), rcv.next(t).ID)
+	assert.Equal(t, 5, rcv.attemptCount())
+}
+
+func TestAddWebhookValidation(t *testing.T) {
+	dispatcher := NewWebhookDispatcher(http.DefaultClient, testRetryPolicy)
+	defer dispatcher.Close()
+
+	_, err := dispatcher.Add(models.Webhook{URL: "ftp://example.com", Secret: "short", Types: []string{"device.exploded"}})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	codes := make([]string, len(verr.Violations))
+	for i, v := range verr.Violations {
+		codes[i] = v.Code
+	}
+	assert.Equal(t, []string{CodeInvalidURL, CodeOutOfRange, CodeInvalidType}, codes)
+
+	webhook, err := dispatcher.Add(models.Webhook{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+	assert.Len(t, webhook.Secret, 64)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Initial: time.Second, Max: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, want, policy.backoff(attempt+1))
+	}
+}